@@ -0,0 +1,62 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// possibleCPUsPath lists the CPUs the kernel may ever bring online, whichever
+// of them taskset or a cgroup leave to psmgmt.
+var possibleCPUsPath = "/sys/devices/system/cpu/possible"
+
+// validateCPUs checks the cpus of a command at load. Every CPU must be one the
+// kernel knows of, if it tells; whether it is allowed is up to sched_setaffinity
+// when the process starts.
+func validateCPUs(list string) error {
+	cpus, err := parseCPUList(list)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(possibleCPUsPath)
+	if err != nil {
+		return nil
+	}
+	possible, err := parseCPUList(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil
+	}
+	for _, cpu := range cpus {
+		if !slices.Contains(possible, cpu) {
+			return fmt.Errorf("CPU %d does not exist, the possible CPUs are %s", cpu, strings.TrimSpace(string(content)))
+		}
+	}
+	return nil
+}
+
+// setAffinity pins the process with the given PID to the given CPUs using sched_setaffinity.
+// The mask is sized to the highest CPU, however many CPUs the machine has.
+func setAffinity(pid int, cpus []int) error {
+	if len(cpus) == 0 {
+		return nil
+	}
+	mask := make([]uint64, slices.Max(cpus)/64+1)
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, errno := syscall.RawSyscall(
+		syscall.SYS_SCHED_SETAFFINITY,
+		uintptr(pid),
+		uintptr(len(mask))*unsafe.Sizeof(mask[0]),
+		uintptr(unsafe.Pointer(&mask[0])),
+	)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateCPUs(t *testing.T) {
+	defer func(path string) { possibleCPUsPath = path }(possibleCPUsPath)
+	possibleCPUsPath = filepath.Join(t.TempDir(), "possible")
+	assert.NoError(t, os.WriteFile(possibleCPUsPath, []byte("0-3\n"), 0o644))
+
+	assert.NoError(t, validateCPUs("0,3"))
+	assert.EqualError(t, validateCPUs("2-4"), "CPU 4 does not exist, the possible CPUs are 0-3")
+	assert.EqualError(t, validateCPUs("a"), `invalid CPU "a" in "a"`)
+
+	// Without the list of possible CPUs sched_setaffinity has the last word
+	possibleCPUsPath = filepath.Join(t.TempDir(), "missing")
+	assert.NoError(t, validateCPUs("4096"))
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// validateCPUs reports an error when cpus is set, since CPU pinning is only implemented on Linux.
+func validateCPUs(list string) error {
+	if list != "" {
+		return errors.New("cpus is only supported on Linux")
+	}
+	return nil
+}
+
+// setAffinity reports an error, since CPU pinning is only implemented on Linux.
+func setAffinity(pid int, cpus []int) error {
+	return errors.New("CPU affinity is only supported on Linux")
+}
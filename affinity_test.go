@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := parseCPUList("0-2")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 1, 2}, cpus)
+
+	cpus, err = parseCPUList("0,3")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, 3}, cpus)
+
+	_, err = parseCPUList("3-1")
+	assert.Error(t, err)
+
+	_, err = parseCPUList("a")
+	assert.Error(t, err)
+
+	// Whether the CPUs exist is checked apart
+	cpus, err = parseCPUList("0-4096")
+	assert.NoError(t, err)
+	assert.Len(t, cpus, 4097)
+}
+
+func TestSetAffinity(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		assert.ErrorContains(t, validateCPUs("0"), "only supported on Linux")
+		assert.NoError(t, validateCPUs(""))
+		t.Skip("CPU affinity is only supported on Linux")
+	}
+
+	cmd := exec.Command("sleep", "5")
+	assert.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	// CPUs beyond the first 1024 don't overflow the mask, the kernel ignores
+	// the ones that don't exist
+	assert.NoError(t, setAffinity(cmd.Process.Pid, []int{0, 1100}))
+	assert.NoError(t, setAffinity(cmd.Process.Pid, []int{0}))
+}
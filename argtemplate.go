@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// runID identifies the run of psmgmt, the same for every app and across
+// reloads of the config.
+var runID = sync.OnceValue(func() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("error generating run ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+})
+
+// argsData is what the command and args of an app are rendered with.
+type argsData struct {
+	// Name is the name of the app.
+	Name string
+	// Index is the position of the app in the config, starting at 0.
+	Index int
+	// RunID is a random ID of the run of psmgmt.
+	RunID string
+	// Env is the environment the process is started with.
+	Env map[string]string
+}
+
+// expandArgs renders the command and args of the app at index in the config as
+// templates, like "--log=/var/log/{{.Name}}-{{.RunID}}.log", if the app sets
+// template. Other apps are returned as they are, so that arguments like
+// docker's "--format={{.ID}}" reach the process untouched.
+func expandArgs(command Command, index int) (Command, error) {
+	if !command.Template {
+		return command, nil
+	}
+	env := commandEnv(command)
+	if env == nil {
+		env = os.Environ()
+	}
+	data := argsData{Name: command.Name, Index: index, RunID: runID(), Env: make(map[string]string, len(env))}
+	for _, entry := range env {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			data.Env[key] = value
+		}
+	}
+
+	expand := func(field string, text string) (string, error) {
+		tmpl, err := template.New(field).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s template: %w", field, err)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", fmt.Errorf("error rendering %s template: %w", field, err)
+		}
+		return b.String(), nil
+	}
+
+	name, err := expand("command", command.Command)
+	if err != nil {
+		return command, err
+	}
+	args := append([]string(nil), command.Args...)
+	for i, arg := range args {
+		if args[i], err = expand(fmt.Sprintf("args[%d]", i), arg); err != nil {
+			return command, err
+		}
+	}
+	command.Command, command.Args = name, args
+	return command, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandArgs(t *testing.T) {
+	t.Setenv("REGION", "eu")
+	command := Command{
+		Name:     "web",
+		Command:  "./{{.Name}}",
+		Args:     []string{"--log=/var/log/{{.Name}}-{{.Index}}.log", "--run={{.RunID}}", "--region={{.Env.REGION}}", "--port={{.Env.PORT}}"},
+		Env:      map[string]string{"PORT": "8080"},
+		Template: true,
+	}
+	expanded, err := expandArgs(command, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "./web", expanded.Command)
+	assert.Equal(t, []string{"--log=/var/log/web-2.log", "--run=" + runID(), "--region=eu", "--port=8080"}, expanded.Args)
+	assert.Len(t, runID(), 16)
+	assert.Equal(t, []string{"--log=/var/log/{{.Name}}-{{.Index}}.log", "--run={{.RunID}}", "--region={{.Env.REGION}}", "--port={{.Env.PORT}}"}, command.Args)
+
+	_, err = expandArgs(Command{Name: "web", Command: "./web", Args: []string{"--ok", "{{.Name"}, Template: true}, 0)
+	assert.ErrorContains(t, err, "invalid args[1] template:")
+	_, err = expandArgs(Command{Name: "web", Command: "./web", Args: []string{"{{.Env.MISSING}}"}, Template: true}, 0)
+	assert.ErrorContains(t, err, `error rendering args[0] template:`)
+
+	// Braces are literal in apps that don't opt in, and can be escaped in the ones that do
+	literal := Command{Name: "ps", Command: "docker", Args: []string{"ps", "--format={{.ID}} {{.Names"}}
+	expanded, err = expandArgs(literal, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, literal.Args, expanded.Args)
+	expanded, err = expandArgs(Command{Name: "ps", Command: "docker", Args: []string{`--format={{"{{"}}.ID}} of {{.Name}}`}, Template: true}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--format={{.ID}} of ps"}, expanded.Args)
+}
+
+func TestExecuteExpandedArgs(t *testing.T) {
+	_, err := parseConfig(strings.NewReader("version: \"1\"\napps:\n  - name: web\n    command: echo\n    args: [\"{{.Nme}}\"]\n    template: true\n"))
+	assert.ErrorContains(t, err, `apps[0] "web": error rendering args[0] template:`)
+
+	config, err := parseConfig(strings.NewReader("version: \"1\"\napps:\n  - name: db\n    command: \"true\"\n  - name: web\n    command: echo\n    args: [\"{{.Name}} at {{.Index}}\"]\n    template: true\n"))
+	assert.NoError(t, err)
+	outputChan := make(chan Message, 10)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, config.Apps[1])
+	var stdout []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputStdout {
+			stdout = append(stdout, message.Content)
+		}
+	})
+	assert.Equal(t, []string{"web at 1"}, stdout)
+}
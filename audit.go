@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditKeyEnv is the environment variable holding the audit log key when no key file is given.
+const auditKeyEnv = "PSMGMT_AUDIT_KEY"
+
+// auditRecord is the JSON representation of a message in the audit log.
+type auditRecord struct {
+	Seq     uint64    `json:"seq"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Type    string    `json:"type"`
+	Content string    `json:"content,omitempty"`
+	Pid     int       `json:"pid,omitempty"`
+	Error   bool      `json:"error,omitempty"`
+	// ExitCode is only set for OutputEnd records, where 0 is meaningful.
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// newAuditRecord returns the record of the message, at its timestamp if it has
+// one and at now otherwise.
+func newAuditRecord(message Message, now time.Time) auditRecord {
+	if !message.Timestamp.IsZero() {
+		now = message.Timestamp
+	}
+	record := auditRecord{
+		Seq:     message.Seq,
+		Time:    now,
+		Command: message.CommandName(),
+		Type:    message.Type.Name(),
+		Content: message.Content,
+		Pid:     message.Pid,
+		Error:   message.IsError,
+	}
+	if message.Type == OutputEnd {
+		exitCode := message.ExitCode
+		record.ExitCode = &exitCode
+	}
+	return record
+}
+
+// auditLog is a Sink appending every message to a file as newline-delimited JSON.
+// When a key is given, every line is encrypted on its own with AES-GCM and stored as
+// base64(nonce || ciphertext), so that any part of the file can be decrypted by itself.
+// Lines are buffered and only written to the file in batches, a batch at once.
+type auditLog struct {
+	file    *os.File
+	aead    cipher.AEAD
+	clock   Clock
+	batcher *batcher
+	// raw records output lines before the replace rules of their command.
+	raw bool
+
+	mu      sync.Mutex
+	buffer  bytes.Buffer
+	pending int
+}
+
+// newAuditLog opens the audit log at path for appending. If key is nil the records
+// are written in plain text.
+func newAuditLog(path string, key []byte, clock Clock, batching Batching) (*auditLog, error) {
+	var aead cipher.AEAD
+	if key != nil {
+		var err error
+		aead, err = newAuditCipher(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log: %w", err)
+	}
+	audit := &auditLog{file: file, aead: aead, clock: clock}
+	audit.batcher = startBatcher(batching, clock, audit.flush)
+	return audit, nil
+}
+
+// Write appends the message to the audit log as a single line.
+func (a *auditLog) Write(message Message) error {
+	record := newAuditRecord(message, a.clock.Now())
+	if a.raw && message.Raw != "" {
+		record.Content = message.Raw
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if a.aead != nil {
+		nonce := make([]byte, a.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		sealed := a.aead.Seal(nonce, nonce, line, nil)
+		line = []byte(base64.StdEncoding.EncodeToString(sealed))
+	}
+
+	a.mu.Lock()
+	a.buffer.Write(append(line, '\n'))
+	a.pending++
+	pending := a.pending
+	a.mu.Unlock()
+	a.batcher.added(pending)
+	return nil
+}
+
+// flush writes the buffered lines to the file.
+func (a *auditLog) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.pending = 0
+	if a.buffer.Len() == 0 {
+		return
+	}
+	_, err := a.file.Write(a.buffer.Bytes())
+	a.buffer.Reset()
+	if err != nil {
+		logSystem("SystemError", "error writing audit log: %v", err)
+	}
+}
+
+// Close writes the lines that are left and closes the audit log file.
+func (a *auditLog) Close() error {
+	a.batcher.stop()
+	return a.file.Close()
+}
+
+// newAuditCipher returns the AES-GCM cipher for the given 16, 24 or 32 byte key.
+func newAuditCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit log key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadAuditKey returns the base64 encoded audit log key read from the file at path,
+// or taken from PSMGMT_AUDIT_KEY if path is empty. It returns nil if neither is set.
+func loadAuditKey(path string) ([]byte, error) {
+	encoded := os.Getenv(auditKeyEnv)
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading audit key file: %w", err)
+		}
+		encoded = string(content)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding audit log key: %w", err)
+	}
+	return key, nil
+}
+
+// decryptAuditLog decrypts every line of an encrypted audit log read from r
+// and writes the plain JSON records to w.
+func decryptAuditLog(r io.Reader, w io.Writer, key []byte) error {
+	aead, err := newAuditCipher(key)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		sealed, err := base64.StdEncoding.DecodeString(scanner.Text())
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return fmt.Errorf("line %d is not an encrypted record", line)
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		record, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return fmt.Errorf("error decrypting line %d: %w", line, err)
+		}
+		if _, err := w.Write(append(record, '\n')); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// runDecrypt implements the decrypt subcommand, which prints the decrypted
+// records of the audit logs given as arguments, or of stdin if there are none.
+func runDecrypt(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	keyFile := flags.String("audit-key-file", "", "read the base64 encoded key from `file` instead of $"+auditKeyEnv)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	key, err := loadAuditKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return fmt.Errorf("no key given, use -audit-key-file or $%s", auditKeyEnv)
+	}
+
+	if flags.NArg() == 0 {
+		return decryptAuditLog(os.Stdin, os.Stdout, key)
+	}
+	var errs []error
+	for _, path := range flags.Args() {
+		file, err := os.Open(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := decryptAuditLog(file, os.Stdout, key); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+		file.Close()
+	}
+	return errors.Join(errs...)
+}
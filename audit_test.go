@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLogEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	audit, err := newAuditLog(path, key, realClock{}, Batching{Size: defaultBatchSize, Interval: defaultFlushInterval})
+	assert.NoError(t, err)
+	web := &Command{Name: "web"}
+	assert.NoError(t, audit.Write(Message{Type: OutputStdout, Content: "secret token", Command: web}))
+	assert.NoError(t, audit.Write(Message{Type: OutputEnd, Command: web, ExitCode: 3}))
+	assert.NoError(t, audit.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "secret token")
+
+	// Every line can be decrypted on its own
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	assert.Len(t, lines, 2)
+	var plain bytes.Buffer
+	assert.NoError(t, decryptAuditLog(strings.NewReader(lines[1]+"\n"), &plain, key))
+	var record auditRecord
+	assert.NoError(t, json.Unmarshal(plain.Bytes(), &record))
+	assert.Equal(t, "web", record.Command)
+	assert.Equal(t, "OutputEnd", record.Type)
+	assert.Equal(t, 3, *record.ExitCode)
+
+	plain.Reset()
+	assert.NoError(t, decryptAuditLog(bytes.NewReader(content), &plain, key))
+	assert.Contains(t, plain.String(), `"content":"secret token"`)
+
+	wrongKey := bytes.Repeat([]byte{8}, 32)
+	assert.ErrorContains(t, decryptAuditLog(bytes.NewReader(content), &plain, wrongKey), "error decrypting line 1")
+}
+
+func TestLoadAuditKey(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 16)
+	t.Setenv(auditKeyEnv, base64.StdEncoding.EncodeToString(key))
+
+	loaded, err := loadAuditKey("")
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	keyFile := filepath.Join(t.TempDir(), "audit.key")
+	other := bytes.Repeat([]byte{2}, 32)
+	assert.NoError(t, os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(other)+"\n"), 0o600))
+	loaded, err = loadAuditKey(keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, other, loaded)
+
+	t.Setenv(auditKeyEnv, "")
+	loaded, err = loadAuditKey("")
+	assert.NoError(t, err)
+	assert.Nil(t, loaded)
+}
+
+func TestAuditRecordTimestamp(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	produced := now.Add(-time.Second)
+	assert.Equal(t, produced, newAuditRecord(Message{Type: OutputStdout, Timestamp: produced}, now).Time)
+	assert.Equal(t, now, newAuditRecord(Message{Type: OutputStdout}, now).Time)
+}
+
+func TestAuditLogBatching(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := newAuditLog(path, nil, NewFakeClock(time.Now()), Batching{Size: 3, Interval: time.Hour})
+	assert.NoError(t, err)
+
+	// Nothing is written before the batch is full, however long the lines
+	web := &Command{Name: "web"}
+	long := strings.Repeat("x", 8192)
+	assert.NoError(t, audit.Write(Message{Type: OutputStdout, Content: long, Command: web}))
+	assert.NoError(t, audit.Write(Message{Type: OutputStdout, Content: long, Command: web}))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Empty(t, content)
+
+	assert.NoError(t, audit.Close())
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+}
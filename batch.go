@@ -0,0 +1,78 @@
+package main
+
+import "time"
+
+const (
+	// defaultBatchSize is the number of messages after which a sink flushes early.
+	defaultBatchSize = 100
+	// defaultFlushInterval is the longest a message waits in a sink before being flushed.
+	defaultFlushInterval = time.Second
+)
+
+// Batching configures how sinks accumulate messages before writing them out:
+// every Size messages or every Interval, whichever comes first.
+type Batching struct {
+	Size     int
+	Interval time.Duration
+}
+
+// batcher calls flush whenever a batch is full or the flush interval passed,
+// and a final time when it is stopped. Sinks add to their batch themselves and
+// report its size with added.
+type batcher struct {
+	batching Batching
+	clock    Clock
+	flush    func()
+
+	full    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// startBatcher starts flushing with flush according to batching.
+func startBatcher(batching Batching, clock Clock, flush func()) *batcher {
+	b := &batcher{
+		batching: batching,
+		clock:    clock,
+		flush:    flush,
+		full:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// added reports the number of messages pending in the batch, flushing it early
+// once it is full.
+func (b *batcher) added(pending int) {
+	if pending < b.batching.Size {
+		return
+	}
+	select {
+	case b.full <- struct{}{}:
+	default:
+	}
+}
+
+// stop flushes what is left and waits for it.
+func (b *batcher) stop() {
+	close(b.done)
+	<-b.stopped
+}
+
+// run flushes whenever the batch is full or the flush interval passed.
+func (b *batcher) run() {
+	defer close(b.stopped)
+	for {
+		select {
+		case <-b.done:
+			b.flush()
+			return
+		case <-b.full:
+			b.flush()
+		case <-b.clock.After(b.batching.Interval):
+			b.flush()
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var flushes atomic.Int32
+	b := startBatcher(Batching{Size: 3, Interval: time.Second}, clock, func() { flushes.Add(1) })
+
+	// A batch that is not full waits for the interval
+	b.added(2)
+	assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	assert.Zero(t, flushes.Load())
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return flushes.Load() == 1 }, time.Second, time.Millisecond)
+
+	// A full batch is flushed right away
+	b.added(3)
+	assert.Eventually(t, func() bool { return flushes.Load() == 2 }, time.Second, time.Millisecond)
+
+	// Stopping flushes a last time
+	b.stop()
+	assert.Equal(t, int32(3), flushes.Load())
+}
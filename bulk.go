@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of the bulk sink.
+const (
+	bulkFormatLoki          = "loki"
+	bulkFormatElasticsearch = "elasticsearch"
+)
+
+// bulkMaxPending bounds the messages a bulk sink holds while its backend is slow
+// or down. Messages beyond it are dropped, so that the commands are never held up.
+const bulkMaxPending = 10000
+
+// bulkSink is a Sink posting the messages in batches to a log backend, using
+// the push API of Loki or the bulk API of Elasticsearch. Every message is sent
+// as its audit log record, labeled with the command's labels.
+type bulkSink struct {
+	url     string
+	format  string
+	index   string
+	client  *http.Client
+	clock   Clock
+	batcher *batcher
+
+	mu      sync.Mutex
+	pending []bulkDocument
+	dropped int
+}
+
+// bulkDocument is a message as it is sent to the backend.
+type bulkDocument struct {
+	auditRecord
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// newBulkSink returns a sink posting to the backend at url, like
+// "http://localhost:3100" for Loki. Elasticsearch documents are indexed in index.
+func newBulkSink(url string, format string, index string, clock Clock, batching Batching) (*bulkSink, error) {
+	sink := &bulkSink{
+		format: format,
+		index:  index,
+		client: &http.Client{Timeout: 10 * time.Second},
+		clock:  clock,
+	}
+	switch format {
+	case bulkFormatLoki:
+		sink.url = strings.TrimSuffix(url, "/") + "/loki/api/v1/push"
+	case bulkFormatElasticsearch:
+		sink.url = strings.TrimSuffix(url, "/") + "/_bulk"
+	default:
+		return nil, fmt.Errorf("unknown bulk format %q, expected %q or %q", format, bulkFormatLoki, bulkFormatElasticsearch)
+	}
+	sink.batcher = startBatcher(batching, clock, sink.flush)
+	return sink, nil
+}
+
+// bulkLabels returns the labels of the message: the labels of its command and
+// the name of the command.
+func bulkLabels(message Message) map[string]string {
+	labels := map[string]string{}
+	if message.Command != nil {
+		for key, value := range message.Command.Labels {
+			labels[key] = value
+		}
+	}
+	labels["command"] = message.CommandName()
+	return labels
+}
+
+// Write adds the message to the current batch, or drops it if the backend fell
+// too far behind.
+func (s *bulkSink) Write(message Message) error {
+	document := bulkDocument{auditRecord: newAuditRecord(message, s.clock.Now()), Labels: bulkLabels(message)}
+
+	s.mu.Lock()
+	if len(s.pending) >= bulkMaxPending {
+		s.dropped++
+		s.mu.Unlock()
+		return nil
+	}
+	s.pending = append(s.pending, document)
+	pending := len(s.pending)
+	s.mu.Unlock()
+	s.batcher.added(pending)
+	return nil
+}
+
+// Close sends the messages that are left and stops the sink.
+func (s *bulkSink) Close() error {
+	s.batcher.stop()
+	return nil
+}
+
+// flush sends the current batch in the format of the backend.
+func (s *bulkSink) flush() {
+	s.mu.Lock()
+	documents, dropped := s.pending, s.dropped
+	s.pending, s.dropped = nil, 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		logSystem("SystemError", "dropped %d messages, the %s endpoint fell behind", dropped, s.format)
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	var err error
+	if s.format == bulkFormatLoki {
+		err = s.sendLoki(documents)
+	} else {
+		err = s.sendElasticsearch(documents)
+	}
+	if err != nil {
+		logSystem("SystemError", "error sending messages to %s: %v", s.format, err)
+	}
+}
+
+// sendLoki pushes the documents as log lines of a stream per label set. Loki
+// accepts or rejects a push as a whole.
+func (s *bulkSink) sendLoki(documents []bulkDocument) error {
+	streams := make(map[string]*lokiStream)
+	var keys []string
+	for _, document := range documents {
+		key := labelsKey(document.Labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: document.Labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		line, err := json.Marshal(document.auditRecord)
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(document.Time.UnixNano(), 10)
+		stream.Values = append(stream.Values, [2]string{timestamp, string(line)})
+	}
+	var push lokiPush
+	for _, key := range keys {
+		push.Streams = append(push.Streams, *streams[key])
+	}
+	body, err := json.Marshal(push)
+	if err != nil {
+		return err
+	}
+
+	return sendWithRetries(s.clock, func() (bool, error) {
+		_, retry, err := postBatch(s.client, s.url, "application/json", body)
+		return retry, err
+	})
+}
+
+// sendElasticsearch indexes the documents with the bulk API. Elasticsearch
+// reports the documents it failed to index one by one, so only the ones that
+// failed transiently are sent again.
+func (s *bulkSink) sendElasticsearch(documents []bulkDocument) error {
+	action, err := json.Marshal(map[string]map[string]string{"create": {"_index": s.index}})
+	if err != nil {
+		return err
+	}
+
+	return sendWithRetries(s.clock, func() (bool, error) {
+		var body bytes.Buffer
+		for _, document := range documents {
+			source, err := json.Marshal(document)
+			if err != nil {
+				return false, err
+			}
+			body.Write(action)
+			body.WriteByte('\n')
+			body.Write(source)
+			body.WriteByte('\n')
+		}
+		content, retry, err := postBatch(s.client, s.url, "application/x-ndjson", body.Bytes())
+		if err != nil {
+			return retry, err
+		}
+
+		var response elasticsearchResponse
+		if err := json.Unmarshal(content, &response); err != nil {
+			return false, fmt.Errorf("error decoding bulk response: %w", err)
+		}
+		if !response.Errors {
+			return false, nil
+		}
+		var retryable []bulkDocument
+		rejected := 0
+		for i, item := range response.Items {
+			for _, result := range item {
+				if result.Status < 300 || i >= len(documents) {
+					continue
+				}
+				if retryableStatus(result.Status) {
+					retryable = append(retryable, documents[i])
+				} else {
+					rejected++
+				}
+			}
+		}
+		if rejected > 0 {
+			logSystem("SystemError", "elasticsearch rejected %d messages", rejected)
+		}
+		documents = retryable
+		if len(documents) == 0 {
+			return false, nil
+		}
+		return true, fmt.Errorf("%d messages failed transiently", len(documents))
+	})
+}
+
+// labelsKey returns a key identifying the label set.
+func labelsKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, strconv.Quote(key)+"="+strconv.Quote(value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// The request of the Loki push API and the response of the Elasticsearch bulk
+// API, limited to the fields psmgmt uses.
+type (
+	lokiPush struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	lokiStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	elasticsearchResponse struct {
+		Errors bool                                 `json:"errors"`
+		Items  []map[string]elasticsearchItemResult `json:"items"`
+	}
+	elasticsearchItemResult struct {
+		Status int `json:"status"`
+	}
+)
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkSinkLoki(t *testing.T) {
+	var mu sync.Mutex
+	var pushes []lokiPush
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "/loki/api/v1/push", r.URL.Path)
+		var push lokiPush
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&push))
+		pushes = append(pushes, push)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := newBulkSink(server.URL, bulkFormatLoki, "", realClock{}, Batching{Size: defaultBatchSize, Interval: defaultFlushInterval})
+	assert.NoError(t, err)
+	web := &Command{Name: "web", Labels: map[string]string{"team": "payments"}}
+	worker := &Command{Name: "worker"}
+	assert.NoError(t, sink.Write(Message{Content: "listening", Type: OutputStdout, Command: web}))
+	assert.NoError(t, sink.Write(Message{Content: "working", Type: OutputStdout, Command: worker}))
+	assert.NoError(t, sink.Write(Message{Content: "boom", Type: OutputStderr, Command: web, IsError: true}))
+	assert.NoError(t, sink.Close())
+
+	assert.Len(t, pushes, 1)
+	streams := pushes[0].Streams
+	assert.Len(t, streams, 2)
+	assert.Equal(t, map[string]string{"command": "web", "team": "payments"}, streams[0].Stream)
+	assert.Len(t, streams[0].Values, 2)
+	assert.Equal(t, map[string]string{"command": "worker"}, streams[1].Stream)
+
+	var record auditRecord
+	assert.NoError(t, json.Unmarshal([]byte(streams[0].Values[1][1]), &record))
+	assert.Equal(t, "boom", record.Content)
+	assert.True(t, record.Error)
+}
+
+func TestBulkSinkElasticsearch(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+
+		var contents []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			assert.JSONEq(t, `{"create":{"_index":"logs"}}`, scanner.Text())
+			assert.True(t, scanner.Scan())
+			var document bulkDocument
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &document))
+			assert.Equal(t, "web", document.Labels["command"])
+			contents = append(contents, document.Content)
+		}
+		batches = append(batches, contents)
+
+		// The first batch partially fails: the second message transiently and
+		// the third for good
+		statuses := []int{201, 429, 400}
+		if len(batches) > 1 {
+			statuses = []int{201}
+		}
+		response := elasticsearchResponse{}
+		for _, status := range statuses {
+			response.Errors = response.Errors || status >= 300
+			response.Items = append(response.Items, map[string]elasticsearchItemResult{"create": {Status: status}})
+		}
+		assert.NoError(t, json.NewEncoder(w).Encode(response))
+	}))
+	defer server.Close()
+
+	sink, err := newBulkSink(server.URL, bulkFormatElasticsearch, "logs", realClock{}, Batching{Size: defaultBatchSize, Interval: defaultFlushInterval})
+	assert.NoError(t, err)
+	web := &Command{Name: "web"}
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, sink.Write(Message{Content: fmt.Sprintf("line %d", i), Type: OutputStdout, Command: web}))
+	}
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, [][]string{{"line 1", "line 2", "line 3"}, {"line 2"}}, batches)
+}
+
+func TestBulkSinkUnknownFormat(t *testing.T) {
+	_, err := newBulkSink("http://localhost:9200", "splunk", "", realClock{}, Batching{Size: 1})
+	assert.Error(t, err)
+}
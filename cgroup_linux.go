@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultCgroupParent is the cgroup directory used when CgroupConfig.Parent is empty.
+const defaultCgroupParent = "/sys/fs/cgroup/psmgmt"
+
+// invalidCgroupChars matches the characters that are replaced in cgroup names.
+var invalidCgroupChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// setupCgroup creates the cgroup configured for the command, applies its limits
+// and arranges for cmd to be started inside it.
+// The returned function removes the cgroup and must be called once the process has exited.
+func setupCgroup(cmd *exec.Cmd, command Command) (func() error, error) {
+	if command.Cgroup == nil {
+		return func() error { return nil }, nil
+	}
+
+	parent := command.Cgroup.Parent
+	if parent == "" {
+		parent = defaultCgroupParent
+	}
+
+	// Enable the controllers needed by the limits in the parent group
+	var controllers []string
+	if command.Cgroup.CPUMax != "" {
+		controllers = append(controllers, "+cpu")
+	}
+	if command.Cgroup.MemoryMax != "" {
+		controllers = append(controllers, "+memory")
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating parent cgroup: %w", err)
+	}
+	if len(controllers) > 0 {
+		err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("error enabling controllers %v: %w", controllers, err)
+		}
+	}
+
+	// Create the command's own group and apply the limits
+	dir := filepath.Join(parent, cgroupName(command.Name))
+	if err := os.Mkdir(dir, 0o755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("error creating cgroup: %w", err)
+	}
+	limits := map[string]string{
+		"cpu.max":    command.Cgroup.CPUMax,
+		"memory.max": command.Cgroup.MemoryMax,
+	}
+	for file, value := range limits {
+		if value == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0o644); err != nil {
+			os.Remove(dir)
+			return nil, fmt.Errorf("error writing %s: %w", file, err)
+		}
+	}
+
+	// Let the kernel start the process directly inside the group
+	fd, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return nil, fmt.Errorf("error opening cgroup: %w", err)
+	}
+	attr := sysProcAttr(cmd)
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(fd.Fd())
+
+	return func() error {
+		fd.Close()
+		return os.Remove(dir)
+	}, nil
+}
+
+// cgroupName returns a cgroup directory name derived from the command name.
+func cgroupName(name string) string {
+	name = invalidCgroupChars.ReplaceAllString(name, "_")
+	if name == "" {
+		return "command"
+	}
+	return name
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// setupCgroup reports an error when a cgroup is requested, since cgroups only exist on Linux.
+func setupCgroup(cmd *exec.Cmd, command Command) (func() error, error) {
+	if command.Cgroup != nil {
+		return nil, errors.New("cgroups are only supported on Linux")
+	}
+	return func() error { return nil }, nil
+}
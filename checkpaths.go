@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// checkConfigPaths returns a problem for every file, directory or executable the
+// config refers to that doesn't exist or isn't accessible, so that they can all be
+// reported at once before anything runs.
+func checkConfigPaths(config *Config) []error {
+	var problems []error
+	checkDir := func(prefix string, setting string, dir string) {
+		info, err := os.Stat(dir)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", dir)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s%s: %w", prefix, setting, err))
+		}
+	}
+	checkExecutable := func(prefix string, setting string, name string, dirs []string) {
+		var err error
+		if len(dirs) > 0 {
+			_, err = lookPath(name, dirs)
+		} else {
+			_, err = exec.LookPath(name)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s%s: %w", prefix, setting, err))
+		}
+	}
+
+	for _, dir := range config.Path {
+		checkDir("", "path", dir)
+	}
+	for i, command := range config.Apps {
+		prefix := fmt.Sprintf("apps[%d] %q: ", i, command.Name)
+		for _, dir := range command.Path {
+			checkDir(prefix, "path", dir)
+		}
+		if command.Builtin == "" {
+			// Remote commands only need psmgmt itself locally, containers the docker client
+			name, _ := commandLine(command)
+			checkExecutable(prefix, "command", name, command.Path)
+		}
+		if len(command.PipeThrough) > 0 {
+			checkExecutable(prefix, "pipe_through", command.PipeThrough[0], command.Path)
+		}
+		if len(command.OnRestart) > 0 {
+			checkExecutable(prefix, "on_restart", command.OnRestart[0], command.Path)
+		}
+		if command.ReadinessProbe != nil && len(command.ReadinessProbe.Exec) > 0 {
+			checkExecutable(prefix, "readiness_probe", command.ReadinessProbe.Exec[0], command.Path)
+		}
+		if len(command.OnCrash) > 0 {
+			checkExecutable(prefix, "on_crash", command.OnCrash[0], command.Path)
+		}
+		if command.OutputFile != "" {
+			checkDir(prefix, "output_file", filepath.Dir(command.OutputFile))
+		}
+		if command.LockFile != "" {
+			checkDir(prefix, "lock_file", filepath.Dir(command.LockFile))
+		}
+		if command.Cgroup != nil && command.Cgroup.Parent != "" {
+			checkDir(prefix, "cgroup parent", command.Cgroup.Parent)
+		}
+	}
+	return problems
+}
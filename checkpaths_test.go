@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConfigPaths(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	config := &Config{
+		Path: []string{missing},
+		Apps: []Command{
+			{Name: "ok", Command: "sh", Path: []string{"/bin", "/usr/bin"}, OutputFile: filepath.Join(dir, "out.log")},
+			{Name: "keepalive", Builtin: builtinKeepalive},
+			{Name: "broken", Command: "no-such-binary", PipeThrough: []string{"no-such-transform"}, OutputFile: filepath.Join(missing, "out.log")},
+		},
+	}
+
+	problems := checkConfigPaths(config)
+	assert.Len(t, problems, 4)
+	assert.Contains(t, problems[0].Error(), "path: stat "+missing)
+	assert.Contains(t, problems[1].Error(), `apps[2] "broken": command: exec: "no-such-binary"`)
+	assert.Contains(t, problems[2].Error(), `apps[2] "broken": pipe_through: exec: "no-such-transform"`)
+	assert.Contains(t, problems[3].Error(), `apps[2] "broken": output_file: stat `+missing)
+}
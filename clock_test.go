@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	short := clock.After(time.Second)
+	long := clock.After(time.Minute)
+	assert.Equal(t, 2, clock.Waiters())
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-short)
+	assert.Equal(t, start.Add(2*time.Second), clock.Now())
+	assert.Equal(t, 1, clock.Waiters())
+	select {
+	case <-long:
+		t.Fatal("timer fired early")
+	default:
+	}
+
+	slept := make(chan struct{})
+	go func() {
+		clock.Sleep(time.Second)
+		close(slept)
+	}()
+	assert.Eventually(t, func() bool { return clock.Waiters() == 2 }, time.Second, time.Millisecond)
+	clock.Advance(time.Minute)
+	<-slept
+	<-long
+	assert.Zero(t, clock.Waiters())
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errAllEnded is returned when starting an app once every app ended, after
+// which psmgmt exits.
+var errAllEnded = errors.New("all apps ended, psmgmt is exiting")
+
+// lifecycles counts the commands whose OutputEnd is still to come and holds the
+// context every app runs with, so that single apps can be stopped and started
+// again while the others keep running.
+type lifecycles struct {
+	mu      sync.Mutex
+	pending int
+	cancels map[string]context.CancelFunc
+}
+
+// newLifecycles returns the lifecycles of pending commands to come.
+func newLifecycles(pending int) *lifecycles {
+	return &lifecycles{pending: pending, cancels: make(map[string]context.CancelFunc)}
+}
+
+// start returns the context the named app runs with, derived from ctx, which
+// stop cancels. An app started again adds to the OutputEnd messages to come,
+// which fails with errAllEnded once they all arrived.
+func (l *lifecycles) start(ctx context.Context, name string, again bool) (context.Context, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if again {
+		if l.pending == 0 {
+			return nil, errAllEnded
+		}
+		l.pending++
+	}
+	// The previous run of the app ended, release its context
+	if cancel, ok := l.cancels[name]; ok {
+		cancel()
+	}
+	appCtx, cancel := context.WithCancel(ctx)
+	l.cancels[name] = cancel
+	return appCtx, nil
+}
+
+// expect adds the OutputEnd of an app started later, once another one ended.
+// It fails with errAllEnded once all OutputEnd messages arrived.
+func (l *lifecycles) expect() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == 0 {
+		return errAllEnded
+	}
+	l.pending++
+	return nil
+}
+
+// stop stops the named app like on shutdown. It reports false if the app
+// doesn't run.
+func (l *lifecycles) stop(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cancel, ok := l.cancels[name]
+	if ok {
+		cancel()
+		delete(l.cancels, name)
+	}
+	return ok
+}
+
+// end counts the OutputEnd messages, reporting whether the message is the
+// last one to come.
+func (l *lifecycles) end(message Message) bool {
+	if message.Type != OutputEnd {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending--
+	return l.pending == 0
+}
+
+// AppStatus is the status of an app served by the control API.
+type AppStatus struct {
+	Name string `json:"name"`
+	// Status is "pending", before the app was started, "starting", "running",
+	// "stopped", once stopped through the API or on shutdown, or "exited".
+	Status string `json:"status"`
+	// Pid is the PID of the running process.
+	Pid int `json:"pid,omitempty"`
+	// ExitCode is the exit code of an exited app, -1 if it was killed by a
+	// signal or never started.
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// controlAPI is a Sink serving the HTTP API listing, stopping and starting the
+// apps, with their status derived from the messages.
+type controlAPI struct {
+	server   *http.Server
+	listener net.Listener
+	// start starts the named app again, stop stops it, filter sets the
+	// pattern its output lines are shown for, see outputFilters.set.
+	start  func(name string) error
+	stop   func(name string) bool
+	filter func(name string, pattern string) error
+
+	mu     sync.Mutex
+	order  []string
+	status map[string]*AppStatus
+	// ended holds the apps whose OutputEnd arrived, which may be started again.
+	ended map[string]bool
+}
+
+// newControlAPI returns the control API of the apps, without serving it yet.
+func newControlAPI(apps []Command, start func(name string) error, stop func(name string) bool, filter func(name string, pattern string) error) *controlAPI {
+	api := &controlAPI{start: start, stop: stop, filter: filter, status: make(map[string]*AppStatus, len(apps)), ended: make(map[string]bool)}
+	for _, command := range apps {
+		api.order = append(api.order, command.Name)
+		api.status[command.Name] = &AppStatus{Name: command.Name, Status: statePending}
+	}
+	return api
+}
+
+// setApps changes the apps listed to the ones of a reloaded config. The apps
+// that were already listed keep their status.
+func (a *controlAPI) setApps(apps []Command) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.order = a.order[:0]
+	for _, command := range apps {
+		a.order = append(a.order, command.Name)
+		if _, ok := a.status[command.Name]; !ok {
+			a.status[command.Name] = &AppStatus{Name: command.Name, Status: statePending}
+		}
+	}
+}
+
+// listen starts serving the API on addr, e.g. ":8080".
+func (a *controlAPI) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting control API: %w", err)
+	}
+	a.listener = listener
+	a.server = &http.Server{Handler: a}
+	go func() {
+		if err := a.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logSystem("SystemError", "error serving control API: %v", err)
+		}
+	}()
+	return nil
+}
+
+// ServeHTTP serves GET /apps, POST /apps/{name}/stop, POST /apps/{name}/start
+// and POST /apps/{name}/filter.
+func (a *controlAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/apps" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		a.serveApps(w)
+		return
+	}
+
+	rest, ok := strings.CutPrefix(r.URL.Path, "/apps/")
+	name, action, hasAction := strings.Cut(rest, "/")
+	if !ok || !hasAction || (action != "stop" && action != "start" && action != "filter") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Hold the lock until the status changed, so that an app is started once
+	// however many requests arrive at the same time
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status, ok := a.status[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown app %q", name), http.StatusNotFound)
+		return
+	}
+	switch action {
+	case "stop":
+		if a.ended[name] || status.Status == stateStopped || !a.stop(name) {
+			http.Error(w, fmt.Sprintf("%s is not running", name), http.StatusConflict)
+			return
+		}
+	case "start":
+		if !a.ended[name] {
+			http.Error(w, fmt.Sprintf("%s is still running", name), http.StatusConflict)
+			return
+		}
+		if err := a.start(name); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		delete(a.ended, name)
+		*status = AppStatus{Name: name, Status: stateStarting}
+	case "filter":
+		// The pattern applies right away, an empty one removes it
+		if err := a.filter(name, r.FormValue("pattern")); err != nil {
+			http.Error(w, fmt.Sprintf("invalid pattern: %v", err), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// serveApps responds with the status of every app, in the order of the config.
+func (a *controlAPI) serveApps(w http.ResponseWriter) {
+	a.mu.Lock()
+	apps := make([]AppStatus, 0, len(a.order))
+	for _, name := range a.order {
+		apps = append(apps, *a.status[name])
+	}
+	a.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(apps); err != nil {
+		logSystem("SystemError", "error serving apps: %v", err)
+	}
+}
+
+// Write updates the status of the message's app.
+func (a *controlAPI) Write(message Message) error {
+	if message.Command == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	status, ok := a.status[message.Command.Name]
+	if !ok {
+		return nil
+	}
+	switch message.Type {
+	case OutputStart:
+		*status = AppStatus{Name: status.Name, Status: stateStarting}
+	case OutputRunning:
+		status.Status, status.Pid = stateRunning, message.Pid
+	case OutputStopped:
+		status.Status, status.Pid = stateStopped, 0
+	case OutputEnd:
+		a.ended[status.Name] = true
+		if status.Status != stateStopped {
+			code := message.ExitCode
+			status.Status, status.ExitCode = stateExited, &code
+		}
+		status.Pid = 0
+	}
+	return nil
+}
+
+// Close stops serving the API.
+func (a *controlAPI) Close() error {
+	if a.server == nil {
+		return nil
+	}
+	return a.server.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlAPI(t *testing.T) {
+	web := &Command{Name: "web"}
+	job := &Command{Name: "job"}
+	var started, stopped []string
+	filters := newOutputFilters()
+	api := newControlAPI([]Command{*web, *job}, func(name string) error {
+		started = append(started, name)
+		return nil
+	}, func(name string) bool {
+		stopped = append(stopped, name)
+		return true
+	}, filters.set)
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		api.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+	apps := func() []AppStatus {
+		recorder := request(http.MethodGet, "/apps")
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		var statuses []AppStatus
+		assert.NoError(t, json.NewDecoder(recorder.Body).Decode(&statuses))
+		return statuses
+	}
+	exitCode := func(code int) *int { return &code }
+
+	// The status follows the messages
+	assert.Equal(t, []AppStatus{{Name: "web", Status: statePending}, {Name: "job", Status: statePending}}, apps())
+	for _, message := range []Message{
+		{Type: OutputStart, Command: web},
+		{Type: OutputRunning, Command: web, Pid: 42},
+		{Type: OutputStart, Command: job},
+		{Type: OutputRunning, Command: job, Pid: 43},
+		{Type: OutputEnd, Command: job, ExitCode: 3},
+	} {
+		assert.NoError(t, api.Write(message))
+	}
+	assert.Equal(t, []AppStatus{
+		{Name: "web", Status: stateRunning, Pid: 42},
+		{Name: "job", Status: stateExited, ExitCode: exitCode(3)},
+	}, apps())
+
+	// Running apps can be stopped, and apps that ended started again
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/apps/web/stop").Code)
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/apps/web/start").Code)
+	assert.NoError(t, api.Write(Message{Type: OutputStopped, Content: "stopped gracefully", Command: web}))
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/apps/web/stop").Code)
+	assert.NoError(t, api.Write(Message{Type: OutputEnd, Command: web, ExitCode: -1}))
+	assert.Equal(t, stateStopped, apps()[0].Status)
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/apps/web/start").Code)
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/apps/job/start").Code)
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/apps/job/start").Code)
+	assert.Equal(t, []AppStatus{{Name: "web", Status: stateStarting}, {Name: "job", Status: stateStarting}}, apps())
+	assert.Equal(t, []string{"web"}, stopped)
+	assert.Equal(t, []string{"web", "job"}, started)
+
+	// Output filters apply right away, and an empty pattern removes them
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPost, "/apps/web/filter?pattern=^GET").Code)
+	assert.True(t, filters.allow(Message{Type: OutputStdout, Content: "GET /", Command: web}))
+	assert.False(t, filters.allow(Message{Type: OutputStdout, Content: "POST /", Command: web}))
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/apps/web/filter?pattern=(").Code)
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPost, "/apps/web/filter").Code)
+	assert.True(t, filters.allow(Message{Type: OutputStdout, Content: "POST /", Command: web}))
+
+	// Unknown apps, actions and methods are rejected
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/apps/db/stop").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/apps/web/restart").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodGet, "/apps/web/stop").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, request(http.MethodDelete, "/apps").Code)
+
+	// A reload adds apps and removes others, keeping the status of the rest
+	db := &Command{Name: "db"}
+	api.setApps([]Command{*db, *web})
+	assert.Equal(t, []AppStatus{{Name: "db", Status: statePending}, {Name: "web", Status: stateStarting}}, apps())
+	assert.NoError(t, api.Write(Message{Type: OutputRunning, Command: db, Pid: 44}))
+	assert.Equal(t, http.StatusAccepted, request(http.MethodPost, "/apps/db/stop").Code)
+	assert.Equal(t, []string{"web", "db"}, stopped)
+}
+
+func TestLifecycles(t *testing.T) {
+	ctx := context.Background()
+	apps := newLifecycles(2)
+	webCtx, err := apps.start(ctx, "web", false)
+	assert.NoError(t, err)
+	_, err = apps.start(ctx, "job", false)
+	assert.NoError(t, err)
+
+	// Stopping cancels the context of the app alone
+	assert.True(t, apps.stop("web"))
+	assert.Error(t, webCtx.Err())
+	assert.False(t, apps.stop("web"))
+	assert.False(t, apps.end(Message{Type: OutputEnd, Command: &Command{Name: "web"}}))
+
+	// An app started again is waited for too
+	_, err = apps.start(ctx, "web", true)
+	assert.NoError(t, err)
+	assert.False(t, apps.end(Message{Type: OutputStdout, Command: &Command{Name: "job"}}))
+	assert.False(t, apps.end(Message{Type: OutputEnd, Command: &Command{Name: "job"}}))
+	assert.True(t, apps.end(Message{Type: OutputEnd, Command: &Command{Name: "web"}}))
+
+	// Once all ended, nothing starts anymore
+	_, err = apps.start(ctx, "web", true)
+	assert.Equal(t, errAllEnded, err)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupGroup is an output line seen from one or more commands within the window,
+// or a message queued behind held lines.
+type dedupGroup struct {
+	first  Message
+	offset string
+	count  int
+	names  []string
+	// released is set once the group may be emitted.
+	released bool
+}
+
+// deduplicator collapses identical output lines of different commands, e.g. of
+// replicas, into a single line. A line is held for the window after it was first
+// seen, then emitted once with a "(xN: names)" suffix if other commands printed
+// it too. The messages after a held line are held with it, so that every message
+// is emitted in the order it arrived in.
+type deduplicator struct {
+	window time.Duration
+	clock  Clock
+	emit   func(message Message, offset string)
+
+	mu sync.Mutex
+	// queue holds the groups still to emit, in the order they arrived in.
+	queue []*dedupGroup
+	// open holds the group lines of other commands are still added to, by line.
+	open map[string]*dedupGroup
+}
+
+// newDeduplicator returns a deduplicator handing the collapsed lines to emit,
+// or nil if window is not positive.
+func newDeduplicator(window time.Duration, clock Clock, emit func(message Message, offset string)) *deduplicator {
+	if window <= 0 {
+		return nil
+	}
+	return &deduplicator{window: window, clock: clock, emit: emit, open: make(map[string]*dedupGroup)}
+}
+
+// hold takes stdout and stderr lines, and the messages arriving while lines are
+// held, which are emitted later, and reports whether it did. Other messages
+// must be handled by the caller right away.
+func (d *deduplicator) hold(message Message, offset string) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if message.Type != OutputStdout && message.Type != OutputStderr {
+		if len(d.queue) == 0 {
+			return false
+		}
+		d.queue = append(d.queue, &dedupGroup{first: message, offset: offset, count: 1, released: true})
+		return true
+	}
+
+	// A line the same command printed again is a line of its own
+	name := message.CommandName()
+	if group, ok := d.open[message.Content]; ok && !slices.Contains(group.names, name) {
+		group.count++
+		group.names = append(group.names, name)
+		return true
+	}
+
+	group := &dedupGroup{first: message, offset: offset, count: 1, names: []string{name}}
+	d.open[message.Content] = group
+	d.queue = append(d.queue, group)
+	expired := d.clock.After(d.window)
+	go func() {
+		<-expired
+		d.release(group)
+	}()
+	return true
+}
+
+// release emits the group once the groups before it were, along with the
+// released groups after it.
+func (d *deduplicator) release(group *dedupGroup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	group.released = true
+	if d.open[group.first.Content] == group {
+		delete(d.open, group.first.Content)
+	}
+	for len(d.queue) > 0 && d.queue[0].released {
+		d.emit(d.queue[0].collapse(), d.queue[0].offset)
+		d.queue = d.queue[1:]
+	}
+}
+
+// flush emits every held message right away, e.g. before exiting.
+func (d *deduplicator) flush() {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, group := range d.queue {
+		group.released = true
+		d.emit(group.collapse(), group.offset)
+	}
+	d.queue = nil
+	d.open = make(map[string]*dedupGroup)
+}
+
+// collapse returns the first message of the group, annotated with the number of
+// times the line was seen and by which commands if it was seen more than once.
+func (g *dedupGroup) collapse() Message {
+	message := g.first
+	if g.count > 1 {
+		message.Content = fmt.Sprintf("%s (x%d: %s)", message.Content, g.count, strings.Join(g.names, ", "))
+	}
+	return message
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicator(t *testing.T) {
+	var mu sync.Mutex
+	var emitted []string
+	clock := NewFakeClock(time.Now())
+	d := newDeduplicator(time.Second, clock, func(message Message, offset string) {
+		mu.Lock()
+		defer mu.Unlock()
+		emitted = append(emitted, message.CommandName()+" "+message.Type.Name()+": "+message.Content)
+	})
+	web1, web2, web3 := &Command{Name: "web-1"}, &Command{Name: "web-2"}, &Command{Name: "web-3"}
+
+	// Nothing is held, so the message is the caller's to handle
+	assert.False(t, d.hold(Message{Type: OutputStart, Command: web1}, ""))
+
+	for _, command := range []*Command{web1, web2, web3} {
+		assert.True(t, d.hold(Message{Content: "connected", Type: OutputStdout, Command: command}, ""))
+	}
+	assert.True(t, d.hold(Message{Content: "unique", Type: OutputStderr, Command: web2}, ""))
+	assert.True(t, d.hold(Message{Content: "", Type: OutputStdout, Command: web1}, ""))
+	assert.True(t, d.hold(Message{Content: "", Type: OutputStdout, Command: web1}, ""))
+	assert.True(t, d.hold(Message{Type: OutputEnd, Command: web1}, ""))
+
+	assert.Eventually(t, func() bool { return clock.Waiters() == 4 }, time.Second, time.Millisecond)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(emitted) == 5
+	}, time.Second, 10*time.Millisecond)
+	// Repeated lines of the same command are kept, and the order too
+	assert.Equal(t, []string{
+		"web-1 OutputStdout: connected (x3: web-1, web-2, web-3)",
+		"web-2 OutputStderr: unique",
+		"web-1 OutputStdout: ",
+		"web-1 OutputStdout: ",
+		"web-1 OutputEnd: ",
+	}, emitted)
+
+	// Held messages are emitted on flush without waiting for the window
+	emitted = nil
+	d = newDeduplicator(time.Hour, clock, func(message Message, offset string) {
+		emitted = append(emitted, message.Content)
+	})
+	d.hold(Message{Content: "pending", Type: OutputStdout, Command: web1}, "")
+	d.hold(Message{Content: "exit code 0", Type: OutputEnd, Command: web1}, "")
+	d.flush()
+	assert.Equal(t, []string{"pending", "exit code 0"}, emitted)
+
+	assert.Nil(t, newDeduplicator(0, clock, nil))
+}
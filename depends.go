@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// dependsOn returns the names of the apps the command waits for before it
+// starts: the ones in its depends_on and the ones whose output its args refer to.
+func dependsOn(command Command) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range append(append([]string(nil), command.DependsOn...), references(command)...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateDependsOn checks that the apps only depend on apps that exist, and
+// that they don't wait for each other in a cycle, naming the apps of the cycle.
+func validateDependsOn(apps []Command) error {
+	deps := make(map[string][]string, len(apps))
+	for _, command := range apps {
+		deps[command.Name] = dependsOn(command)
+	}
+	for i, command := range apps {
+		for _, name := range command.DependsOn {
+			if _, ok := deps[name]; !ok {
+				return fmt.Errorf("apps[%d] %q: depends_on names unknown app %q", i, command.Name, name)
+			}
+		}
+	}
+
+	// Depth-first search for a dependency back to an app on the current path
+	var path []string
+	visited := make(map[string]bool, len(apps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		for i, current := range path {
+			if current == name {
+				cycle := append(append([]string(nil), path[i:]...), name)
+				return fmt.Errorf("apps depend on each other in a cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+		if visited[name] {
+			return nil
+		}
+		path = append(path, name)
+		for _, dep := range deps[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		visited[name] = true
+		return nil
+	}
+	for _, command := range apps {
+		if err := visit(command.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dependency is an app that others depend on.
+type dependency struct {
+	ready chan struct{}
+	ended chan struct{}
+}
+
+// dependencies follows the apps that others depend on in the message stream, so
+// that the apps depending on them start once they are ready.
+type dependencies struct {
+	mu      sync.Mutex
+	results map[string]*dependency
+}
+
+// newDependencies returns the follower of the apps the others depend on.
+func newDependencies(apps []Command) *dependencies {
+	d := &dependencies{results: make(map[string]*dependency)}
+	for _, command := range apps {
+		for _, name := range command.DependsOn {
+			if _, ok := d.results[name]; !ok {
+				d.results[name] = &dependency{ready: make(chan struct{}), ended: make(chan struct{})}
+			}
+		}
+	}
+	return d
+}
+
+// observe records when the apps depended on become ready, or end for good
+// without ever becoming ready.
+func (d *dependencies) observe(message Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result, ok := d.results[message.CommandName()]
+	if !ok || message.Command == nil {
+		return
+	}
+
+	switch message.Type {
+	case readyMessage(*message.Command):
+		closeOnce(result.ready)
+	case OutputEnd:
+		closeOnce(result.ended)
+	}
+}
+
+// closeOnce closes the channel unless it is closed already.
+func closeOnce(ch chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// tracks reports whether the apps in the command's depends_on are followed,
+// which only the ones some app depended on at startup are.
+func (d *dependencies) tracks(command Command) bool {
+	for _, name := range command.DependsOn {
+		if _, ok := d.results[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// wait waits for the apps in the command's depends_on to become ready.
+func (d *dependencies) wait(ctx context.Context, command Command) error {
+	for _, name := range command.DependsOn {
+		result := d.results[name]
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("shut down while waiting for %s to become ready", name)
+		case <-result.ready:
+		case <-result.ended:
+			select {
+			case <-result.ready:
+			default:
+				return fmt.Errorf("not starting, %s ended before becoming ready", name)
+			}
+		}
+	}
+	return nil
+}
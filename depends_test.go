@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDependsOn(t *testing.T) {
+	assert.NoError(t, validateDependsOn([]Command{
+		{Name: "a", Command: "a"},
+		{Name: "b", Command: "b", DependsOn: []string{"a"}},
+		{Name: "c", Command: "c", DependsOn: []string{"b"}, Args: []string{"$(a.stdout)"}},
+	}))
+
+	assert.EqualError(t, validateDependsOn([]Command{
+		{Name: "web", Command: "web", DependsOn: []string{"db"}},
+	}), `apps[0] "web": depends_on names unknown app "db"`)
+
+	assert.EqualError(t, validateDependsOn([]Command{
+		{Name: "a", Command: "a", DependsOn: []string{"c"}},
+		{Name: "b", Command: "b", DependsOn: []string{"a"}},
+		{Name: "c", Command: "c", DependsOn: []string{"b"}},
+	}), "apps depend on each other in a cycle: a -> c -> b -> a")
+
+	// Waiting for output counts towards cycles too
+	assert.EqualError(t, validateDependsOn([]Command{
+		{Name: "a", Command: "a", DependsOn: []string{"b"}},
+		{Name: "b", Command: "b", Args: []string{"$(a.stdout)"}},
+	}), "apps depend on each other in a cycle: a -> b -> a")
+}
+
+func TestDependencies(t *testing.T) {
+	a := &Command{Name: "a", Command: "a"}
+	b := &Command{Name: "b", Command: "b", DependsOn: []string{"a"}, ReadyWhen: "listening"}
+	c := &Command{Name: "c", Command: "c", DependsOn: []string{"b"}}
+	d := newDependencies([]Command{*a, *b, *c})
+
+	// A chain starts one app after the other
+	waited := make(chan string, 2)
+	for _, command := range []*Command{b, c} {
+		go func(command *Command) {
+			assert.NoError(t, d.wait(context.Background(), *command))
+			waited <- command.Name
+		}(command)
+	}
+	d.observe(Message{Type: OutputStart, Command: a})
+	assert.Never(t, func() bool { return len(waited) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+	d.observe(Message{Type: OutputRunning, Command: a})
+	assert.Equal(t, "b", <-waited)
+
+	// b is only ready once it matched ready_when
+	d.observe(Message{Type: OutputRunning, Command: b})
+	assert.Never(t, func() bool { return len(waited) > 0 }, 50*time.Millisecond, 5*time.Millisecond)
+	d.observe(Message{Type: OutputReady, Command: b})
+	assert.Equal(t, "c", <-waited)
+
+	// An app that ended without becoming ready fails the apps depending on it
+	d = newDependencies([]Command{*a, *b})
+	d.observe(Message{Type: OutputEnd, Command: a})
+	assert.EqualError(t, d.wait(context.Background(), *b), "not starting, a ended before becoming ready")
+
+	// Shutting down stops waiting
+	d = newDependencies([]Command{*a, *b})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, d.wait(ctx, *b))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// referencePattern matches references to the output of another app in args
+// and env values, like $(port.stdout).
+var referencePattern = regexp.MustCompile(`\$\(([^()]+)\.stdout\)`)
+
+// references returns the names of the apps whose output the command's args and
+// env values refer to.
+func references(command Command) []string {
+	var names []string
+	seen := make(map[string]bool)
+	values := slices.Clone(command.Args)
+	for _, key := range sortedKeys(command.Env) {
+		values = append(values, command.Env[key])
+	}
+	for _, value := range values {
+		for _, match := range referencePattern.FindAllStringSubmatch(value, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				names = append(names, match[1])
+			}
+		}
+	}
+	return names
+}
+
+// validateReferences checks that the apps only refer to the output of apps that
+// exist, and that they don't wait for each other in a cycle.
+func validateReferences(apps []Command) error {
+	refs := make(map[string][]string, len(apps))
+	for _, command := range apps {
+		refs[command.Name] = references(command)
+	}
+	for i, command := range apps {
+		for _, name := range refs[command.Name] {
+			if _, ok := refs[name]; !ok {
+				return fmt.Errorf("apps[%d] %q: args or env refer to the output of unknown app %q", i, command.Name, name)
+			}
+		}
+	}
+
+	// Depth-first search for a reference back to an app on the current path
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(apps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("apps refer to each other's output in a cycle through %q", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, ref := range refs[name] {
+			if err := visit(ref); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, command := range apps {
+		if err := visit(command.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discovery is the output of an app that others refer to.
+type discovery struct {
+	done   chan struct{}
+	lines  []string
+	failed bool
+}
+
+// discoveries collects the output of the apps referred to by others from the
+// message stream, so that the references are resolved at runtime once the app
+// has exited.
+type discoveries struct {
+	mu      sync.Mutex
+	results map[string]*discovery
+}
+
+// newDiscoveries returns the collector for the apps referred to by the others.
+func newDiscoveries(apps []Command) *discoveries {
+	d := &discoveries{results: make(map[string]*discovery)}
+	for _, command := range apps {
+		for _, name := range references(command) {
+			if _, ok := d.results[name]; !ok {
+				d.results[name] = &discovery{done: make(chan struct{})}
+			}
+		}
+	}
+	return d
+}
+
+// observe records the stdout lines of referred to apps, whether they failed, and
+// when they exited.
+func (d *discoveries) observe(message Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result, ok := d.results[message.CommandName()]
+	if !ok || message.Command == nil {
+		return
+	}
+	select {
+	case <-result.done:
+		// Later runs don't change what was discovered
+		return
+	default:
+	}
+
+	switch message.Type {
+	case OutputStdout:
+		result.lines = append(result.lines, message.Content)
+	case SystemError:
+		result.failed = result.failed || message.Failed
+	case OutputEnd:
+		close(result.done)
+	}
+}
+
+// tracks reports whether the output of the apps the command refers to is
+// collected, which it only is for the ones referred to at startup.
+func (d *discoveries) tracks(command Command) bool {
+	for _, name := range references(command) {
+		if _, ok := d.results[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// resolve waits for the apps the command refers to to exit and returns the
+// command with the references in its args and env replaced by their trimmed
+// stdout.
+func (d *discoveries) resolve(ctx context.Context, command Command) (Command, error) {
+	values := make(map[string]string)
+	for _, name := range references(command) {
+		result := d.results[name]
+		select {
+		case <-ctx.Done():
+			return command, errors.New("shut down while waiting for the output of " + name)
+		case <-result.done:
+		}
+
+		d.mu.Lock()
+		failed, value := result.failed, strings.TrimSpace(strings.Join(result.lines, "\n"))
+		d.mu.Unlock()
+		if failed {
+			return command, fmt.Errorf("error resolving $(%s.stdout): %s failed", name, name)
+		}
+		values[name] = value
+	}
+
+	replace := func(value string) string {
+		return referencePattern.ReplaceAllStringFunc(value, func(ref string) string {
+			return values[referencePattern.FindStringSubmatch(ref)[1]]
+		})
+	}
+	args := make([]string, len(command.Args))
+	for i, arg := range command.Args {
+		args[i] = replace(arg)
+	}
+	command.Args = args
+	if command.Env != nil {
+		env := make(map[string]string, len(command.Env))
+		for key, value := range command.Env {
+			env[key] = replace(value)
+		}
+		command.Env = env
+	}
+	return command, nil
+}
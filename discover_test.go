@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateReferences(t *testing.T) {
+	assert.NoError(t, validateReferences([]Command{
+		{Name: "port", Command: "free-port"},
+		{Name: "token", Command: "issue-token", Args: []string{"$(port.stdout)"}},
+		{Name: "web", Command: "web", Args: []string{"--port=$(port.stdout)", "$(token.stdout)"}},
+	}))
+	assert.Equal(t, []string{"port", "token"}, references(Command{Args: []string{"$(port.stdout)"}, Env: map[string]string{"TOKEN": "$(token.stdout)", "PORT": "$(port.stdout)"}}))
+
+	assert.EqualError(t, validateReferences([]Command{
+		{Name: "web", Command: "web", Args: []string{"$(port.stdout)"}},
+	}), `apps[0] "web": args or env refer to the output of unknown app "port"`)
+	assert.EqualError(t, validateReferences([]Command{
+		{Name: "web", Command: "web", Env: map[string]string{"TOKEN": "$(token.stdout)"}},
+	}), `apps[0] "web": args or env refer to the output of unknown app "token"`)
+
+	assert.EqualError(t, validateReferences([]Command{
+		{Name: "a", Command: "a", Args: []string{"$(b.stdout)"}},
+		{Name: "b", Command: "b", Args: []string{"$(a.stdout)"}},
+	}), `apps refer to each other's output in a cycle through "a"`)
+}
+
+func TestDiscoveries(t *testing.T) {
+	port := &Command{Name: "port", Command: "free-port"}
+	web := Command{Name: "web", Command: "web", Args: []string{"--port=$(port.stdout)", "-v"}, Env: map[string]string{"PORT": "$(port.stdout)", "MODE": "dev"}}
+	d := newDiscoveries([]Command{*port, web})
+
+	d.observe(Message{Type: OutputStart, Command: port})
+	d.observe(Message{Content: "8080 ", Type: OutputStdout, Command: port})
+	d.observe(Message{Type: OutputEnd, Command: port})
+
+	resolved, err := d.resolve(context.Background(), web)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--port=8080", "-v"}, resolved.Args)
+	assert.Equal(t, map[string]string{"PORT": "8080", "MODE": "dev"}, resolved.Env)
+	assert.Equal(t, []string{"--port=$(port.stdout)", "-v"}, web.Args)
+	assert.Equal(t, "$(port.stdout)", web.Env["PORT"])
+
+	// A failed app can't be referred to
+	d = newDiscoveries([]Command{*port, web})
+	d.observe(Message{Content: "error waiting for command: exit status 1", Type: SystemError, Command: port, Failed: true})
+	d.observe(Message{Type: OutputEnd, Command: port})
+	_, err = d.resolve(context.Background(), web)
+	assert.EqualError(t, err, "error resolving $(port.stdout): port failed")
+
+	// Shutting down stops waiting
+	d = newDiscoveries([]Command{*port, web})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = d.resolve(ctx, web)
+	assert.Error(t, err)
+}
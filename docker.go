@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+)
+
+// invalidContainerChars matches the characters that are replaced in container names.
+var invalidContainerChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// containerName returns the name of the container running the command.
+// It includes the PID of psmgmt so that several instances don't collide.
+func containerName(command Command) string {
+	return fmt.Sprintf("psmgmt-%d-%s", os.Getpid(), invalidContainerChars.ReplaceAllString(command.Name, "_"))
+}
+
+// dockerRunArgs returns the docker arguments that run the command inside its image.
+// The container is removed when it exits and keeps stdin open like a local process.
+// It gets the command's env, unless docker runs on a remote host, and runs in
+// its working_dir inside the container.
+func dockerRunArgs(command Command) []string {
+	args := []string{"run", "--rm", "-i", "--name", containerName(command)}
+	if command.WorkingDir != "" {
+		args = append(args, "-w", command.WorkingDir)
+	}
+	// Pass the env by name only, docker takes the values from its own environment,
+	// so that they don't show up in the command line
+	if command.Host == "" {
+		for _, key := range sortedKeys(command.Env) {
+			args = append(args, "-e", key)
+		}
+	}
+	args = append(args, command.Image)
+	if command.Command != "" {
+		args = append(args, command.Command)
+	}
+	return append(args, command.Args...)
+}
+
+// stopContainer stops the container running the command. Killing the docker client
+// on shutdown leaves the container running, so it has to be stopped explicitly.
+func stopContainer(command Command) error {
+	stop := Command{Command: "docker", Args: []string{"stop", containerName(command)}, Host: command.Host}
+	name, args := commandLine(stop)
+	output, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, output)
+	}
+	return nil
+}
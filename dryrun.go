@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretEnv matches the names of variables whose values are likely secrets,
+// like DB_PASSWORD or API_KEY.
+var secretEnv = regexp.MustCompile(`(?i)password|passwd|secret|token|api[-_]?key`)
+
+// writePlan writes what running the config would do to w, without running
+// anything: the setenv commands, and for every app its command line, working
+// directory, the environment it sets and the apps it waits for.
+func writePlan(w io.Writer, config *Config) error {
+	var b strings.Builder
+	for _, entry := range config.SetEnv {
+		fmt.Fprintf(&b, "setenv %s: %s\n", entry.Name, redactedCommandLine(entry.Command, entry.Args))
+	}
+	for _, command := range config.Apps {
+		fmt.Fprintf(&b, "%s:\n", command.Name)
+		if command.Builtin != "" {
+			fmt.Fprintf(&b, "  builtin: %s\n", command.Builtin)
+		} else {
+			fmt.Fprintf(&b, "  command: %s\n", redactedCommandLine(commandLine(command)))
+		}
+		dir := command.WorkingDir
+		if dir == "" {
+			dir = "."
+		}
+		fmt.Fprintf(&b, "  working_dir: %s\n", dir)
+		if deps := dependsOn(command); len(deps) > 0 {
+			fmt.Fprintf(&b, "  depends_on: %s\n", strings.Join(deps, ", "))
+		}
+		if len(command.Env) > 0 || len(command.Path) > 0 {
+			b.WriteString("  env:\n")
+			for _, key := range sortedKeys(command.Env) {
+				value := command.Env[key]
+				if secretEnv.MatchString(key) {
+					value = "***"
+				}
+				fmt.Fprintf(&b, "    %s=%s\n", key, value)
+			}
+			if len(command.Path) > 0 {
+				fmt.Fprintf(&b, "    PATH=%s\n", strings.Join(command.Path, string(os.PathListSeparator)))
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
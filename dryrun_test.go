@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePlan(t *testing.T) {
+	config := &Config{
+		SetEnv: []SetEnv{{Name: "VERSION", Command: "git", Args: []string{"describe"}}},
+		Apps: []Command{
+			{Name: "db", Command: "postgres", Args: []string{"-D", "data dir"}, WorkingDir: "/srv/db"},
+			{Name: "web", Command: "./server", Args: []string{"--token=hunter2"}, Env: map[string]string{"PORT": "8080", "API_KEY": "hunter2"}, DependsOn: []string{"db"}},
+			{Name: "idle", Builtin: builtinKeepalive},
+		},
+	}
+
+	var b strings.Builder
+	assert.NoError(t, writePlan(&b, config))
+	assert.Equal(t, `setenv VERSION: git describe
+db:
+  command: postgres -D 'data dir'
+  working_dir: /srv/db
+web:
+  command: ./server --token=***
+  working_dir: .
+  depends_on: db
+  env:
+    API_KEY=***
+    PORT=8080
+idle:
+  builtin: keepalive
+  working_dir: .
+`, b.String())
+}
+
+func TestRunDryRun(t *testing.T) {
+	defer func() { *noSignalHandling, *dryRun = false, false }()
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "ran")
+
+	// A valid config is printed and nothing runs
+	path := filepath.Join(dir, "psmgmt.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("version: \"1\"\napps:\n  - name: touch\n    command: touch\n    args: [\""+marker+"\"]\n"), 0o644))
+	assert.Equal(t, 0, run([]string{"--no-signal-handling", "--dry-run", path}))
+	assert.NoFileExists(t, marker)
+
+	// An invalid one fails with the validation error
+	assert.NoError(t, os.WriteFile(path, []byte("version: \"1\"\napps:\n  - name: a\n    command: \"true\"\n    depends_on: [b]\n  - name: b\n    command: \"true\"\n    depends_on: [a]\n"), 0o644))
+	assert.Equal(t, 1, run([]string{"--no-signal-handling", "--dry-run", path}))
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// secretFlag matches flags whose names suggest that their value is a secret, along
+// with the value, like --db-password=hunter2 or --token hunter2.
+var secretFlag = regexp.MustCompile(`(?i)(-{1,2}[\w-]*(?:password|passwd|secret|token|api[-_]?key)[\w-]*)(=|\s+)([^\s']+)`)
+
+// redactedCommandLine returns the command line of name and args as it would be
+// typed in a shell, with the values of secret-looking flags replaced by ***.
+func redactedCommandLine(name string, args []string) string {
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{name}, args...) {
+		words = append(words, shellQuote(word))
+	}
+	return secretFlag.ReplaceAllString(strings.Join(words, " "), "$1$2***")
+}
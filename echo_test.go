@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactedCommandLine(t *testing.T) {
+	assert.Equal(t, "/usr/bin/web --port 8080 'hello world'",
+		redactedCommandLine("/usr/bin/web", []string{"--port", "8080", "hello world"}))
+	assert.Equal(t, "web --db-password=*** --token *** -v",
+		redactedCommandLine("web", []string{"--db-password=hunter2", "--token", "abc123", "-v"}))
+
+	// Secrets are also redacted inside the remote command of ssh
+	name, args := commandLine(Command{Command: "web", Args: []string{"--api-key=abc"}, Host: "deploy@app1"})
+	assert.Equal(t, shellQuote(sshExecutable())+" ssh deploy@app1 -- 'web --api-key=***'", redactedCommandLine(name, args))
+}
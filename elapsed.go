@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// elapsedTracker remembers when each command started in order to annotate its
+// messages with the time elapsed since then.
+type elapsedTracker struct {
+	starts map[string]time.Time
+}
+
+// newElapsedTracker returns an elapsedTracker that knows of no started command.
+func newElapsedTracker() *elapsedTracker {
+	return &elapsedTracker{starts: make(map[string]time.Time)}
+}
+
+// annotate returns the time elapsed between the start of the message's command
+// and now, formatted like "+1.234s". It returns an empty string for messages that
+// don't belong to a started command. OutputStart messages record the start time.
+func (e *elapsedTracker) annotate(message Message, now time.Time) string {
+	name := message.CommandName()
+	if message.Type == OutputStart {
+		e.starts[name] = now
+	}
+
+	start, ok := e.starts[name]
+	if !ok || message.Command == nil {
+		return ""
+	}
+	if message.Type == OutputEnd {
+		delete(e.starts, name)
+	}
+	return fmt.Sprintf("+%.3fs", now.Sub(start).Seconds())
+}
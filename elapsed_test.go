@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElapsedTracker(t *testing.T) {
+	tracker := newElapsedTracker()
+	web := &Command{Name: "web"}
+	start := time.Now()
+
+	assert.Equal(t, "+0.000s", tracker.annotate(Message{Type: OutputStart, Command: web}, start))
+	assert.Equal(t, "+1.234s", tracker.annotate(Message{Type: OutputStdout, Command: web}, start.Add(1234*time.Millisecond)))
+	assert.Equal(t, "+2.500s", tracker.annotate(Message{Type: OutputEnd, Command: web}, start.Add(2500*time.Millisecond)))
+
+	// Messages after the end, or without a command, have no start to refer to
+	assert.Equal(t, "", tracker.annotate(Message{Type: OutputStdout, Command: web}, start.Add(3*time.Second)))
+	assert.Equal(t, "", tracker.annotate(Message{Type: SystemError}, start))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readEnvFile reads the variables of the .env file at path.
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseEnvFile(file)
+}
+
+// envFilePath returns the path of an env_file of the config file at path,
+// which it is relative to, like include, unless it is absolute. An empty path
+// stands for the working directory.
+func envFilePath(path, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(filepath.Dir(path), file)
+}
+
+// parseEnvFile parses KEY=VALUE lines, optionally prefixed with export. Blank
+// lines and lines starting with # are skipped, as is a # comment after an
+// unquoted value. Values in double quotes may use Go escapes like \n, those
+// in single quotes are taken literally.
+func parseEnvFile(r io.Reader) (map[string]string, error) {
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || !envName.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", number)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", number, key, err)
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// parseEnvValue unquotes the value of a .env line.
+func parseEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end < 0 {
+			return "", errors.New("unterminated quote")
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated quote")
+		}
+		return value[1 : end+1], nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
+
+// closingQuote returns the index of the double quote closing the one value
+// starts with, skipping escaped ones, or -1 if there is none.
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// applyEnvFile adds the variables of an env file to the command's env, unless
+// the command or the environment psmgmt runs with already sets them.
+func applyEnvFile(command *Command, env map[string]string) {
+	for key, value := range env {
+		if _, ok := command.Env[key]; ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(key); ok {
+			continue
+		}
+		if command.Env == nil {
+			command.Env = make(map[string]string)
+		}
+		command.Env[key] = value
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	env, err := parseEnvFile(strings.NewReader(`# secrets of the dev stack
+DB_USER=admin
+export DB_PASSWORD="p@ss \"word\"\n"
+
+API_KEY='$ecret # not a comment'
+REGION=eu-west-1 # the closest one
+EMPTY=
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_USER":     "admin",
+		"DB_PASSWORD": "p@ss \"word\"\n",
+		"API_KEY":     "$ecret # not a comment",
+		"REGION":      "eu-west-1",
+		"EMPTY":       "",
+	}, env)
+
+	_, err = parseEnvFile(strings.NewReader("A=1\nnot a variable\n"))
+	assert.EqualError(t, err, "line 2: expected KEY=VALUE")
+	_, err = parseEnvFile(strings.NewReader(`A="open`))
+	assert.EqualError(t, err, "line 1: A: unterminated quote")
+}
+
+func TestParseConfigEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, ".env")
+	assert.NoError(t, os.WriteFile(shared, []byte("PSMGMT_TEST_PORT=8080\nPSMGMT_TEST_USER=shared\nPSMGMT_TEST_HOME=file\n"), 0o600))
+	worker := filepath.Join(dir, "worker.env")
+	assert.NoError(t, os.WriteFile(worker, []byte("PSMGMT_TEST_USER=worker\nPSMGMT_TEST_QUEUE=jobs\n"), 0o600))
+	t.Setenv("PSMGMT_TEST_HOME", "real")
+
+	config, err := parseConfig(strings.NewReader(`version: "1"
+env_file: ` + shared + `
+apps:
+  - name: web
+    command: web
+    args: ["--port", "$PSMGMT_TEST_PORT", "--home", "$PSMGMT_TEST_HOME"]
+  - name: worker
+    command: worker
+    env_file: ` + worker + `
+    env:
+      PSMGMT_TEST_QUEUE: mail
+`))
+	assert.NoError(t, err)
+
+	// The real environment takes precedence over env files, the env of the
+	// app over everything
+	assert.Equal(t, []string{"--port", "8080", "--home", "real"}, config.Apps[0].Args)
+	assert.Equal(t, map[string]string{"PSMGMT_TEST_PORT": "8080", "PSMGMT_TEST_USER": "shared"}, config.Apps[0].Env)
+	assert.Equal(t, map[string]string{"PSMGMT_TEST_PORT": "8080", "PSMGMT_TEST_USER": "worker", "PSMGMT_TEST_QUEUE": "mail"}, config.Apps[1].Env)
+
+	_, err = parseConfig(strings.NewReader("version: \"1\"\nenv_file: " + filepath.Join(dir, "missing.env") + "\n"))
+	assert.ErrorContains(t, err, "env_file: open ")
+}
+
+func TestLoadConfigEnvFileRelative(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "workers"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".env"), []byte("PSMGMT_TEST_PORT=8080\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "web.env"), []byte("PSMGMT_TEST_USER=web\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "workers", "worker.env"), []byte("PSMGMT_TEST_USER=worker\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "workers", "workers.yml"), []byte(`apps:
+  - name: worker
+    command: worker
+    env_file: worker.env
+`), 0o644))
+	path := filepath.Join(dir, "psmgmt.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`version: "1"
+env_file: .env
+include: [workers/workers.yml]
+apps:
+  - name: web
+    command: web
+    env_file: web.env
+`), 0o644))
+
+	// The env files are relative to the config file they are set in, not to
+	// the working directory
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"PSMGMT_TEST_PORT": "8080", "PSMGMT_TEST_USER": "web"}, config.Apps[0].Env)
+	assert.Equal(t, map[string]string{"PSMGMT_TEST_PORT": "8080", "PSMGMT_TEST_USER": "worker"}, config.Apps[1].Env)
+}
@@ -0,0 +1,52 @@
+package main
+
+// exitTracker follows the messages of all command runs to compute the exit code
+// of psmgmt. A command run fails if it reports a failed SystemError or exits
+// with a non-zero code, unless psmgmt stopped it. Notices like restarts don't
+// fail it.
+type exitTracker struct {
+	failed  map[string]bool
+	stopped map[string]bool
+	worst   int
+}
+
+// newExitTracker returns an exitTracker that has seen no failure.
+func newExitTracker() *exitTracker {
+	return &exitTracker{failed: make(map[string]bool), stopped: make(map[string]bool)}
+}
+
+// observe records the failure reported by the message, if any.
+func (e *exitTracker) observe(message Message) {
+	if message.Command == nil {
+		return
+	}
+	switch message.Type {
+	case OutputStart:
+		// An app stopped through --listen may be started again
+		delete(e.stopped, message.CommandName())
+	case SystemError:
+		if message.Failed {
+			e.failed[message.CommandName()] = true
+		}
+	case OutputStopped:
+		// Stopping on shutdown makes processes exit with non-zero codes
+		e.stopped[message.CommandName()] = true
+	case OutputEnd:
+		if !e.stopped[message.CommandName()] && message.ExitCode > e.worst {
+			e.worst = message.ExitCode
+		}
+	}
+}
+
+// code returns 0 if every command succeeded. Otherwise it returns the highest
+// exit code of the commands, or 1 if none of the failed ones exited with a
+// code, e.g. because they were killed by a signal or never started.
+func (e *exitTracker) code() int {
+	if e.worst > 0 {
+		return e.worst
+	}
+	if len(e.failed) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitTracker(t *testing.T) {
+	exits := newExitTracker()
+	web := &Command{Name: "web"}
+	job := &Command{Name: "job"}
+
+	exits.observe(Message{Type: OutputStart, Command: web})
+	exits.observe(Message{Type: OutputStderr, Content: "warning", Command: web})
+	exits.observe(Message{Type: OutputEnd, Command: web})
+	exits.observe(Message{Type: SystemError, Content: "error writing pids file"})
+	assert.Equal(t, 0, exits.code())
+
+	// Notices don't fail the command
+	exits.observe(Message{Type: SystemError, Content: "exited with code 0, restarting in 100ms (restart 1)", Command: web})
+	exits.observe(Message{Type: SystemError, Content: "line truncated to 4 bytes, raise max_line_bytes to keep longer lines", Command: web})
+	exits.observe(Message{Type: OutputEnd, Command: web})
+	assert.Equal(t, 0, exits.code())
+
+	exits.observe(Message{Type: SystemError, Content: "error waiting for command: exit status 2", Command: job, Failed: true})
+	exits.observe(Message{Type: OutputEnd, Command: job})
+	assert.Equal(t, 1, exits.code())
+}
+
+func TestExitTrackerCodes(t *testing.T) {
+	exits := newExitTracker()
+	web := &Command{Name: "web"}
+	job := &Command{Name: "job"}
+	lint := &Command{Name: "lint"}
+
+	// A command stopped on shutdown didn't fail
+	exits.observe(Message{Type: OutputStopped, Content: "stopped gracefully", Command: web})
+	exits.observe(Message{Type: OutputEnd, Command: web, ExitCode: 143})
+	assert.Equal(t, 0, exits.code())
+
+	// The highest code wins
+	exits.observe(Message{Type: SystemError, Content: "error waiting for command: exit status 3", Command: job, Failed: true})
+	exits.observe(Message{Type: OutputEnd, Command: job, ExitCode: 3})
+	exits.observe(Message{Type: SystemError, Content: "error waiting for command: exit status 2", Command: lint, Failed: true})
+	exits.observe(Message{Type: OutputEnd, Command: lint, ExitCode: 2})
+	assert.Equal(t, 3, exits.code())
+}
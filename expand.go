@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// expandEnv replaces the $VAR and ${VAR} references in the config with the
+// values of the environment variables, or else of fileEnv, and $$ with a
+// literal $. References
+// that are not variable names, like $1 or $(port.stdout), are kept as they
+// are. When strict, referring to an undefined variable is an error naming
+// every such variable instead of expanding it to an empty string.
+func expandEnv(content []byte, fileEnv map[string]string, strict bool) ([]byte, error) {
+	var undefined []string
+	expanded := os.Expand(string(content), func(name string) string {
+		switch {
+		case name == "$":
+			return "$"
+		case !envName.MatchString(name):
+			return "${" + name + "}"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			value, ok = fileEnv[name]
+		}
+		if !ok && strict && !slices.Contains(undefined, name) {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	if len(undefined) > 0 {
+		return nil, fmt.Errorf("config refers to undefined environment variables: %s", strings.Join(undefined, ", "))
+	}
+	return []byte(expanded), nil
+}
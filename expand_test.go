@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("PSMGMT_TEST_PORT", "8080")
+	t.Setenv("PSMGMT_TEST_EMPTY", "")
+
+	for _, test := range []struct {
+		content, expected string
+	}{
+		{"--port=$PSMGMT_TEST_PORT", "--port=8080"},
+		{"--port=${PSMGMT_TEST_PORT}0", "--port=80800"},
+		{"[$PSMGMT_TEST_EMPTY]", "[]"},
+		{"[$PSMGMT_TEST_UNDEFINED]", "[]"},
+		{"$$HOME costs $$5", "$HOME costs $5"},
+		{"$(port.stdout) $1 ${2}", "$(port.stdout) ${1} ${2}"},
+	} {
+		expanded, err := expandEnv([]byte(test.content), nil, false)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, string(expanded), test.content)
+	}
+
+	// Strict expansion names every undefined variable once
+	_, err := expandEnv([]byte("$PSMGMT_TEST_EMPTY $PSMGMT_TEST_A ${PSMGMT_TEST_B} $PSMGMT_TEST_A"), nil, true)
+	assert.EqualError(t, err, "config refers to undefined environment variables: PSMGMT_TEST_A, PSMGMT_TEST_B")
+}
+
+func TestParseConfigExpandsEnv(t *testing.T) {
+	t.Setenv("PSMGMT_TEST_PORT", "8080")
+	config, err := parseConfig(strings.NewReader(`version: "1"
+apps:
+  - name: web
+    command: web
+    args: ["--port", "${PSMGMT_TEST_PORT}", "--root=$$HOME", "$PSMGMT_TEST_UNDEFINED"]
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--port", "8080", "--root=$HOME", ""}, config.Apps[0].Args)
+
+	*strictEnv = true
+	defer func() { *strictEnv = false }()
+	_, err = parseConfig(strings.NewReader("version: \"1\"\napps:\n  - name: web\n    command: web\n    args: [$PSMGMT_TEST_UNDEFINED]\n"))
+	assert.EqualError(t, err, "config refers to undefined environment variables: PSMGMT_TEST_UNDEFINED")
+}
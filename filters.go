@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// outputFilters holds per-command patterns that stdout and stderr lines must match
+// to be shown. They can be changed while psmgmt runs, e.g. to drill into a noisy
+// command during an incident without restarting it.
+type outputFilters struct {
+	mu       sync.RWMutex
+	patterns map[string]*regexp.Regexp
+}
+
+// newOutputFilters returns an empty set of filters, which lets every line through.
+func newOutputFilters() *outputFilters {
+	return &outputFilters{patterns: make(map[string]*regexp.Regexp)}
+}
+
+// set only lets the output lines of the named command through that match pattern.
+// An empty pattern removes the command's filter.
+func (f *outputFilters) set(name string, pattern string) error {
+	if pattern == "" {
+		f.mu.Lock()
+		delete(f.patterns, name)
+		f.mu.Unlock()
+		return nil
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.patterns[name] = compiled
+	f.mu.Unlock()
+	return nil
+}
+
+// allow reports whether the message passes the filter of its command.
+// Only stdout and stderr lines are filtered; lifecycle and error messages always pass.
+func (f *outputFilters) allow(message Message) bool {
+	if message.Type != OutputStdout && message.Type != OutputStderr {
+		return true
+	}
+
+	f.mu.RLock()
+	pattern, ok := f.patterns[message.CommandName()]
+	f.mu.RUnlock()
+	return !ok || pattern.MatchString(message.Content)
+}
+
+// headLimiter keeps only the first head_lines stdout and stderr lines of each
+// process of a command and drops the rest, leaving a note in place of the first dropped line.
+type headLimiter struct {
+	counts map[string]int
+}
+
+// newHeadLimiter returns a headLimiter with no lines counted.
+func newHeadLimiter() *headLimiter {
+	return &headLimiter{counts: make(map[string]int)}
+}
+
+// apply returns the message to show in place of the given one, and false if it is dropped.
+func (h *headLimiter) apply(message Message) (Message, bool) {
+	name := message.CommandName()
+	switch {
+	case message.Type == OutputRunning:
+		// Every process of the command, also a restarted one, starts over
+		delete(h.counts, name)
+		return message, true
+	case message.Command == nil || message.Command.HeadLines <= 0:
+		return message, true
+	case message.Type != OutputStdout && message.Type != OutputStderr:
+		return message, true
+	}
+
+	h.counts[name]++
+	limit := message.Command.HeadLines
+	switch {
+	case h.counts[name] <= limit:
+		return message, true
+	case h.counts[name] == limit+1:
+		message.Content = fmt.Sprintf("(output truncated after %d lines)", limit)
+		return message, true
+	}
+	return message, false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputFilters(t *testing.T) {
+	filters := newOutputFilters()
+	web := &Command{Name: "web"}
+	db := &Command{Name: "db"}
+
+	assert.True(t, filters.allow(Message{Type: OutputStdout, Content: "GET /", Command: web}))
+
+	assert.NoError(t, filters.set("web", "ERROR"))
+	assert.False(t, filters.allow(Message{Type: OutputStdout, Content: "GET /", Command: web}))
+	assert.True(t, filters.allow(Message{Type: OutputStderr, Content: "ERROR timeout", Command: web}))
+	assert.True(t, filters.allow(Message{Type: OutputEnd, Command: web}))
+	assert.True(t, filters.allow(Message{Type: OutputStdout, Content: "checkpoint", Command: db}))
+
+	assert.Error(t, filters.set("web", "("))
+
+	assert.NoError(t, filters.set("web", ""))
+	assert.True(t, filters.allow(Message{Type: OutputStdout, Content: "GET /", Command: web}))
+}
+
+func TestHeadLimiter(t *testing.T) {
+	head := newHeadLimiter()
+	banner := &Command{Name: "banner", HeadLines: 2}
+	chatty := &Command{Name: "chatty"}
+
+	contents := make([]string, 0)
+	for _, message := range []Message{
+		{Type: OutputStart, Command: banner},
+		{Type: OutputRunning, Command: banner},
+		{Type: OutputStdout, Content: "v1.2.3", Command: banner},
+		{Type: OutputStderr, Content: "config loaded", Command: banner},
+		{Type: OutputStdout, Content: "request 1", Command: banner},
+		{Type: OutputStdout, Content: "request 2", Command: banner},
+		{Type: OutputStdout, Content: "anything", Command: chatty},
+		{Type: OutputRunning, Command: banner},
+		{Type: OutputStdout, Content: "restarted", Command: banner},
+		{Type: OutputEnd, Command: banner},
+		{Type: OutputStart, Command: banner},
+		{Type: OutputRunning, Command: banner},
+		{Type: OutputStdout, Content: "v1.2.4", Command: banner},
+	} {
+		if message, ok := head.apply(message); ok {
+			contents = append(contents, message.Type.Name()+":"+message.Content)
+		}
+	}
+
+	assert.Equal(t, []string{
+		"OutputStart:",
+		"OutputRunning:",
+		"OutputStdout:v1.2.3",
+		"OutputStderr:config loaded",
+		"OutputStdout:(output truncated after 2 lines)",
+		"OutputStdout:anything",
+		"OutputRunning:",
+		"OutputStdout:restarted",
+		"OutputEnd:",
+		"OutputStart:",
+		"OutputRunning:",
+		"OutputStdout:v1.2.4",
+	}, contents)
+}
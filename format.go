@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Formats of the lines printed for the messages.
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// validateFormat checks the --format flag.
+func validateFormat(format string) error {
+	switch format {
+	case formatText, formatJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, expected %q or %q", format, formatText, formatJSON)
+}
+
+// jsonLine is a message printed with --format=json: its audit log record, with
+// the time elapsed since its command started when --elapsed is given.
+type jsonLine struct {
+	auditRecord
+	Elapsed string `json:"elapsed,omitempty"`
+}
+
+// writeJSONLine writes the message produced at now to w as a JSON object on a
+// line of its own.
+func writeJSONLine(w io.Writer, message Message, now time.Time, elapsed string) error {
+	line, err := json.Marshal(jsonLine{auditRecord: newAuditRecord(message, now), Elapsed: elapsed})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
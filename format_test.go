@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJSONLine(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	web := &Command{Name: "web"}
+	var output bytes.Buffer
+	assert.NoError(t, writeJSONLine(&output, Message{Content: "listening on :8080", Type: OutputStdout, Command: web, Seq: 3}, now, ""))
+	assert.NoError(t, writeJSONLine(&output, Message{Type: OutputEnd, Command: web, ExitCode: 1}, now, "+1.500s"))
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	assert.Len(t, lines, 2)
+	var line map[string]any
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "web", line["command"])
+	assert.Equal(t, "OutputStdout", line["type"])
+	assert.Equal(t, "listening on :8080", line["content"])
+	assert.Equal(t, "2024-05-01T12:00:00Z", line["time"])
+	assert.NotContains(t, line, "elapsed")
+
+	assert.JSONEq(t, `{"seq":0,"time":"2024-05-01T12:00:00Z","command":"web","type":"OutputEnd","exit_code":1,"elapsed":"+1.500s"}`, lines[1])
+}
+
+func TestMessageTypeJSON(t *testing.T) {
+	encoded, err := json.Marshal(map[string]MessageType{"type": OutputReady})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"type":"OutputReady"}`, string(encoded))
+}
+
+func TestValidateFormat(t *testing.T) {
+	assert.NoError(t, validateFormat(formatText))
+	assert.NoError(t, validateFormat(formatJSON))
+	assert.EqualError(t, validateFormat("yaml"), `unknown format "yaml", expected "text" or "json"`)
+}
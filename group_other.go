@@ -0,0 +1,20 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing on systems without Unix process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminate asks the process to exit gracefully with signal.
+func terminate(process *os.Process, signal syscall.Signal) error {
+	return process.Signal(signal)
+}
+
+// killGroup does nothing on systems without Unix process groups.
+func killGroup(process *os.Process) {}
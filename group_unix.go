@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command's process in a process group of its own,
+// so that its children, like the ones of a shell, are signalled along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	sysProcAttr(cmd).Setpgid = true
+}
+
+// terminate asks the process and the rest of its process group to exit
+// gracefully with signal. A process that doesn't lead a group of its own is
+// signalled alone.
+func terminate(process *os.Process, signal syscall.Signal) error {
+	err := syscall.Kill(-process.Pid, signal)
+	if errors.Is(err, syscall.ESRCH) {
+		return process.Signal(signal)
+	}
+	return err
+}
+
+// killGroup kills whatever is left of the process group of the process once it
+// exited, like children that ignored the stop signal.
+func killGroup(process *os.Process) {
+	_ = syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
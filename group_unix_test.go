@@ -0,0 +1,51 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// processGone reports whether the process exited, counting zombies that are
+// left for an init that doesn't reap them.
+func processGone(pid int) bool {
+	if errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+		return true
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	return err == nil && strings.Contains(string(stat), ") Z ")
+}
+
+func TestExecuteStopsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outputChan := make(chan Message, 2)
+	Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+		Name:        "shell",
+		Command:     "sh",
+		Args:        []string{"-c", "sleep 30 & echo $!; wait"},
+		StopTimeout: time.Second,
+	})
+
+	child := 0
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputStdout {
+			child, _ = strconv.Atoi(message.Content)
+			cancel()
+		}
+	})
+
+	assert.NotZero(t, child)
+	assert.Eventually(t, func() bool { return processGone(child) }, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Environment variables passed to the on_restart and on_crash hooks.
+const (
+	hookRestartsEnv = "PSMGMT_RESTARTS"
+	hookExitCodeEnv = "PSMGMT_EXIT_CODE"
+	hookSignalEnv   = "PSMGMT_SIGNAL"
+	hookLogFileEnv  = "PSMGMT_LOG_FILE"
+)
+
+// runRestartHook runs the on_restart hook of command before its restarts-th restart,
+// whether that restart ends up succeeding or not. The hook sees the restart count and
+// the exit code of the previous run in its environment. Its output is captured as
+// the output of a command named "<name>:on_restart" and it is waited for.
+func runRestartHook(ctx context.Context, clock Clock, outputChan chan<- Message, command Command, restarts int, exitCode int) error {
+	return runHook(ctx, clock, outputChan, command, "on_restart", command.OnRestart, map[string]string{
+		hookRestartsEnv: strconv.Itoa(restarts),
+		hookExitCodeEnv: strconv.Itoa(exitCode),
+	})
+}
+
+// runCrashHook runs the on_crash hook of command if its process ended abnormally,
+// with a non-zero exit code or by a signal, e.g. to collect a stack dump. The hook
+// sees the exit code, the name of the signal, if any, and the command's
+// log_file, or else its output_file, in its environment. Its output is captured as the output of a
+// command named "<name>:on_crash" and it is waited for.
+func runCrashHook(ctx context.Context, clock Clock, outputChan chan<- Message, command Command, state *os.ProcessState) error {
+	if state == nil || state.Success() {
+		return nil
+	}
+	var signal string
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		signal = signalName(status.Signal())
+	}
+	logFile := command.LogFile
+	if logFile == "" {
+		logFile = command.OutputFile
+	}
+	return runHook(ctx, clock, outputChan, command, "on_crash", command.OnCrash, map[string]string{
+		hookExitCodeEnv: strconv.Itoa(state.ExitCode()),
+		hookSignalEnv:   signal,
+		hookLogFileEnv:  logFile,
+	})
+}
+
+// runHook runs the hook set by the given option of command, with vars added to
+// its environment, and waits for it.
+func runHook(ctx context.Context, clock Clock, outputChan chan<- Message, command Command, option string, commandLine []string, vars map[string]string) error {
+	if len(commandLine) == 0 {
+		return nil
+	}
+	hook := Command{
+		Name:    command.Name + ":" + option,
+		Command: commandLine[0],
+		Args:    commandLine[1:],
+		Path:    command.Path,
+	}
+
+	name := hook.Command
+	env := os.Environ()
+	if len(hook.Path) > 0 {
+		var err error
+		name, err = lookPath(name, hook.Path)
+		if err != nil {
+			return fmt.Errorf("error resolving %s hook: %w", option, err)
+		}
+		env = setEnv(env, "PATH", strings.Join(hook.Path, string(os.PathListSeparator)))
+	}
+	cmd := exec.CommandContext(ctx, name, hook.Args...)
+	for _, key := range sortedKeys(vars) {
+		env = setEnv(env, key, vars[key])
+	}
+	cmd.Env = env
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error creating StdoutPipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("error creating StderrPipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s hook: %w", option, err)
+	}
+
+	// Read the output to the end before Wait closes the pipes
+	stdoutDone := captureOutput(stdout, clock, outputChan, hook, OutputStdout, nil, nil)
+	stderrDone := captureOutput(stderr, clock, outputChan, hook, OutputStderr, nil, nil)
+	<-stdoutDone
+	<-stderrDone
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("error running %s hook: %w", option, err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunRestartHook(t *testing.T) {
+	command := Command{
+		Name:      "web",
+		OnRestart: []string{"sh", "-c", "echo $PSMGMT_RESTARTS $PSMGMT_EXIT_CODE"},
+	}
+
+	outputChan := make(chan Message, 10)
+	err := runRestartHook(context.Background(), realClock{}, outputChan, command, 2, 1)
+	assert.NoError(t, err)
+	close(outputChan)
+
+	var messages []Message
+	for message := range outputChan {
+		messages = append(messages, message)
+	}
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "2 1", messages[0].Content)
+	assert.Equal(t, "web:on_restart", messages[0].CommandName())
+
+	command.OnRestart = []string{"sh", "-c", "exit 3"}
+	err = runRestartHook(context.Background(), realClock{}, make(chan Message, 10), command, 1, 0)
+	assert.EqualError(t, err, "error running on_restart hook: exit status 3")
+}
+
+func TestRunCrashHook(t *testing.T) {
+	command := Command{
+		Name:       "web",
+		OutputFile: "/var/log/web.log",
+		OnCrash:    []string{"sh", "-c", `echo "$PSMGMT_EXIT_CODE/$PSMGMT_SIGNAL/$PSMGMT_LOG_FILE"`},
+	}
+	for _, test := range []struct {
+		script string
+		lines  []string
+	}{
+		{"exit 0", nil},
+		{"exit 3", []string{"3//" + command.OutputFile}},
+		{"kill -SEGV $$", []string{"-1/SIGSEGV/" + command.OutputFile}},
+	} {
+		crashed := exec.Command("sh", "-c", test.script)
+		_ = crashed.Run()
+
+		outputChan := make(chan Message, 10)
+		err := runCrashHook(context.Background(), realClock{}, outputChan, command, crashed.ProcessState)
+		assert.NoError(t, err)
+		close(outputChan)
+
+		var lines []string
+		for message := range outputChan {
+			assert.Equal(t, "web:on_crash", message.CommandName())
+			lines = append(lines, message.Content)
+		}
+		assert.Equal(t, test.lines, lines, test.script)
+	}
+}
+
+func TestExecuteCrashHookLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.log")
+	// Room for every line, so that the app gets far ahead of the consumer
+	outputChan := make(chan Message, 500)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "web",
+		Command: "sh",
+		Args:    []string{"-c", "seq 200; echo last; exit 1"},
+		LogFile: path,
+		OnCrash: []string{"sh", "-c", `tail -n 1 "$PSMGMT_LOG_FILE"`},
+	})
+	files := newLogFiles()
+	var hook []string
+	streamLogs(outputChan, 1, func(message Message) {
+		time.Sleep(10 * time.Microsecond)
+		assert.NoError(t, files.Write(message))
+		if message.CommandName() == "web:on_crash" {
+			hook = append(hook, message.Content)
+		}
+	})
+	assert.NoError(t, files.Close())
+	assert.Equal(t, []string{"last"}, hook)
+}
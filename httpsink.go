@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// sinkAttempts is the number of times an HTTP sink sends a batch before it is dropped.
+	sinkAttempts = 3
+	// sinkRetryDelay is the delay before the first retry, doubled for every other one.
+	sinkRetryDelay = 200 * time.Millisecond
+)
+
+// sendWithRetries calls send until it succeeds, reports a failure that is not
+// worth retrying, or failed sinkAttempts times, and returns its last error.
+func sendWithRetries(clock Clock, send func() (bool, error)) error {
+	delay := sinkRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := send()
+		if err == nil || !retry || attempt == sinkAttempts {
+			return err
+		}
+		clock.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// postBatch posts the encoded batch to url once and returns the response body.
+// It reports whether a failure is worth retrying: network errors and responses
+// telling to come back later are.
+func postBatch(client *http.Client, url string, contentType string, body []byte) ([]byte, bool, error) {
+	resp, err := client.Post(url, contentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return content, false, nil
+	case retryableStatus(resp.StatusCode):
+		return nil, true, fmt.Errorf("server responded %s", resp.Status)
+	default:
+		return nil, false, fmt.Errorf("server responded %s", resp.Status)
+	}
+}
+
+// retryableStatus reports whether a response with the status code is transient.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+}
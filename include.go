@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// includedConfig is a config file included by another one, which only
+// contributes apps.
+type includedConfig struct {
+	Version string    `yaml:"version"`
+	Include []string  `yaml:"include"`
+	Apps    []Command `yaml:"apps"`
+}
+
+// includeApps appends the apps of the files the config includes, and of the
+// files those include in turn, to its apps. path is the file the config was
+// read from, which included files are relative to, or empty for the working
+// directory. The config may refer to the variables of fileEnv like its
+// including file.
+func includeApps(config *Config, path string, fileEnv map[string]string) error {
+	if len(config.Include) == 0 {
+		return nil
+	}
+	name := path
+	if name == "" {
+		name = "config"
+	}
+	owners := make(map[string]string, len(config.Apps))
+	for _, command := range config.Apps {
+		owners[strings.ToLower(command.Name)] = name
+	}
+	var chain []string
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("include: %w", err)
+		}
+		chain = append(chain, abs)
+	}
+	apps, err := readIncludes(config.Include, filepath.Dir(path), fileEnv, chain, owners)
+	if err != nil {
+		return err
+	}
+	config.Apps = append(config.Apps, apps...)
+	return nil
+}
+
+// readIncludes returns the apps of the included files, relative to dir. chain
+// holds the files including them, to stop at cycles, and owners the file
+// every app name seen so far is defined in, ignoring case.
+func readIncludes(files []string, dir string, fileEnv map[string]string, chain []string, owners map[string]string) ([]Command, error) {
+	var apps []Command
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", file, err)
+		}
+		for i, including := range chain {
+			if including == abs {
+				cycle := append(append([]string(nil), chain[i:]...), abs)
+				return nil, fmt.Errorf("config files include each other in a cycle: %s", strings.Join(cycle, " -> "))
+			}
+		}
+
+		included, err := readIncludedConfig(file, fileEnv)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %w", file, err)
+		}
+		for i, command := range included.Apps {
+			key := strings.ToLower(command.Name)
+			if owner, ok := owners[key]; ok {
+				return nil, fmt.Errorf("include %s: apps[%d] %q: duplicate name, already used in %s", file, i, command.Name, owner)
+			}
+			owners[key] = file
+			// Their env_file is relative to the file they are defined in
+			if command.EnvFile != "" {
+				included.Apps[i].EnvFile = envFilePath(file, command.EnvFile)
+			}
+		}
+		apps = append(apps, included.Apps...)
+
+		nested, err := readIncludes(included.Include, filepath.Dir(file), fileEnv, append(chain, abs), owners)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, nested...)
+	}
+	return apps, nil
+}
+
+// readIncludedConfig reads an included config file, substituting the
+// environment variables it refers to.
+func readIncludedConfig(file string, fileEnv map[string]string) (*includedConfig, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	content, err = expandEnv(content, fileEnv, *strictEnv)
+	if err != nil {
+		return nil, err
+	}
+	var included includedConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&included); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing YAML content: %w", err)
+	}
+	if included.Version != "" && included.Version != "1" {
+		return nil, errors.New("unsupported config version")
+	}
+	return &included, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	// Included files are relative to the file including them, also nested ones
+	root := write("psmgmt.yml", `version: "1"
+include: [workers/workers.yml]
+apps:
+  - name: web
+    command: ./server
+    depends_on: [db]
+`)
+	write("workers/workers.yml", `include: [../db.yml]
+apps:
+  - name: worker
+    command: ./worker
+`)
+	write("db.yml", `version: "1"
+apps:
+  - name: db
+    command: postgres
+`)
+	config, err := loadConfig(root)
+	assert.NoError(t, err)
+	var names []string
+	for _, command := range config.Apps {
+		names = append(names, command.Name)
+	}
+	assert.Equal(t, []string{"web", "worker", "db"}, names)
+
+	// App names are unique across files
+	write("db.yml", `apps:
+  - name: Web
+    command: ./other
+`)
+	_, err = loadConfig(root)
+	assert.ErrorContains(t, err, `apps[0] "Web": duplicate name, already used in `+root)
+
+	// Files can't include each other
+	self := write("self.yml", `version: "1"
+include: [self.yml]
+apps:
+  - name: web
+    command: ./server
+`)
+	_, err = loadConfig(self)
+	assert.ErrorContains(t, err, "config files include each other in a cycle: "+self+" -> "+self)
+
+	write("db.yml", `include: [psmgmt.yml]`)
+	_, err = loadConfig(root)
+	assert.ErrorContains(t, err, "in a cycle: "+root+" -> "+filepath.Join(dir, "workers", "workers.yml")+" -> "+filepath.Join(dir, "db.yml")+" -> "+root)
+
+	// Included files only contribute apps
+	write("db.yml", "max_concurrent: 1\n")
+	_, err = loadConfig(root)
+	assert.ErrorContains(t, err, "field max_concurrent not found")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// concurrencyLimit holds back commands from starting while max_concurrent
+// others run. A command holds its slot until its OutputEnd, including its
+// restarts.
+type concurrencyLimit struct {
+	slots   chan struct{}
+	mu      sync.Mutex
+	holders map[string]bool
+}
+
+// newConcurrencyLimit returns a limit of max running commands, or nil, which
+// doesn't limit them, when max is zero.
+func newConcurrencyLimit(max int) *concurrencyLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &concurrencyLimit{slots: make(chan struct{}, max), holders: make(map[string]bool)}
+}
+
+// acquire waits for a slot for the command to run in.
+func (l *concurrencyLimit) acquire(ctx context.Context, command Command) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("shut down while waiting for one of the max_concurrent %d slots", cap(l.slots))
+	case l.slots <- struct{}{}:
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.holders[command.Name] = true
+	return nil
+}
+
+// observe releases the slot of a command once it ended. Commands that never
+// got a slot, e.g. because an app they depend on failed, release nothing.
+func (l *concurrencyLimit) observe(message Message) {
+	if l == nil || message.Type != OutputEnd || message.Command == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holders[message.Command.Name] {
+		delete(l.holders, message.Command.Name)
+		<-l.slots
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	ctx := context.Background()
+	limit := newConcurrencyLimit(2)
+	outputChan := make(chan Message, 10)
+	wg := new(sync.WaitGroup)
+	const commands = 6
+	for i := 0; i < commands; i++ {
+		command := Command{Name: fmt.Sprintf("job%d", i), Command: "sleep", Args: []string{"0.05"}}
+		go func() {
+			assert.NoError(t, limit.acquire(ctx, command))
+			Execute(ctx, wg, outputChan, command)
+		}()
+	}
+
+	// Count the commands between their OutputRunning and OutputEnd
+	running, most := 0, 0
+	streamLogs(outputChan, commands, func(message Message) {
+		switch message.Type {
+		case OutputRunning:
+			running++
+			most = max(most, running)
+		case OutputEnd:
+			running--
+		}
+		limit.observe(message)
+	})
+	assert.Equal(t, 2, most)
+
+	// Without a limit, nothing waits
+	assert.Nil(t, newConcurrencyLimit(0))
+	assert.NoError(t, (*concurrencyLimit)(nil).acquire(ctx, Command{}))
+
+	// Shutting down stops the wait for a slot
+	limit = newConcurrencyLimit(1)
+	assert.NoError(t, limit.acquire(ctx, Command{Name: "a"}))
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.EqualError(t, limit.acquire(cancelled, Command{Name: "b"}), "shut down while waiting for one of the max_concurrent 1 slots")
+}
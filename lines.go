@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// defaultMaxLineBytes is the length of the longest output line kept whole,
+// unless max_line_bytes says otherwise.
+const defaultMaxLineBytes = 1 << 20
+
+// rawChunkBytes is the most raw_output sends in a single message.
+const rawChunkBytes = 32 << 10
+
+// lineReader splits output into lines like bufio.Scanner, without their "\n"
+// or "\r\n", but keeps reading after a line longer than max bytes, which is
+// truncated to it.
+type lineReader struct {
+	reader *bufio.Reader
+	max    int
+	line   []byte
+}
+
+// newLineReader returns a lineReader of r, keeping max bytes of every line, or
+// defaultMaxLineBytes if max isn't positive.
+func newLineReader(r io.Reader, max int) *lineReader {
+	if max <= 0 {
+		max = defaultMaxLineBytes
+	}
+	return &lineReader{reader: bufio.NewReader(r), max: max}
+}
+
+// next returns the next line, valid until the next call, and whether it was
+// truncated. The error is the one reading failed with once no line is left,
+// io.EOF at the end of the output.
+func (l *lineReader) next() ([]byte, bool, error) {
+	l.line = l.line[:0]
+	truncated := false
+	for {
+		chunk, err := l.reader.ReadSlice('\n')
+		chunk = bytes.TrimSuffix(chunk, []byte("\n"))
+		// Keep a byte more than max for the "\r" of a "\r\n" that may follow
+		if room := l.max + 1 - len(l.line); len(chunk) > room {
+			chunk, truncated = chunk[:room], true
+		}
+		l.line = append(l.line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && len(l.line) == 0 && !truncated {
+			return nil, false, err
+		}
+
+		l.line = bytes.TrimSuffix(l.line, []byte("\r"))
+		if len(l.line) > l.max {
+			l.line, truncated = l.line[:l.max], true
+		}
+		return l.line, truncated, nil
+	}
+}
+
+// chunkReader reads output in chunks of whatever arrived, up to rawChunkBytes,
+// for output not made of lines.
+type chunkReader struct {
+	reader io.Reader
+	chunk  []byte
+}
+
+// newChunkReader returns a chunkReader of r.
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{reader: r, chunk: make([]byte, rawChunkBytes)}
+}
+
+// next returns the next chunk, valid until the next call, like lineReader.next.
+// Chunks are never truncated.
+func (c *chunkReader) next() ([]byte, bool, error) {
+	for {
+		n, err := c.reader.Read(c.chunk)
+		if n > 0 {
+			return c.chunk[:n], false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+// isRaw reports whether the message is a chunk of raw_output, which keeps the
+// line endings of the output, rather than a line without its line ending.
+func (m Message) isRaw() bool {
+	return m.Command != nil && m.Command.RawOutput && (m.Type == OutputStdout || m.Type == OutputStderr)
+}
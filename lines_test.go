@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineReader(t *testing.T) {
+	type line struct {
+		text      string
+		truncated bool
+	}
+	read := func(output string, max int) []line {
+		lines := newLineReader(strings.NewReader(output), max)
+		var read []line
+		for {
+			text, truncated, err := lines.next()
+			if err != nil {
+				assert.Equal(t, io.EOF, err)
+				return read
+			}
+			read = append(read, line{string(text), truncated})
+		}
+	}
+
+	assert.Equal(t, []line{{"a", false}, {"", false}, {"b", false}, {"c", false}}, read("a\n\nb\r\nc", 0))
+	assert.Equal(t, []line{{"abcd", false}, {"abcd", true}, {"ab", false}}, read("abcd\r\nabcdef\nab\n", 4))
+	long := strings.Repeat("x", 100_000)
+	assert.Equal(t, []line{{long, false}, {"end", false}}, read(long+"\nend\n", 0))
+	assert.Equal(t, []line{{long[:70_000], true}, {"end", false}}, read(long+"\nend\n", 70_000))
+}
+
+func TestExecuteLongLine(t *testing.T) {
+	// Lines longer than the 64KB bufio.Scanner allows are kept, and so is the
+	// output after lines beyond max_line_bytes
+	for _, max := range []int{0, 70_000} {
+		outputChan := make(chan Message, 10)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:         "long",
+			Command:      "sh",
+			Args:         []string{"-c", `head -c 100000 /dev/zero | tr '\0' x; echo; echo end`},
+			MaxLineBytes: max,
+		})
+
+		var stdout []int
+		var errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputStdout:
+				stdout = append(stdout, len(message.Content))
+			case SystemError:
+				errors = append(errors, message.Content)
+			}
+		})
+		if max == 0 {
+			assert.Equal(t, []int{100_000, 3}, stdout)
+			assert.Empty(t, errors)
+		} else {
+			assert.Equal(t, []int{70_000, 3}, stdout)
+			assert.Equal(t, []string{"line truncated to 70000 bytes, raise max_line_bytes to keep longer lines"}, errors)
+		}
+	}
+}
+
+func TestExecuteRawOutput(t *testing.T) {
+	// Progress redrawn after a "\r" shows as it is printed, not once a "\n" ends it
+	outputChan := make(chan Message, 10)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:      "progress",
+		Command:   "sh",
+		Args:      []string{"-c", `printf '10%%\r'; sleep 0.3; printf '50%%\r'; sleep 0.3; printf '100%%\n'`},
+		RawOutput: true,
+	})
+
+	var chunks []string
+	var arrived []time.Time
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputStdout {
+			chunks = append(chunks, message.Content)
+			arrived = append(arrived, time.Now())
+		}
+	})
+	assert.Equal(t, []string{"10%\r", "50%\r", "100%\n"}, chunks)
+	if assert.Len(t, arrived, 3) {
+		assert.Greater(t, arrived[1].Sub(arrived[0]), 200*time.Millisecond)
+		assert.Greater(t, arrived[2].Sub(arrived[1]), 200*time.Millisecond)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Policies applied when the lock_file of a command is held by another process.
+const (
+	lockPolicySkip  = "skip"
+	lockPolicyAbort = "abort"
+)
+
+// errLockHeld is returned by acquireLock when another process holds the lock.
+var errLockHeld = errors.New("lock is held by another process")
+
+// validateLockPolicy checks the lock_policy of a command.
+func validateLockPolicy(command Command) error {
+	switch command.LockPolicy {
+	case "", lockPolicySkip, lockPolicyAbort:
+		return nil
+	}
+	return fmt.Errorf("unknown lock_policy %q, expected %q or %q", command.LockPolicy, lockPolicySkip, lockPolicyAbort)
+}
+
+// acquireLock takes an exclusive lock on the file at path without waiting for it,
+// creating the file if needed. Closing the returned file releases the lock.
+func acquireLock(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
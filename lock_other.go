@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile reports an error, since lock files rely on flock, which only exists on unix.
+func lockFile(file *os.File) error {
+	return errors.New("lock_file is only supported on unix")
+}
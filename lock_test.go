@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteLockFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("lock files are only supported on unix")
+	}
+
+	path := filepath.Join(t.TempDir(), "web.lock")
+	held, err := acquireLock(path)
+	assert.NoError(t, err)
+
+	// The lock held above can't be taken again, even from the same process
+	_, err = acquireLock(path)
+	assert.ErrorIs(t, err, errLockHeld)
+
+	for _, policy := range []string{lockPolicySkip, lockPolicyAbort} {
+		runner := NewRunner()
+		outputChan := make(chan Message, 10)
+		runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:       "web",
+			Command:    "true",
+			LockFile:   path,
+			LockPolicy: policy,
+		})
+
+		var messageTypes []MessageType
+		var errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			messageTypes = append(messageTypes, message.Type)
+			if message.Type == SystemError {
+				errors = append(errors, message.Content)
+			}
+		})
+
+		assert.NotContains(t, messageTypes, OutputRunning)
+		assert.Equal(t, []string{"lock_file " + path + " is held by another process, not starting"}, errors)
+		assert.Equal(t, policy == lockPolicyAbort, runner.Aborted())
+	}
+
+	// Once released, the command runs and releases the lock again on exit
+	assert.NoError(t, held.Close())
+	outputChan := make(chan Message, 10)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{Name: "web", Command: "true", LockFile: path})
+	var errors []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == SystemError {
+			errors = append(errors, message.Content)
+		}
+	})
+	assert.Empty(t, errors)
+	held, err = acquireLock(path)
+	assert.NoError(t, err)
+	held.Close()
+}
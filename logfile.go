@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// logFile is a log_file opened for the commands writing to it.
+type logFile struct {
+	file  *rotatingWriter
+	users map[string]bool
+}
+
+// logFiles is a Sink appending the output lines of every command with a
+// log_file to its file. Every line is written to the file right away. A file
+// is opened on the first line of a command and closed once every command
+// writing to it ended, so that commands may share a file. Files are rotated
+// according to the log_max_size and log_max_files of the command opening them.
+type logFiles struct {
+	mu    sync.Mutex
+	files map[string]*logFile
+}
+
+// newLogFiles returns a Sink for the log_file of the commands.
+func newLogFiles() *logFiles {
+	return &logFiles{files: make(map[string]*logFile)}
+}
+
+// Write appends output lines to the log_file of their command, and closes the
+// file once its command ended and no other command writes to it.
+func (l *logFiles) Write(message Message) error {
+	if message.Command == nil || message.Command.LogFile == "" {
+		return nil
+	}
+	path := filepath.Clean(message.Command.LogFile)
+	name := message.Command.Name
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch message.Type {
+	case OutputStdout, OutputStderr:
+		file, err := l.open(path, *message.Command)
+		if err != nil {
+			return err
+		}
+		file.users[name] = true
+		content := message.Content
+		if !message.isRaw() {
+			content += "\n"
+		}
+		_, err = file.file.Write([]byte(content))
+		return err
+	case OutputEnd:
+		file, ok := l.files[path]
+		if !ok {
+			return nil
+		}
+		delete(file.users, name)
+		if len(file.users) > 0 {
+			return nil
+		}
+		delete(l.files, path)
+		return file.file.Close()
+	}
+	return nil
+}
+
+// open returns the file at path, opening it for appending and creating it and
+// its parent directories as needed, rotated as the command sets.
+func (l *logFiles) open(path string, command Command) (*logFile, error) {
+	if file, ok := l.files[path]; ok {
+		return file, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	maxSize, maxFiles := logRotation(command)
+	file, err := openRotatingWriter(path, maxSize, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	l.files[path] = &logFile{file: file, users: make(map[string]bool)}
+	return l.files[path], nil
+}
+
+// Close closes the files that are still open.
+func (l *logFiles) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for path, file := range l.files {
+		errs = append(errs, file.file.Close())
+		delete(l.files, path)
+	}
+	return errors.Join(errs...)
+}
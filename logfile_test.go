@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "shared.log")
+	web := &Command{Name: "web", LogFile: path}
+	worker := &Command{Name: "worker", LogFile: path}
+	files := newLogFiles()
+
+	assert.NoError(t, files.Write(Message{Type: OutputStart, Command: web}))
+	assert.NoError(t, files.Write(Message{Content: "listening", Type: OutputStdout, Command: web}))
+	assert.NoError(t, files.Write(Message{Content: "working", Type: OutputStderr, Command: worker}))
+	assert.NoError(t, files.Write(Message{Content: "boom", Type: SystemError, Command: web}))
+
+	// The file stays open for the command that still writes to it
+	assert.NoError(t, files.Write(Message{Type: OutputEnd, Command: web}))
+	assert.Len(t, files.files, 1)
+	assert.NoError(t, files.Write(Message{Content: "done", Type: OutputStdout, Command: worker}))
+	assert.NoError(t, files.Write(Message{Type: OutputEnd, Command: worker}))
+	assert.Empty(t, files.files)
+	assert.NoError(t, files.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "listening\nworking\ndone\n", string(content))
+}
+
+func TestLogFilesRawOutput(t *testing.T) {
+	// Chunks of raw_output are written as they arrived
+	path := filepath.Join(t.TempDir(), "progress.log")
+	progress := &Command{Name: "progress", LogFile: path, RawOutput: true}
+	files := newLogFiles()
+	for _, chunk := range []string{"10%\r", "50%\r", "100%\n"} {
+		assert.NoError(t, files.Write(Message{Content: chunk, Type: OutputStdout, Command: progress}))
+	}
+	assert.NoError(t, files.Write(Message{Type: OutputEnd, Command: progress}))
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "10%\r50%\r100%\n", string(content))
+}
+
+func TestExecuteLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.log")
+	assert.NoError(t, os.WriteFile(path, []byte("previous run\n"), 0o644))
+
+	outputChan := make(chan Message, 2)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "web",
+		Command: "sh",
+		Args:    []string{"-c", "echo out; echo err >&2"},
+		LogFile: path,
+	})
+	files := newLogFiles()
+	streamLogs(outputChan, 1, func(message Message) {
+		assert.NoError(t, files.Write(message))
+	})
+	assert.NoError(t, files.Close())
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	assert.Equal(t, "previous run", lines[0])
+	assert.ElementsMatch(t, []string{"out", "err"}, lines[1:])
+}
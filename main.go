@@ -1,17 +1,22 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -20,6 +25,36 @@ import (
 type Config struct {
 	Version string    `yaml:"version"`
 	Apps    []Command `yaml:"apps"`
+	// RestartLimit caps the number of restarts across all apps.
+	RestartLimit *RestartLimit `yaml:"restart_limit"`
+	// Path lists the directories commands are looked up in, replacing $PATH.
+	Path []string `yaml:"path"`
+	// StartupDeadline is the time every app has to become ready in, after
+	// which psmgmt shuts down and exits with an error.
+	StartupDeadline time.Duration `yaml:"startup_deadline"`
+	// ShutdownTimeout is the time commands have to exit after SIGTERM on shutdown
+	// before they are killed, unless they set their own stop_timeout.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// SetEnv lists commands run when the config is loaded, in order, whose output
+	// becomes the value of an environment variable the config can refer to and
+	// every app gets.
+	SetEnv []SetEnv `yaml:"setenv"`
+	// MaxConcurrent is the number of commands that may run at once, the others
+	// waiting for one to end before they start. Zero doesn't limit them.
+	MaxConcurrent int `yaml:"max_concurrent"`
+	// Mode is how the apps run: "parallel", the default, runs them all at once,
+	// "sequential" one at a time, in order, stopping at the first failure.
+	Mode string `yaml:"mode"`
+	// EnvFile is a .env file of KEY=VALUE lines, relative to the config file,
+	// whose variables the config can refer to and every app gets, unless
+	// psmgmt runs with them already.
+	EnvFile string `yaml:"env_file"`
+	// Include lists config files, relative to this one, whose apps are added
+	// to the apps of this config.
+	Include []string `yaml:"include"`
+	// MaxLineBytes is the length of the longest output line of the apps kept
+	// whole, unless they set their own max_line_bytes. It defaults to 1MiB.
+	MaxLineBytes int `yaml:"max_line_bytes"`
 }
 
 // Command represents a system command to be executed.
@@ -30,6 +65,165 @@ type Command struct {
 	Command string `yaml:"command"`
 	// Args are the arguments to be passed to the command.
 	Args []string `yaml:"args"`
+	// Template renders Command and Args as templates when the config is loaded,
+	// see expandArgs. Without it they are taken literally, braces and all.
+	Template bool `yaml:"template"`
+	// Shell runs Command as a script of the shell, like "FOO=1 ./run | tee log",
+	// with Args as its positional parameters. See shellCommandLine.
+	Shell bool `yaml:"shell"`
+	// Env sets environment variables of the process, overriding inherited ones.
+	Env map[string]string `yaml:"env"`
+	// EnvFile is a .env file, relative to the config file defining the command,
+	// whose variables the process gets, unless Env or the environment of psmgmt
+	// sets them. It takes precedence over the top-level env_file.
+	EnvFile string `yaml:"env_file"`
+	// Restart is the restart policy of the command: "no", the default, "on-failure"
+	// to restart it when it exits with an error, or "always".
+	Restart string `yaml:"restart"`
+	// MaxRetries is the number of restarts after which the command is no longer
+	// restarted. Zero allows any number.
+	MaxRetries int `yaml:"max_retries"`
+	// MinUptime is the time the process has to stay up for, after which it is
+	// healthy. Exiting before that is a failed start.
+	MinUptime time.Duration `yaml:"min_uptime"`
+	// RestartBackoff is the delay before the first restart, doubled for every
+	// restart after a process exited soon after starting. It defaults to 100ms,
+	// which is not doubled.
+	RestartBackoff time.Duration `yaml:"restart_backoff"`
+	// RestartBackoffMax caps the doubled RestartBackoff and UnhealthyBackoff. It
+	// defaults to 1m.
+	RestartBackoffMax time.Duration `yaml:"restart_backoff_max"`
+	// RunOnce runs the command at most once per psmgmt process, e.g. a migration
+	// that apps restarted with restart_with depend on.
+	RunOnce bool `yaml:"run_once"`
+	// StartDelay is the time the command waits for after it started, before its
+	// process does, e.g. to stagger the starts of apps sharing a dependency.
+	StartDelay time.Duration `yaml:"start_delay"`
+	// Labels are attached to the command's messages sent to Loki or Elasticsearch.
+	Labels map[string]string `yaml:"labels"`
+	// Tags group apps, so that --tag runs the apps of a group only.
+	Tags []string `yaml:"tags"`
+	// WorkingDir is the directory the process is started in. It defaults to the
+	// working directory of psmgmt.
+	WorkingDir string `yaml:"working_dir"`
+	// ReadinessProbe checks that the command is available before it counts as
+	// ready, instead of it merely running.
+	ReadinessProbe *ReadinessProbe `yaml:"readiness_probe"`
+	// Cgroup places the process in its own cgroup v2 group (Linux only).
+	Cgroup *CgroupConfig `yaml:"cgroup"`
+	// Namespaces lists the Linux namespaces the process is started in,
+	// e.g. "net" or "mount".
+	Namespaces []string `yaml:"namespaces"`
+	// PipeThrough is a command, with its arguments, that stdout is piped through
+	// before it is captured, e.g. ["jq", "--unbuffered", "."].
+	PipeThrough []string `yaml:"pipe_through"`
+	// Builtin selects a built-in pseudo-command that runs inside psmgmt instead
+	// of spawning a process. The only built-in is "keepalive".
+	Builtin string `yaml:"builtin"`
+	// CPUs pins the process to a CPU list such as "0-3" or "0,2" (Linux only).
+	CPUs string `yaml:"cpus"`
+	// ReadyWhen is a regular expression; the command is considered ready once
+	// a line of its output matches it.
+	ReadyWhen string `yaml:"ready_when"`
+	// LogFile is a file the command's stdout and stderr lines are appended to,
+	// in addition to being printed.
+	LogFile string `yaml:"log_file"`
+	// LogMaxSize is the size, like "10M", beyond which the log_file is rotated.
+	LogMaxSize string `yaml:"log_max_size"`
+	// LogMaxFiles is the number of rotated log files kept. It defaults to 5.
+	LogMaxFiles int `yaml:"log_max_files"`
+	// OutputFile is a file that stdout is written to verbatim instead of being
+	// captured line by line, for commands producing binary output.
+	OutputFile string `yaml:"output_file"`
+	// Host runs the command on a remote machine over SSH, e.g. "user@host" or
+	// "user@host:2222". See runSSH.
+	Host string `yaml:"host"`
+	// Image runs the command inside a Docker container created from the image.
+	// Command may be left empty to use the image's default command.
+	Image string `yaml:"image"`
+	// ReloadSignal is sent to the process when the config is reloaded without
+	// changes to the command, e.g. "SIGHUP", so that it picks up the new
+	// environment instead of being restarted.
+	ReloadSignal string `yaml:"reload_signal"`
+	// HeadLines limits the displayed output to the first lines of each run.
+	HeadLines int `yaml:"head_lines"`
+	// Prefix is a template for the prefix of the command's lines, like "{{.Name}} |".
+	// It defaults to "[{{.Name}}::{{.Type}}]:".
+	Prefix string `yaml:"prefix"`
+	// RestartWith names the apps that are restarted whenever this command is
+	// restarted, e.g. because they hold a connection to it.
+	RestartWith []string `yaml:"restart_with"`
+	// DependsOn names the apps that must be ready before this command starts.
+	DependsOn []string `yaml:"depends_on"`
+	// StderrIsError escalates the command's stderr lines to errors. By default
+	// stderr is informational, as many programs log there as a matter of course.
+	StderrIsError bool `yaml:"stderr_is_error"`
+	// RawOutput sends the output of the command as it arrives instead of line
+	// by line, for output like progress bars redrawn after a "\r".
+	RawOutput bool `yaml:"raw_output"`
+	// OnRestart is a command, with its arguments, that is run every time the
+	// command is restarted.
+	OnRestart []string `yaml:"on_restart"`
+	// OnCrash is a command, with its arguments, that is run every time the
+	// process exits with a non-zero code or by a signal, e.g. to collect a
+	// stack dump.
+	OnCrash []string `yaml:"on_crash"`
+	// RestartMemoryThreshold is the resident memory, like "512M", above which the
+	// process is restarted (Linux only).
+	RestartMemoryThreshold string `yaml:"restart_memory_threshold"`
+	// LockFile is a file locked with flock while the command runs, so that only one
+	// process runs it at a time (unix only).
+	LockFile string `yaml:"lock_file"`
+	// LockPolicy is what happens when the lock is held elsewhere: "skip" the
+	// command, which is the default, or "abort" the whole run.
+	LockPolicy string `yaml:"lock_policy"`
+	// StopSignal is the signal that asks the process to exit gracefully, like
+	// "SIGQUIT". It defaults to SIGTERM.
+	StopSignal string `yaml:"stop_signal"`
+	// StopTimeout is the time the process has to exit after its stop signal on shutdown
+	// before it is killed. It defaults to the top-level shutdown_timeout, or 10s.
+	StopTimeout time.Duration `yaml:"stop_timeout"`
+	// MaxLineBytes is the length of the longest output line kept whole, longer
+	// ones being truncated. It defaults to the top-level max_line_bytes.
+	MaxLineBytes int `yaml:"max_line_bytes"`
+	// Timeout is the time every run of the command may take before its process
+	// is stopped like on shutdown, e.g. for a one-shot job that hangs.
+	Timeout time.Duration `yaml:"timeout"`
+	// Overrides change the command line on the platforms they are keyed by,
+	// a GOOS like "darwin" or a GOOS and GOARCH like "linux/arm64".
+	Overrides map[string]Override `yaml:"overrides"`
+	// StdinTemplate is a Go template rendered when the command starts and fed to
+	// its stdin, with .App holding the command and .Env its environment.
+	StdinTemplate string `yaml:"stdin_template"`
+	// Stdin is written to the stdin of the process, which is then closed, for
+	// tools reading a script from stdin.
+	Stdin string `yaml:"stdin"`
+	// Replace lists the rewrites applied, in order, to the command's output lines.
+	Replace []Replacement `yaml:"replace"`
+	// Path lists the directories the command is looked up in, replacing $PATH.
+	// It defaults to the top-level path.
+	Path []string `yaml:"path"`
+	// UnhealthyBackoff is the first extra delay before restarting the command after
+	// it crashed without becoming ready. It doubles with every such crash in a row,
+	// up to RestartBackoffMax.
+	UnhealthyBackoff time.Duration `yaml:"unhealthy_backoff"`
+	// MaxUnhealthyRestarts is the number of crashes in a row without becoming ready
+	// after which the command is no longer restarted.
+	MaxUnhealthyRestarts int `yaml:"max_unhealthy_restarts"`
+}
+
+// builtinKeepalive is the built-in pseudo-command that blocks until shutdown.
+const builtinKeepalive = "keepalive"
+
+// CgroupConfig describes the cgroup v2 group a command is placed in.
+type CgroupConfig struct {
+	// Parent is the cgroup directory under which the command's group is created.
+	// It defaults to /sys/fs/cgroup/psmgmt.
+	Parent string `yaml:"parent"`
+	// CPUMax is written verbatim to cpu.max, e.g. "50000 100000" for half a CPU.
+	CPUMax string `yaml:"cpu_max"`
+	// MemoryMax is written verbatim to memory.max, e.g. "256M".
+	MemoryMax string `yaml:"memory_max"`
 }
 
 // MessageType represents the type of message.
@@ -48,17 +242,37 @@ func (m MessageType) Name() string {
 		return "OutputEnd"
 	case SystemError:
 		return "SystemError"
+	case OutputReady:
+		return "OutputReady"
+	case OutputRunning:
+		return "OutputRunning"
+	case OutputStopped:
+		return "OutputStopped"
+	case OutputCommand:
+		return "OutputCommand"
+	case OutputExited:
+		return "OutputExited"
 	}
 	return "Unknown"
 }
 
+// MarshalText encodes the MessageType as its name, e.g. in JSON.
+func (m MessageType) MarshalText() ([]byte, error) {
+	return []byte(m.Name()), nil
+}
+
 // Message types
 const (
-	OutputStart  MessageType = iota // OutputStart indicates the start of command output.
-	OutputStdout                    // OutputStdout indicates stdout output from the command.
-	OutputStderr                    // OutputStderr indicates stderr output from the command.
-	OutputEnd                       // OutputEnd indicates the end of command output.
-	SystemError                     // SystemError indicates an error related to the system or command execution.
+	OutputStart   MessageType = iota // OutputStart indicates the start of command output.
+	OutputStdout                     // OutputStdout indicates stdout output from the command.
+	OutputStderr                     // OutputStderr indicates stderr output from the command.
+	OutputEnd                        // OutputEnd indicates the end of command output.
+	SystemError                      // SystemError indicates an error related to the system or command execution.
+	OutputReady                      // OutputReady indicates the command's output matched its ready_when pattern.
+	OutputRunning                    // OutputRunning indicates the command's process was started; Pid carries its PID.
+	OutputStopped                    // OutputStopped indicates how the command's process stopped on shutdown.
+	OutputCommand                    // OutputCommand carries the command line the process is started with, with secrets redacted.
+	OutputExited                     // OutputExited indicates the command's process exited, before any restart; ExitCode carries its exit code.
 )
 
 // Message represents a message containing the content, type, and associated command.
@@ -69,149 +283,953 @@ type Message struct {
 	Type MessageType
 	// Command is the associated command.
 	Command *Command
+	// Pid is the process ID of the command, set on OutputRunning messages.
+	Pid int
+	// IsError escalates an output line to an error for alerting, which stderr
+	// lines only are for commands with stderr_is_error.
+	IsError bool
+	// Raw is the output line before the replace rules of the command changed it.
+	Raw string
+	// Seq orders the messages of all commands as they were produced, starting at 1.
+	Seq uint64
+	// Timestamp is the time the message was produced, e.g. when its line was read,
+	// which may be well before it is printed.
+	Timestamp time.Time
+	// ExitCode is the exit code of the process, set on OutputExited and OutputEnd
+	// messages. It is -1 if the process was killed by a signal or never ran.
+	ExitCode int
+	// Failed marks a SystemError reporting that the command failed, unlike
+	// notices such as a restart or a truncated line.
+	Failed bool
+	// written, if set, is closed once the consumer handed the message to the
+	// sinks, and so wrote the messages produced before it too.
+	written chan struct{}
 }
 
+// systemName is the name messages without a command are printed with.
+const systemName = "system"
+
 // CommandName returns the name of the associated command, or "system" if no command is present.
 func (m Message) CommandName() string {
 	if m.Command != nil {
 		return m.Command.Name
 	}
-	return "system"
+	return systemName
+}
+
+// markWritten tells the producer of the message that it was handled, if it
+// waits for that.
+func (m Message) markWritten() {
+	if m.written != nil {
+		close(m.written)
+	}
+}
+
+// messageSeq is the sequence number of the last message produced.
+var messageSeq atomic.Uint64
+
+// send stamps the message with the next sequence number and the time of the
+// clock, unless it has one, and sends it to the outputChan. Every message is produced
+// through it, so that consumers can restore the order in which messages were
+// produced across commands.
+func send(clock Clock, outputChan chan<- Message, message Message) {
+	message.Seq = messageSeq.Add(1)
+	if message.Timestamp.IsZero() {
+		message.Timestamp = clock.Now()
+	}
+	outputChan <- message
+}
+
+// timestampLayout is the RFC 3339 layout, with milliseconds, of the timestamps
+// of printed messages.
+const timestampLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// timestampWriter stamps every line logged through it with the time of the
+// clock in timestampLayout, so that psmgmt's own lines look like the printed
+// messages.
+type timestampWriter struct {
+	clock Clock
+	w     io.Writer
+}
+
+func (t timestampWriter) Write(p []byte) (int, error) {
+	// A single write, not to be interleaved with printed messages
+	line := append([]byte(t.clock.Now().Format(timestampLayout)+" "), p...)
+	if _, err := t.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Execute executes the given command in a separate goroutine with a new Runner.
+// See Runner.Execute.
+func Execute(ctx context.Context, wg *sync.WaitGroup, outputChan chan<- Message, command Command) {
+	NewRunner().Execute(ctx, wg, outputChan, command)
 }
 
 // Execute executes the given command in a separate goroutine.
 // It captures the command output and sends it to the outputChan.
 // It also handles errors and sends error messages to the outputChan.
-func Execute(ctx context.Context, wg *sync.WaitGroup, outputChan chan<- Message, command Command) {
+func (r *Runner) Execute(ctx context.Context, wg *sync.WaitGroup, outputChan chan<- Message, command Command) {
+	// Count the goroutine before it starts, so that wg.Wait can't return before
+	// it even ran
+	wg.Add(1)
 	go func(ctx context.Context, wg *sync.WaitGroup, outputChan chan<- Message, command Command) {
 		// Defer wg.Done to ensure it is called even if the goroutine panics
-		wg.Add(1)
 		defer wg.Done()
 
-		outputChan <- Message{
+		// Commands that run once are not run again, e.g. when a cascade restarts them
+		if command.RunOnce && !r.claimRun(command.Name) {
+			send(r.Clock, outputChan, Message{Type: OutputStart, Command: &command})
+			send(r.Clock, outputChan, Message{Content: "already ran once, not running again", Type: OutputEnd, Command: &command})
+			return
+		}
+
+		r.track(command)
+		send(r.Clock, outputChan, Message{
 			Type:    OutputStart,
 			Command: &command,
-		}
+		})
+		exitCode := -1
 		defer func() {
-			outputChan <- Message{
-				Type:    OutputEnd,
-				Command: &command,
+			if ctx.Err() != nil {
+				r.update(command.Name, stateStopped, nil)
+			} else {
+				r.update(command.Name, stateExited, nil)
 			}
+			send(r.Clock, outputChan, Message{
+				Type:     OutputEnd,
+				Command:  &command,
+				ExitCode: exitCode,
+			})
 		}()
 
-		// Execute system command with context
-		cmd := exec.CommandContext(ctx, command.Command, command.Args...)
+		// The keepalive built-in blocks until shutdown without spawning a process
+		if command.Builtin == builtinKeepalive {
+			r.running(command.Name, 0, nil)
+			<-ctx.Done()
+			exitCode = 0
+			return
+		}
 
-		// Create pipes to capture stdout and stderr
-		stdout, err := cmd.StdoutPipe()
-		if err != nil {
-			outputChan <- Message{
-				Content: fmt.Errorf("error creating StdoutPipe: %w", err).Error(),
+		// Wait for the start_delay, unless shutting down in the meantime, in
+		// which case the process isn't started at all
+		if command.StartDelay > 0 {
+			select {
+			case <-ctx.Done():
+				exitCode = 0
+				return
+			case <-r.Clock.After(command.StartDelay):
+			}
+		}
+
+		// Run the process, and again for as long as its restart policy says so
+		var restarts <-chan string
+		if !command.RunOnce {
+			restarts = r.watchRestarts(command.Name)
+			defer r.unwatchRestarts(command.Name, restarts)
+		}
+		guard := newCrashGuard(command)
+		backoff := newRestartBackoff(command)
+		for attempt := 1; ; attempt++ {
+			result := r.run(ctx, outputChan, command, restarts)
+			exitCode = result.exitCode
+			if !r.restart(ctx, outputChan, command, result, guard, backoff, attempt) {
+				return
+			}
+			// The restart covers the ones requested in the meantime
+			select {
+			case <-restarts:
+			default:
+			}
+		}
+	}(ctx, wg, outputChan, command)
+}
+
+// runResult describes how a single run of a command's process ended.
+type runResult struct {
+	// started is set once the process was started.
+	started bool
+	// err is the error waiting for the process returned.
+	err error
+	// exitCode is the exit code of the process, or -1 if it was killed by a
+	// signal or never started.
+	exitCode int
+	// uptime is the time the process ran for.
+	uptime time.Duration
+	// healthy is set if the process became ready before exiting.
+	healthy bool
+	// restartRequested is set if psmgmt stopped the process to restart it.
+	restartRequested bool
+	// cascaded is set if the process was restarted along with another app.
+	cascaded bool
+}
+
+// run starts the process of the command and waits for it to exit. It stops the
+// process to restart it when the name of an app it is restarted with arrives
+// on restarts.
+func (r *Runner) run(ctx context.Context, outputChan chan<- Message, command Command, restarts <-chan string) runResult {
+	result := runResult{exitCode: -1}
+	var restartRequested atomic.Bool
+
+	// Watch the output for the ready_when pattern
+	gate, err := newReadyGate(command)
+	if err != nil {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Errorf("error compiling ready_when: %w", err).Error(),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+		return result
+	}
+
+	// Make sure no other process runs the command at the same time
+	if command.LockFile != "" {
+		lock, err := acquireLock(command.LockFile)
+		if errors.Is(err, errLockHeld) {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Sprintf("lock_file %s is held by another process, not starting", command.LockFile),
 				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			if command.LockPolicy == lockPolicyAbort {
+				r.abort()
 			}
-			return
+			return result
+		} else if err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error acquiring lock_file: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
 		}
+		defer lock.Close()
+	}
 
-		stderr, err := cmd.StderrPipe()
+	if dir := localWorkingDir(command); dir != "" {
+		if err := checkWorkingDir(dir); err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: err.Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+	}
+
+	// Execute system command with context
+	name, args := commandLine(command)
+	if len(command.Path) > 0 {
+		name, err = lookPath(name, command.Path)
 		if err != nil {
-			outputChan <- Message{
-				Content: fmt.Errorf("error creating StderrPipe: %w", err).Error(),
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error resolving command: %w", err).Error(),
 				Type:    SystemError,
-			}
-			return
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+	}
+	// A command with a timeout is stopped on its own once it ran for too long,
+	// and any command with stopRun, like on shutdown, to restart it
+	timeoutCtx := ctx
+	if command.Timeout > 0 {
+		var cancel context.CancelFunc
+		timeoutCtx, cancel = context.WithTimeout(ctx, command.Timeout)
+		defer cancel()
+	}
+	runCtx, stopRun := context.WithCancel(timeoutCtx)
+	defer stopRun()
+	cmd := exec.CommandContext(runCtx, name, args...)
+	// Ask the process and its children to stop on shutdown, and kill it if it
+	// doesn't in time
+	cmd.Cancel = func() error { return terminate(cmd.Process, stopSignal(command)) }
+	cmd.WaitDelay = stopTimeout(command)
+	cmd.Env = commandEnv(command)
+	cmd.Dir = localWorkingDir(command)
+	setProcessGroup(cmd)
+
+	// Place the process in its own cgroup when configured
+	cleanupCgroup, err := setupCgroup(cmd, command)
+	if err != nil {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Errorf("error setting up cgroup: %w", err).Error(),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+		return result
+	}
+	defer func() {
+		if err := cleanupCgroup(); err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error removing cgroup: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
 		}
+	}()
 
-		// Capture stdout and stderr output
-		captureOutput(ctx, stdout, outputChan, command, OutputStdout)
-		captureOutput(ctx, stderr, outputChan, command, OutputStderr)
+	// Start the process in new namespaces when configured
+	err = setupNamespaces(cmd, command)
+	if err != nil {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Errorf("error setting up namespaces: %w", err).Error(),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+		return result
+	}
 
-		// Start the command
-		err = cmd.Start()
+	// Create pipes to capture stdout and stderr, counting what is captured.
+	// Unlike with StdoutPipe, Wait doesn't wait for them to be closed, which
+	// the children of the process may not do when it exits. Only the process
+	// needs their write ends.
+	counters := r.throughput.command(command.Name)
+	var stdout *os.File
+	var writeEnds []*os.File
+	closeWriteEnds := func() {
+		for _, w := range writeEnds {
+			w.Close()
+		}
+		writeEnds = nil
+	}
+	defer closeWriteEnds()
+	if command.OutputFile != "" {
+		// Write stdout verbatim to the file without scanning it
+		file, err := os.Create(command.OutputFile)
 		if err != nil {
-			outputChan <- Message{
-				Content: fmt.Errorf("error starting command: %w", err).Error(),
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error creating output_file: %w", err).Error(),
 				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+		defer file.Close()
+		cmd.Stdout = file
+	} else if len(command.PipeThrough) > 0 {
+		// Route stdout through the transform command and capture its output instead
+		waitTransform, err := startTransform(cmd, r.Clock, outputChan, command, gate, counters)
+		if err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error starting pipe_through command: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+		defer func() {
+			if err := waitTransform(); err != nil {
+				send(r.Clock, outputChan, Message{
+					Content: fmt.Errorf("error waiting for pipe_through command: %w", err).Error(),
+					Type:    SystemError,
+					Command: &command,
+					Failed:  true,
+				})
 			}
-			return
+		}()
+	} else {
+		stdout, cmd.Stdout, err = outputPipe(&writeEnds)
+		if err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error creating stdout pipe: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+		defer stdout.Close()
+	}
+
+	stderr, stderrWriter, err := outputPipe(&writeEnds)
+	if err != nil {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Errorf("error creating stderr pipe: %w", err).Error(),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+		return result
+	}
+	defer stderr.Close()
+	cmd.Stderr = stderrWriter
+
+	// Failing to reach the host is told apart from the remote command failing
+	var connectionErrors *os.File
+	if command.Host != "" {
+		connectionErrors, err = sshErrors(cmd, &writeEnds)
+		if err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error creating ssh errors pipe: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+		defer connectionErrors.Close()
+	}
+
+	// Feed stdin or the rendered stdin_template to the process, with its
+	// runtime environment
+	stdinContent := command.Stdin
+	if command.StdinTemplate != "" {
+		env := cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		stdinContent, err = renderStdin(command, env)
+		if err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error rendering stdin_template: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
 		}
+	}
+	var stdin io.WriteCloser
+	if stdinContent != "" {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error creating StdinPipe: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+			return result
+		}
+	}
+
+	// Record exactly what runs, right before it does
+	send(r.Clock, outputChan, Message{
+		Content: redactedCommandLine(cmd.Path, cmd.Args[1:]),
+		Type:    OutputCommand,
+		Command: &command,
+	})
+
+	// Start the command
+	err = cmd.Start()
+	closeWriteEnds()
+	if err != nil {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Errorf("error starting command: %w", err).Error(),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+		return result
+	}
+	result.started = true
+	started := r.Clock.Now()
+	r.running(command.Name, cmd.Process.Pid, gate)
+	send(r.Clock, outputChan, Message{
+		Type:    OutputRunning,
+		Command: &command,
+		Pid:     cmd.Process.Pid,
+	})
+
+	var wroteStdin <-chan struct{}
+	if stdin != nil {
+		wroteStdin = writeStdin(r.Clock, outputChan, command, stdin, stdinContent)
+	}
+
+	// Capture stdout and stderr output, which the pipes buffer until read,
+	// so that it follows the OutputRunning message
+	var captured []<-chan struct{}
+	if stdout != nil {
+		captured = append(captured, captureOutput(stdout, r.Clock, outputChan, command, OutputStdout, gate, counters.stream(OutputStdout)))
+	}
+	captured = append(captured, captureOutput(stderr, r.Clock, outputChan, command, OutputStderr, gate, counters.stream(OutputStderr)))
+
+	// Stop the container on shutdown, which killing the docker client doesn't do
+	if command.Image != "" {
+		exited := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(exited)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			select {
+			case <-runCtx.Done():
+				if err := stopContainer(command); err != nil {
+					send(r.Clock, outputChan, Message{
+						Content: fmt.Errorf("error stopping container: %w", err).Error(),
+						Type:    SystemError,
+						Command: &command,
+						Failed:  true,
+					})
+				}
+			case <-exited:
+			}
+		}()
+	}
 
-		// Wait for the command to finish
-		err = cmd.Wait()
+	// Pin the process to the configured CPUs
+	if command.CPUs != "" {
+		cpus, err := parseCPUList(command.CPUs)
+		if err == nil {
+			err = setAffinity(cmd.Process.Pid, cpus)
+		}
 		if err != nil {
-			outputChan <- Message{
-				Content: fmt.Errorf("error waiting for command: %w", err).Error(),
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Errorf("error setting CPU affinity: %w", err).Error(),
 				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+		}
+	}
+
+	// Restart the process once it uses more memory than allowed
+	if command.RestartMemoryThreshold != "" {
+		threshold, _ := parseSize(command.RestartMemoryThreshold)
+		exited := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(exited)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			// Exceeding the threshold is a notice of the restart
+			exceeded, err := r.watchMemory(cmd.Process, threshold, exited, func() {
+				restartRequested.Store(true)
+				stopRun()
+			})
+			if err != nil {
+				send(r.Clock, outputChan, Message{
+					Content: err.Error(),
+					Type:    SystemError,
+					Command: &command,
+					Failed:  !exceeded,
+				})
 			}
+		}()
+	}
+
+	// Check the readiness_probe until the process is available
+	if command.ReadinessProbe != nil {
+		exited := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(exited)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			r.probeReadiness(ctx, outputChan, command, gate, exited)
+		}()
+	}
+
+	// Restart the process along with the app it is restarted with
+	cascaded := make(chan bool, 1)
+	if restarts != nil {
+		exited := make(chan struct{})
+		stopped := make(chan struct{})
+		defer func() {
+			close(exited)
+			<-stopped
+		}()
+		go func() {
+			defer close(stopped)
+			select {
+			case cause := <-restarts:
+				restartRequested.Store(true)
+				cascaded <- true
+				send(r.Clock, outputChan, Message{
+					Content: fmt.Sprintf("restarting along with %s", cause),
+					Type:    SystemError,
+					Command: &command,
+				})
+				stopRun()
+			case <-exited:
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	// Wait for the command to finish, which the output pipes don't hold up
+	err = cmd.Wait()
+	if runCtx.Err() != nil {
+		killGroup(cmd.Process)
+	}
+	// Shutting down takes precedence over timing out
+	timedOut := ctx.Err() == nil && timeoutCtx.Err() != nil
+
+	// Read the output to the end, so that no line is lost or sent after
+	// OutputEnd, including the ones printed while the command stops. Its
+	// children may hold the pipes open after it exited, so once its
+	// stop_timeout is over close them instead.
+	outputRead := make(chan struct{})
+	go func() {
+		for _, done := range captured {
+			<-done
 		}
-	}(ctx, wg, outputChan, command)
+		close(outputRead)
+	}()
+	unread := time.NewTimer(cmd.WaitDelay)
+	select {
+	case <-outputRead:
+	case <-unread.C:
+		if stdout != nil {
+			stdout.Close()
+		}
+		stderr.Close()
+		<-outputRead
+	}
+	unread.Stop()
+	// Wait closed stdin, so the write ended too
+	if wroteStdin != nil {
+		<-wroteStdin
+	}
+	result.err = err
+	result.uptime = r.Clock.Now().Sub(started)
+	if cmd.ProcessState != nil {
+		result.exitCode = cmd.ProcessState.ExitCode()
+	}
+	r.exited(command.Name, result.exitCode)
+	exited := Message{Type: OutputExited, Command: &command, ExitCode: result.exitCode}
+	if len(command.OnCrash) > 0 && command.LogFile != "" {
+		// The on_crash hook reads the log_file, which the consumer writes
+		exited.written = make(chan struct{})
+	}
+	send(r.Clock, outputChan, exited)
+	result.healthy = gate == nil || gate.isReady()
+	result.restartRequested = restartRequested.Load()
+	select {
+	case result.cascaded = <-cascaded:
+	default:
+	}
+	if ctx.Err() != nil {
+		// On shutdown the process was asked to stop, which isn't an error
+		// unless it had to be killed
+		content := "stopped gracefully"
+		if wasKilled(cmd.ProcessState) {
+			content = fmt.Sprintf("killed after stop_timeout of %s", cmd.WaitDelay)
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Sprintf("did not stop within stop_timeout of %s, killed", cmd.WaitDelay),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+		}
+		send(r.Clock, outputChan, Message{
+			Content: content,
+			Type:    OutputStopped,
+			Command: &command,
+		})
+	} else if timedOut {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Sprintf("command timed out after %s", command.Timeout),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+	} else if result.restartRequested && wasSignaled(cmd.ProcessState) {
+		// Stopping the process to restart it isn't an error either, unless it
+		// had to be killed
+		if wasKilled(cmd.ProcessState) {
+			send(r.Clock, outputChan, Message{
+				Content: fmt.Sprintf("did not stop within stop_timeout of %s, killed", cmd.WaitDelay),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+		} else {
+			result.err = nil
+		}
+	} else if connectionErr := readSSHErrors(connectionErrors); err != nil && connectionErr != "" {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Sprintf("error running command on %s: ssh connection failed: %s", command.Host, connectionErr),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+	} else if err != nil {
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Errorf("error waiting for command: %w", err).Error(),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+	}
+
+	// A command with a ready_when pattern must match it before exiting
+	if gate != nil && !gate.isReady() {
+		content := "command exited before matching ready_when"
+		if command.ReadinessProbe != nil {
+			content = "command exited before passing readiness_probe"
+		}
+		send(r.Clock, outputChan, Message{
+			Content: content,
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+	}
+
+	// A command with a min_uptime has to stay up for it to have started at all
+	if ctx.Err() == nil && !result.restartRequested && result.uptime < command.MinUptime {
+		result.healthy = false
+		r.failedStart(command.Name)
+		send(r.Clock, outputChan, Message{
+			Content: fmt.Sprintf("exited after %s, before min_uptime of %s, failed to start", result.uptime.Round(time.Millisecond), command.MinUptime),
+			Type:    SystemError,
+			Command: &command,
+			Failed:  true,
+		})
+	}
+
+	// Processes stopped by psmgmt itself didn't crash
+	if ctx.Err() == nil && !result.restartRequested {
+		// Let the last lines of the command reach its log_file first
+		if exited.written != nil {
+			select {
+			case <-exited.written:
+			case <-ctx.Done():
+			}
+		}
+		if err := runCrashHook(ctx, r.Clock, outputChan, command, cmd.ProcessState); err != nil {
+			send(r.Clock, outputChan, Message{
+				Content: err.Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+		}
+	}
+	return result
+}
+
+// sysProcAttr returns the SysProcAttr of cmd, allocating it if needed.
+func sysProcAttr(cmd *exec.Cmd) *syscall.SysProcAttr {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	return cmd.SysProcAttr
+}
+
+// outputPipe creates a pipe for the output of a process, adding its write end
+// to writeEnds, which the caller closes once the process started.
+func outputPipe(writeEnds *[]*os.File) (*os.File, *os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	*writeEnds = append(*writeEnds, w)
+	return r, w, nil
 }
 
 // captureOutput captures the output from the given io.ReadCloser and sends it to the outputChan.
-// It runs in a separate goroutine and stops when the context is canceled or when the io.ReadCloser is closed.
-func captureOutput(ctx context.Context, std io.ReadCloser, outputChan chan<- Message, command Command, messageType MessageType) {
-	stdScanner := bufio.NewScanner(std)
+// It runs in a separate goroutine and stops when the io.ReadCloser reaches EOF or is closed,
+// also on shutdown, so that the lines a command prints while it stops aren't lost.
+// Lines longer than the max_line_bytes of the command are truncated, with a SystemError saying so.
+// With raw_output the output is sent in chunks as it arrives, which are then handled like lines.
+// Every line is checked against the ready gate and counted by the counter, which may both be nil.
+// The returned channel is closed once the goroutine stopped sending messages.
+func captureOutput(std io.ReadCloser, clock Clock, outputChan chan<- Message, command Command, messageType MessageType, gate *readyGate, counter *streamCounter) <-chan struct{} {
+	var next func() ([]byte, bool, error)
+	var max int
+	if command.RawOutput {
+		next = newChunkReader(std).next
+	} else {
+		lines := newLineReader(std, command.MaxLineBytes)
+		next, max = lines.next, lines.max
+	}
+	done := make(chan struct{})
 	go func() {
-		for stdScanner.Scan() {
-			select {
-			case <-ctx.Done():
+		defer close(done)
+		for {
+			line, truncated, err := next()
+			if err != nil {
 				return
-			default:
-				// Send the line to the output channel
-				outputChan <- Message{
-					Content: stdScanner.Text(),
-					Type:    messageType,
+			}
+			text := string(line)
+
+			// Send the line to the output channel
+			send(clock, outputChan, Message{
+				Content: text,
+				Type:    messageType,
+				Command: &command,
+				IsError: messageType == OutputStderr && command.StderrIsError,
+			})
+			if truncated {
+				send(clock, outputChan, Message{
+					Content: fmt.Sprintf("line truncated to %d bytes, raise max_line_bytes to keep longer lines", max),
+					Type:    SystemError,
 					Command: &command,
-				}
+				})
 			}
+			counter.add(len(line))
+			gate.check(text, clock, outputChan, &command)
 		}
 	}()
+	return done
 }
 
 // streamLogs streams log messages from the output channel and invokes the callback function for each message.
-// It waits for all commands to complete before returning.
+// It waits for all commands to complete before returning, then drains the messages still buffered in the channel.
 func streamLogs(outputChan <-chan Message, amountOfCommands int, callback func(message Message)) {
+	// Without commands no OutputEnd ever arrives
+	if amountOfCommands == 0 {
+		return
+	}
+	streamLifecycles(outputChan, newLifecycles(amountOfCommands), callback)
+}
+
+// streamLifecycles is streamLogs for commands that may be started again while
+// their messages are streamed, until the last OutputEnd counted by apps.
+func streamLifecycles(outputChan <-chan Message, apps *lifecycles, callback func(message Message)) {
 	for message := range outputChan {
 		callback(message)
+		message.markWritten()
+		if apps.end(message) {
+			drainLogs(outputChan, callback)
+			return
+		}
+	}
+}
 
-		// Check if the message type is OutputEnd
-		if message.Type == OutputEnd {
-			// Decrement the amountOfCommands counter
-			amountOfCommands--
-
-			// Check if all commands have completed and exit the function
-			if amountOfCommands == 0 {
+// drainLogs invokes the callback for the messages already in the output
+// channel, e.g. the ones of another command buffered behind the last
+// OutputEnd, without waiting for more.
+func drainLogs(outputChan <-chan Message, callback func(message Message)) {
+	for {
+		select {
+		case message, ok := <-outputChan:
+			if !ok {
 				return
 			}
+			callback(message)
+			message.markWritten()
+		default:
+			return
 		}
 	}
 }
 
-// loadConfig loads the configuration from a YAML file.
-// It expects the path to the config file as a command-line argument.
-// If the file is valid and the version is supported, it returns a Config object.
-// Otherwise, it returns an error.
-func loadConfig() (*Config, error) {
-	// Check if the correct number of command-line arguments is provided
-	if len(os.Args) != 2 {
-		return nil, fmt.Errorf("usage: %s <config_file.yml>", os.Args[0])
+// defaultConfigPath is the config file used when none is given.
+const defaultConfigPath = "psmgmt.yml"
+
+// resolveConfigPath returns the path of the config file, given by the -config
+// flag of the parsed flags or, as before that flag existed, as the only
+// command-line argument.
+func resolveConfigPath(flags *flag.FlagSet) (string, error) {
+	configSet := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "c" {
+			configSet = true
+		}
+	})
+	switch {
+	case flags.NArg() > 1:
+		return "", fmt.Errorf("expected a single config file, got %d arguments", flags.NArg())
+	case flags.NArg() == 1 && configSet:
+		return "", errors.New("give the config file either with -config or as an argument, not both")
+	case flags.NArg() == 1:
+		return flags.Arg(0), nil
 	}
+	return flags.Lookup("config").Value.String(), nil
+}
 
-	// Get the config file path from the command-line argument
-	configFilePath := os.Args[1]
+// usage prints how to run psmgmt and the available flags.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [config_file.yml]\n\nThe config file defaults to %s. Flags:\n", os.Args[0], defaultConfigPath)
+	flag.PrintDefaults()
+}
 
-	// Read the content of the config file
-	configFileContent, err := os.ReadFile(configFilePath)
+// loadConfig loads the configuration from the YAML file at configFilePath.
+// See parseConfig.
+func loadConfig(configFilePath string) (*Config, error) {
+	file, err := os.Open(configFilePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, fmt.Errorf("config file does not exist: %w", err)
 		}
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
+	defer file.Close()
+	return parseConfigFile(file, configFilePath)
+}
 
-	// Unmarshal the YAML content into a Config object
-	var config Config
-	err = yaml.Unmarshal(configFileContent, &config)
+// parseConfig parses the configuration from the YAML read from r, including
+// files relative to the working directory. See parseConfigFile.
+func parseConfig(r io.Reader) (*Config, error) {
+	return parseConfigFile(r, "")
+}
+
+// parseConfigFile parses the configuration from the YAML read from r, which
+// was read from the file at path. If it is valid and the version is supported,
+// it returns a Config object with the apps of the included files and the
+// defaults applied. Otherwise, it returns an error.
+func parseConfigFile(r io.Reader, path string) (*Config, error) {
+	// Read the content of the config file
+	configFileContent, err := io.ReadAll(r)
 	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	// Load the env_file and run the setenv commands first, so that the config
+	// can refer to their variables. Errors of the YAML are reported when
+	// decoding it below.
+	var header struct {
+		EnvFile string   `yaml:"env_file"`
+		SetEnv  []SetEnv `yaml:"setenv"`
+	}
+	_ = yaml.Unmarshal(configFileContent, &header)
+	var fileEnv map[string]string
+	if header.EnvFile != "" {
+		if fileEnv, err = readEnvFile(envFilePath(path, header.EnvFile)); err != nil {
+			return nil, fmt.Errorf("env_file: %w", err)
+		}
+	}
+	if err := validateSetEnv(header.SetEnv); err != nil {
+		return nil, err
+	}
+	// Only checking the config runs nothing, keeping the references to their
+	// variables as they are
+	vars := fileEnv
+	if *dryRun || *checkPaths {
+		vars = make(map[string]string, len(fileEnv)+len(header.SetEnv))
+		for name, value := range fileEnv {
+			vars[name] = value
+		}
+		for _, entry := range header.SetEnv {
+			vars[entry.Name] = "${" + entry.Name + "}"
+		}
+	} else if err := runSetEnv(header.SetEnv); err != nil {
+		return nil, err
+	}
+
+	// Substitute the environment variables the config refers to
+	configFileContent, err = expandEnv(configFileContent, vars, *strictEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unmarshal the YAML content into a Config object, rejecting unknown keys
+	// as they are likely misspelled
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(configFileContent))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
 		return nil, fmt.Errorf("error parsing YAML content: %w", err)
 	}
 
@@ -220,56 +1238,789 @@ func loadConfig() (*Config, error) {
 		return nil, errors.New("unsupported config version")
 	}
 
+	// Add the apps of the included files before checking them all together
+	if err := includeApps(&config, path, vars); err != nil {
+		return nil, err
+	}
+
+	// An empty config is more likely a mistake than intended
+	if len(config.Apps) == 0 && !*allowEmpty {
+		return nil, errors.New("no apps defined, pass --allow-empty to exit cleanly instead")
+	}
+
+	// Check that the restart limit is usable
+	if limit := config.RestartLimit; limit != nil && (limit.Max <= 0 || limit.Window <= 0) {
+		return nil, errors.New("restart_limit requires a positive max and window")
+	}
+
+	if config.StartupDeadline < 0 {
+		return nil, errors.New("startup_deadline must not be negative")
+	}
+
+	if config.ShutdownTimeout < 0 {
+		return nil, errors.New("shutdown_timeout must not be negative")
+	}
+
+	if config.MaxConcurrent < 0 {
+		return nil, errors.New("max_concurrent must not be negative")
+	}
+
+	if config.MaxLineBytes < 0 {
+		return nil, errors.New("max_line_bytes must not be negative")
+	}
+
+	if err := validateMode(&config); err != nil {
+		return nil, err
+	}
+
+	// Warn about path directories that don't exist, which may be created later
+	for _, dir := range config.Path {
+		if _, err := os.Stat(dir); err != nil {
+			log.Printf("warning: path directory %q does not exist", dir)
+		}
+	}
+
+	// Resolve the command lines for the platform psmgmt runs on
+	for i, command := range config.Apps {
+		if err := validateOverrides(command.Overrides); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		config.Apps[i] = applyOverrides(command, runtime.GOOS, runtime.GOARCH)
+	}
+
+	// Apps are told apart by name, also when the config is reloaded, which
+	// ignores the case of names
+	names := make(map[string]bool, len(config.Apps))
+	first := make(map[string]int, len(config.Apps))
+	for i, command := range config.Apps {
+		key := strings.ToLower(command.Name)
+		if j, ok := first[key]; ok {
+			return nil, fmt.Errorf("apps[%d] %q: duplicate name, already used by apps[%d] %q", i, command.Name, j, config.Apps[j].Name)
+		}
+		first[key] = i
+		names[command.Name] = true
+	}
+
+	// Check that every app can run on this platform
+	for i, command := range config.Apps {
+		if command.Builtin != "" && command.Builtin != builtinKeepalive {
+			return nil, fmt.Errorf("apps[%d] %q: unknown builtin %q", i, command.Name, command.Builtin)
+		}
+		if command.Builtin != "" && command.Command != "" {
+			return nil, fmt.Errorf("apps[%d] %q: builtin and command are mutually exclusive", i, command.Name)
+		}
+		if command.Shell && command.Command == "" {
+			return nil, fmt.Errorf("apps[%d] %q: shell requires command", i, command.Name)
+		}
+		// Containers may run the command of their image
+		if command.Builtin == "" && command.Image == "" && command.Command == "" {
+			return nil, fmt.Errorf("apps[%d] %q: command is required", i, command.Name)
+		}
+		if dir := localWorkingDir(command); dir != "" {
+			if err := checkWorkingDir(dir); err != nil {
+				return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+			}
+		}
+		if command.MinUptime < 0 {
+			return nil, fmt.Errorf("apps[%d] %q: min_uptime must not be negative", i, command.Name)
+		}
+		if command.Timeout < 0 {
+			return nil, fmt.Errorf("apps[%d] %q: timeout must not be negative", i, command.Name)
+		}
+		if command.StartDelay < 0 {
+			return nil, fmt.Errorf("apps[%d] %q: start_delay must not be negative", i, command.Name)
+		}
+		if command.MaxLineBytes < 0 {
+			return nil, fmt.Errorf("apps[%d] %q: max_line_bytes must not be negative", i, command.Name)
+		}
+		if command.HeadLines < 0 {
+			return nil, fmt.Errorf("apps[%d] %q: head_lines must not be negative", i, command.Name)
+		}
+		if err := validateRestart(command); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		if command.OutputFile != "" && len(command.PipeThrough) > 0 {
+			return nil, fmt.Errorf("apps[%d] %q: output_file and pipe_through are mutually exclusive", i, command.Name)
+		}
+		if err := validateNamespaces(command.Namespaces); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		if command.CPUs != "" {
+			if err := validateCPUs(command.CPUs); err != nil {
+				return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+			}
+		}
+		if command.ReloadSignal != "" {
+			if _, err := parseSignal(command.ReloadSignal); err != nil {
+				return nil, fmt.Errorf("apps[%d] %q: invalid reload_signal: %w", i, command.Name, err)
+			}
+		}
+		if command.StopSignal != "" {
+			if _, err := parseSignal(command.StopSignal); err != nil {
+				return nil, fmt.Errorf("apps[%d] %q: invalid stop_signal: %w", i, command.Name, err)
+			}
+		}
+		if _, err := newReadyGate(command); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: invalid ready_when: %w", i, command.Name, err)
+		}
+		if err := validateReadinessProbe(command); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		if err := validateLogRotation(command); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		for _, name := range command.RestartWith {
+			if !names[name] {
+				return nil, fmt.Errorf("apps[%d] %q: restart_with names unknown app %q", i, command.Name, name)
+			}
+		}
+		if command.RestartMemoryThreshold != "" {
+			if err := validateMemoryThreshold(command.RestartMemoryThreshold); err != nil {
+				return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+			}
+		}
+		if _, err := compileReplacements(command.Replace); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		if err := validateLockPolicy(command); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		if command.Stdin != "" && command.StdinTemplate != "" {
+			return nil, fmt.Errorf("apps[%d] %q: stdin and stdin_template are mutually exclusive", i, command.Name)
+		}
+		if _, err := parseStdinTemplate(command.StdinTemplate); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: invalid stdin_template: %w", i, command.Name, err)
+		}
+		if _, err := parsePrefix(command.Prefix); err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: invalid prefix: %w", i, command.Name, err)
+		}
+		for _, dir := range command.Path {
+			if _, err := os.Stat(dir); err != nil {
+				log.Printf("warning: apps[%d] %q: path directory %q does not exist", i, command.Name, dir)
+			}
+		}
+	}
+
+	if err := validateReferences(config.Apps); err != nil {
+		return nil, err
+	}
+	if err := validateDependsOn(config.Apps); err != nil {
+		return nil, err
+	}
+
+	// Apply the top-level settings to the apps that don't override them
+	for i := range config.Apps {
+		if file := config.Apps[i].EnvFile; file != "" {
+			env, err := readEnvFile(envFilePath(path, file))
+			if err != nil {
+				return nil, fmt.Errorf("apps[%d] %q: env_file: %w", i, config.Apps[i].Name, err)
+			}
+			applyEnvFile(&config.Apps[i], env)
+		}
+		applyEnvFile(&config.Apps[i], fileEnv)
+		if len(config.Apps[i].Path) == 0 {
+			config.Apps[i].Path = config.Path
+		}
+		if config.Apps[i].StopTimeout == 0 {
+			config.Apps[i].StopTimeout = config.ShutdownTimeout
+		}
+		if config.Apps[i].MaxLineBytes == 0 {
+			config.Apps[i].MaxLineBytes = config.MaxLineBytes
+		}
+	}
+
+	// Render the command lines once the environment of the apps is complete
+	for i, command := range config.Apps {
+		expanded, err := expandArgs(command, i)
+		if err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: %w", i, command.Name, err)
+		}
+		config.Apps[i] = expanded
+	}
+
+	// Resolve the transforms like the commands, which --check-paths reports on its own
+	for i, command := range config.Apps {
+		if len(command.PipeThrough) == 0 || *checkPaths {
+			continue
+		}
+		name, err := resolvePipeThrough(command)
+		if err != nil {
+			return nil, fmt.Errorf("apps[%d] %q: invalid pipe_through: %w", i, command.Name, err)
+		}
+		config.Apps[i].PipeThrough = append([]string{name}, command.PipeThrough[1:]...)
+	}
+
 	return &config, nil
 }
 
+// Command-line flags
+var (
+	// configPath is the config file, unless it is given as an argument.
+	configPath = flag.String("config", defaultConfigPath, "load the config from `file`, which may also be given as the only argument")
+	// format selects how the messages are printed.
+	format = flag.String("format", formatText, "print messages as \"text\" lines or as \"json\" objects, one per line")
+	// forceColor colors the output even if it doesn't go to a terminal.
+	forceColor = flag.Bool("color", false, "color the output even if it is not a terminal")
+	// noColor never colors the output.
+	noColor = flag.Bool("no-color", false, "never color the output, even on a terminal")
+	// statusLines prints lifecycle messages as systemd-style status lines.
+	statusLines = flag.Bool("status-lines", false, "print start and exit of commands as systemd-style status lines")
+	// pidsFile is a file kept up to date with the PID of every running command.
+	pidsFile = flag.String("pids-file", "", "keep the `file` up to date with a \"name pid\" line per running command")
+	// printPids prints the PID of every started process to stdout.
+	printPids = flag.Bool("print-pids", false, "print \"name pid\" to stdout whenever a process starts")
+	// elapsed prefixes every line with the time elapsed since its command started.
+	elapsed = flag.Bool("elapsed", false, "prefix lines with the time elapsed since their command started, like +1.234s")
+	// once runs every command to completion without supervision and exits with an aggregate code.
+	once = flag.Bool("once", false, "run every command to completion, then exit non-zero if any of them failed")
+	// auditLogPath is a file every message is appended to as JSON.
+	auditLogPath = flag.String("audit-log", "", "append every message to `file` as newline-delimited JSON")
+	// auditKeyFile holds the key the audit log is encrypted with.
+	auditKeyFile = flag.String("audit-key-file", "", "encrypt the audit log with the base64 encoded key in `file` instead of $"+auditKeyEnv)
+	// checkPaths only checks that the files and executables in the config exist.
+	checkPaths = flag.Bool("check-paths", false, "check that every file, directory and executable in the config exists, then exit")
+	// dryRun only validates the config and prints what running it would do.
+	dryRun = flag.Bool("dry-run", false, "validate the config and print every app with its command line, working directory, env and dependencies, then exit without running anything")
+	// dedupWindow collapses identical lines of different commands seen within it.
+	dedupWindow = flag.Duration("dedup", 0, "collapse identical lines of different commands seen within `window`, like 1s, into one")
+	// readyFile is created once every command is ready.
+	readyFile = flag.String("ready-file", "", "create `file` once every command is ready, and remove it on exit")
+	// otlpEndpoint is an OpenTelemetry collector messages are exported to as log records.
+	otlpEndpoint = flag.String("otlp-endpoint", "", "export every message as an OpenTelemetry log record to the collector at `url`, like http://localhost:4318")
+	// echoCommands prints the command line of every process before it starts.
+	echoCommands = flag.Bool("echo-commands", false, "print the command line of every process, with secrets redacted, before it starts")
+	// allowEmpty makes a config without apps exit cleanly instead of failing.
+	allowEmpty = flag.Bool("allow-empty", false, "exit cleanly instead of failing when the config defines no apps")
+	// strictEnv fails configs referring to undefined environment variables.
+	strictEnv = flag.Bool("strict-env", false, "fail when the config refers to an undefined environment variable instead of substituting an empty string")
+	// auditRaw writes output lines to the audit log before replace rules rewrote them.
+	auditRaw = flag.Bool("audit-raw", false, "write output lines to the audit log as printed by the commands, before replace rules")
+	// pprofAddr serves the net/http/pprof endpoints of psmgmt itself.
+	pprofAddr = flag.String("pprof", "", "serve the net/http/pprof endpoints of psmgmt on `address`, like :6060")
+	// cpuProfile is a file a CPU profile of psmgmt is written to.
+	cpuProfile = flag.String("cpuprofile", "", "write a CPU profile of psmgmt to `file` on exit")
+	// memProfile is a file a memory profile of psmgmt is written to on exit.
+	memProfile = flag.String("memprofile", "", "write a memory profile of psmgmt to `file` on exit")
+	// bulkURL is a Loki or Elasticsearch server messages are sent to in batches.
+	bulkURL = flag.String("bulk-url", "", "send every message in batches to the Loki or Elasticsearch server at `url`")
+	// bulkFormat selects the API of the server at bulkURL.
+	bulkFormat = flag.String("bulk-format", bulkFormatLoki, "the API of the --bulk-url server, \"loki\" or \"elasticsearch\"")
+	// bulkIndex is the Elasticsearch index messages are added to.
+	bulkIndex = flag.String("bulk-index", "psmgmt", "the Elasticsearch `index` messages are added to")
+	// sinkBatchSize is the number of messages after which sinks write them out.
+	sinkBatchSize = flag.Int("sink-batch-size", defaultBatchSize, "write messages to the audit log, OTLP collector and --bulk-url every `n` messages")
+	// sinkFlushInterval is the longest messages wait in sinks before being written out.
+	sinkFlushInterval = flag.Duration("sink-flush-interval", defaultFlushInterval, "write messages to the audit log, OTLP collector and --bulk-url at least every `interval`")
+	// noSignalHandling leaves SIGINT and SIGTERM to whatever runs psmgmt.
+	noSignalHandling = flag.Bool("no-signal-handling", false, "don't stop the apps gracefully on SIGINT and SIGTERM nor reload the config on SIGHUP, leaving the signals to their default action")
+	// listenAddr is the address the control API is served on.
+	listenAddr = flag.String("listen", "", "serve an HTTP API listing, stopping and starting the apps on `address`, like :8080")
+	// tagFilter, onlyFilter and exceptFilter select the apps that run.
+	tagFilter    = stringListFlag("tag", "only run the apps with `tag`, may be repeated or comma-separated")
+	onlyFilter   = stringListFlag("only", "only run the app `name`, may be repeated or comma-separated")
+	exceptFilter = stringListFlag("except", "don't run the app `name`, may be repeated or comma-separated")
+	// alignPrefix prints lines prefixed with the padded name of their app.
+	alignPrefix = flag.Bool("prefix", false, "prefix lines with the name of their app, padded to the longest name, and \"|\" instead of the prefix templates")
+	// webAddr is the address the web log viewer is served on.
+	webAddr = flag.String("web", "", "serve a page streaming the logs live on `address`, like :8080")
+	// metricsAddr is the address Prometheus metrics of the apps are served on.
+	metricsAddr = flag.String("metrics", "", "serve Prometheus metrics of the apps on `address`, like :9090, under /metrics")
+)
+
+// handleShutdownSignals cancels the run on SIGINT or SIGTERM, telling systemd
+// that psmgmt is stopping.
+func handleShutdownSignals(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		if err := sdNotify("STOPPING=1"); err != nil {
+			logSystem("SystemError", "error notifying systemd: %v", err)
+		}
+		cancel()
+	}()
+}
+
+// handleReloadSignal calls reload on every SIGHUP until ctx is done.
+func handleReloadSignal(ctx context.Context, reload func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigs:
+				reload()
+			}
+		}
+	}()
+}
+
+// prepareOutput stores the prefixer and the replacer of the apps' output. If
+// align is set, the prefixes line up, see prefixer.align.
+func prepareOutput(apps []Command, color bool, align bool, prefixes *atomic.Pointer[prefixer], replacements *atomic.Pointer[replacer]) error {
+	p, err := newPrefixer(apps, color)
+	if err != nil {
+		return err
+	}
+	if align {
+		p.align(apps)
+	}
+	r, err := newReplacer(apps)
+	if err != nil {
+		return err
+	}
+	prefixes.Store(p)
+	replacements.Store(r)
+	return nil
+}
+
 func main() {
+	flag.StringVar(configPath, "c", defaultConfigPath, "shorthand for -config")
+	flag.Usage = usage
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs psmgmt with the command-line arguments args and returns its exit
+// status, once everything it set up is cleaned up.
+func run(args []string) int {
+	// psmgmt's own lines are stamped like the printed messages, by the runner's clock
+	runner := NewRunner()
+	log.SetFlags(0)
+	log.SetOutput(timestampWriter{clock: runner.Clock, w: os.Stderr})
+
+	// Run the ssh subcommand of apps with a host on behalf of psmgmt
+	if len(args) > 0 && args[0] == "ssh" {
+		return runSSH(args[1:])
+	}
+
+	// Run the decrypt subcommand instead of the commands when asked to
+	if len(args) > 0 && args[0] == "decrypt" {
+		if err := runDecrypt(args[1:]); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	flag.CommandLine.Parse(args)
+	if err := validateFormat(*format); err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	// Profile psmgmt itself when asked to
+	stopProfiling, err := startProfiling(*pprofAddr, *cpuProfile, *memProfile)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer stopProfiling()
+
 	// Load the configuration
-	config, err := loadConfig()
+	path, err := resolveConfigPath(flag.CommandLine)
+	if err != nil {
+		log.Print(err)
+		flag.Usage()
+		return 2
+	}
+	filter := appFilter{tags: *tagFilter, only: *onlyFilter, except: *exceptFilter}
+	config, err := loadConfig(path)
+	if err == nil {
+		config.Apps, err = filter.apply(config.Apps)
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		return 1
+	}
+
+	// Apps started again would run out of turn
+	if *listenAddr != "" && config.Mode == modeSequential {
+		log.Print("--listen cannot be used with mode sequential")
+		return 1
+	}
+
+	// With --allow-empty there may be nothing to run
+	if len(config.Apps) == 0 {
+		log.Print("no apps defined, nothing to run")
+		return 0
+	}
+
+	// Report every missing path at once instead of failing in the middle of a run
+	if *checkPaths {
+		problems := checkConfigPaths(config)
+		for _, problem := range problems {
+			log.Print(problem)
+		}
+		if len(problems) > 0 {
+			return 1
+		}
+		return 0
+	}
+
+	// Show what would run, without running the setenv commands either
+	if *dryRun {
+		if err := writePlan(os.Stdout, config); err != nil {
+			log.Print(err)
+			return 1
+		}
+		return 0
+	}
+
+	runner.Apps = config.Apps
+	runner.RestartLimit = config.RestartLimit
+	runner.NoRestart = *once
+
+	// Open the sinks every message is written to
+	if *sinkBatchSize < 1 || *sinkFlushInterval <= 0 {
+		log.Print("--sink-batch-size and --sink-flush-interval must be positive")
+		return 1
+	}
+	batching := Batching{Size: *sinkBatchSize, Interval: *sinkFlushInterval}
+	var sinks []Sink
+	// reloadables are told the apps of a reloaded config
+	var reloadables []func(apps []Command)
+	if *auditLogPath != "" {
+		key, err := loadAuditKey(*auditKeyFile)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		audit, err := newAuditLog(*auditLogPath, key, runner.Clock, batching)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		audit.raw = *auditRaw
+		sinks = append(sinks, audit)
+	}
+	sinks = append(sinks, newLogFiles())
+	if *otlpEndpoint != "" {
+		sinks = append(sinks, newOTLPSink(*otlpEndpoint, runner.Clock, batching))
+	}
+	if *bulkURL != "" {
+		bulk, err := newBulkSink(*bulkURL, *bulkFormat, *bulkIndex, runner.Clock, batching)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		sinks = append(sinks, bulk)
+	}
+	if *webAddr != "" {
+		viewer, err := newWebViewer(*webAddr, runner.Clock, runner.Snapshot)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		sinks = append(sinks, viewer)
+	}
+	if *metricsAddr != "" {
+		metrics, err := newMetricsServer(*metricsAddr, config.Apps, runner.Throughput)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		sinks = append(sinks, metrics)
+		reloadables = append(reloadables, metrics.setApps)
+	}
+	defer func() {
+		for _, sink := range sinks {
+			if err := sink.Close(); err != nil {
+				logSystem("SystemError", "error closing sink: %v", err)
+			}
+		}
+	}()
+
+	// Color the output when it goes to a terminal, unless told otherwise
+	color := (isTerminal(os.Stderr) || *forceColor) && !*noColor
+	// Reloading the config swaps them for the ones of the reloaded apps
+	var replacements atomic.Pointer[replacer]
+	if err := prepareOutput(config.Apps, color, *alignPrefix, &prefixes, &replacements); err != nil {
+		log.Print(err)
+		return 1
 	}
 
 	// Create a context and a cancel function for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner.Shutdown = cancel
 
-	// Set up signal handling for interrupts and termination signals
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// The runner installs no signal handlers of its own, it stops once the
+	// context is cancelled
+	if !*noSignalHandling {
+		handleShutdownSignals(cancel)
+	}
 
-	// Start a goroutine to handle signals and cancel the context on signal reception
-	go func() {
-		<-sigs
-		cancel()
-	}()
+	// Keep the systemd watchdog, if any, from restarting psmgmt
+	go runWatchdog(ctx, runner.Clock, systemLogger("SystemError"))
 
 	// Create a wait group to wait for all commands to complete
 	wg := new(sync.WaitGroup)
 
 	// Create a channel to receive output messages from commands
 	outputChan := make(chan Message, 2)
-	defer close(outputChan)
+
+	// Give every command a channel of its own, so that a chatty one can't
+	// starve the others
+	mux := newFairMux(outputChan)
 
 	// Execute each command concurrently
 	commands := config.Apps
 	amountOfCommands := len(commands)
-	for _, command := range commands {
-		Execute(ctx, wg, outputChan, command)
+	discovered := newDiscoveries(commands)
+	deps := newDependencies(commands)
+	limit := newConcurrencyLimit(config.MaxConcurrent)
+	skip := func(command Command, reason string) {
+		input := mux.input(command.Name)
+		send(runner.Clock, input, Message{Type: OutputStart, Command: &command})
+		send(runner.Clock, input, Message{Content: reason, Type: SystemError, Command: &command, Failed: true})
+		send(runner.Clock, input, Message{Type: OutputEnd, Command: &command})
+	}
+	apps := newLifecycles(amountOfCommands)
+	launch := func(command Command, again bool) error {
+		// Every app runs with a context of its own, so that --listen can stop it
+		ctx, err := apps.start(ctx, command.Name, again)
+		if err != nil {
+			return err
+		}
+		input := mux.input(command.Name)
+		if len(dependsOn(command)) == 0 && limit == nil {
+			runner.Execute(ctx, wg, input, command)
+			return nil
+		}
+
+		// Wait for the apps the command depends on, for the output its args
+		// refer to and for a slot to run in before starting it
+		go func() {
+			err := deps.wait(ctx, command)
+			resolved := command
+			if err == nil {
+				resolved, err = discovered.resolve(ctx, command)
+			}
+			if err == nil {
+				err = limit.acquire(ctx, command)
+			}
+			if err != nil {
+				skip(command, err.Error())
+				return
+			}
+			runner.Execute(ctx, wg, input, resolved)
+		}()
+		return nil
+	}
+	start := func(command Command) {
+		_ = launch(command, false)
+	}
+
+	reloads := newReloader(commands, apps, launch, func(command Command) error {
+		if !deps.tracks(command) || !discovered.tracks(command) {
+			return errors.New("depends_on and $(app.stdout) only work for apps depended on at startup")
+		}
+		return nil
+	}, func(command Command) error {
+		return signalApp(runner.Snapshot(), command)
+	})
+	reloads.notify(runner.SetApps)
+	for _, update := range reloadables {
+		reloads.notify(update)
+	}
+
+	// Serve the API starting stopped apps again, stopping single apps and
+	// filtering their output
+	filters := newOutputFilters()
+	if *listenAddr != "" {
+		control := newControlAPI(commands, func(name string) error {
+			if command, ok := reloads.lookup(name); ok {
+				return launch(command, true)
+			}
+			return fmt.Errorf("%s was removed from the config", name)
+		}, apps.stop, filters.set)
+		if err := control.listen(*listenAddr); err != nil {
+			log.Print(err)
+			return 1
+		}
+		sinks = append(sinks, control)
+		reloads.notify(control.setApps)
+	}
+
+	// Apply the changes to the config on SIGHUP
+	if !*noSignalHandling {
+		handleReloadSignal(ctx, func() {
+			if config.Mode == modeSequential {
+				logSystem("SystemError", "reload: not supported with mode sequential, keeping the current config")
+				return
+			}
+			reloaded, err := reloadConfig(runner.Clock, func() (*Config, error) { return loadConfig(path) }, reloadAttempts, reloadRetryDelay, func(format string, args ...any) {
+				logSystem("SystemError", "reload: "+format, args...)
+			})
+			if err == nil {
+				reloaded.Apps, err = filter.apply(reloaded.Apps)
+			}
+			if err == nil {
+				err = prepareOutput(reloaded.Apps, color, *alignPrefix, &prefixes, &replacements)
+			}
+			if err != nil {
+				logSystem("SystemError", "reload: keeping the current config: %v", err)
+				return
+			}
+			actions := reloads.reload(reloaded.Apps)
+			if len(actions) == 0 {
+				actions = []string{"no apps changed"}
+			}
+			for _, action := range actions {
+				logSystem("SystemError", "reload: %s", action)
+			}
+		})
+	}
+
+	var seq *sequence
+	if config.Mode == modeSequential {
+		seq = newSequence(commands)
+		go seq.run(ctx, start, skip)
+	} else {
+		for _, command := range commands {
+			start(command)
+		}
 	}
 
 	// Stream logs from the output channel and process them with a handler function
-	streamLogs(
-		outputChan, amountOfCommands,
+	status := newStatusPrinter(color)
+	head := newHeadLimiter()
+	starts := newElapsedTracker()
+	exits := newExitTracker()
+	pids := newPidFile(*pidsFile)
+	defer pids.remove()
+	stops := new(stopReport)
+	ready := newAllReady(config.Apps)
+	if *readyFile != "" {
+		defer os.Remove(*readyFile)
+	}
+
+	// Give up on the launch if the apps don't all become ready in time
+	var startupFailed atomic.Bool
+	if config.StartupDeadline > 0 {
+		go func() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-runner.Clock.After(config.StartupDeadline):
+			}
+			if names := ready.notReady(); len(names) > 0 {
+				logSystem("SystemError", "not ready within startup_deadline of %s: %s", config.StartupDeadline, strings.Join(names, ", "))
+				startupFailed.Store(true)
+				cancel()
+			}
+		}()
+	}
+	printMessage := func(message Message, offset string) {
+		if *format == formatJSON {
+			if err := writeJSONLine(os.Stderr, message, runner.Clock.Now(), offset); err != nil {
+				logSystem("SystemError", "error printing message: %v", err)
+			}
+			return
+		}
+		// The restart notice or the OutputEnd that follows says how the process exited
+		if message.Type == OutputExited {
+			return
+		}
+		content := message.Content
+		if message.Type == OutputEnd && content == "" {
+			content = fmt.Sprintf("exit code %d", message.ExitCode)
+		}
+		line := prefixes.Load().format(message) + " " + content
+		if offset != "" {
+			line = offset + " " + line
+		}
+		// The time the message was produced replaces the time it is printed at.
+		// Raw output ends its lines itself
+		line = message.Timestamp.Format(timestampLayout) + " " + line
+		if !message.isRaw() {
+			line += "\n"
+		}
+		fmt.Fprint(os.Stderr, line)
+	}
+	dedup := newDeduplicator(*dedupWindow, runner.Clock, printMessage)
+	streamLifecycles(
+		outputChan, apps,
 		func(message Message) {
-			log.Printf(
-				"[%s::%s]: %s",
-				message.CommandName(),
-				message.Type.Name(),
-				message.Content,
-			)
+			message = replacements.Load().apply(message)
+			exits.observe(message)
+			stops.observe(message)
+			discovered.observe(message)
+			deps.observe(message)
+			limit.observe(message)
+			seq.observe(message)
+			reloads.observe(message)
+			for _, sink := range sinks {
+				if err := sink.Write(message); err != nil {
+					logSystem("SystemError", "error writing to sink: %v", err)
+				}
+			}
+			if err := pids.update(message); err != nil {
+				logSystem("SystemError", "error writing pids file: %v", err)
+			}
+			if ready.observe(message) {
+				if *readyFile != "" {
+					if err := os.WriteFile(*readyFile, nil, 0o644); err != nil {
+						logSystem("SystemError", "error writing ready file: %v", err)
+					}
+				}
+				if err := sdNotify("READY=1"); err != nil {
+					logSystem("SystemError", "error notifying systemd: %v", err)
+				}
+			}
+			if *printPids && message.Type == OutputRunning {
+				fmt.Printf("%s %d\n", message.CommandName(), message.Pid)
+			}
+			if message.Type == OutputCommand && !*echoCommands {
+				return
+			}
+			message, ok := head.apply(message)
+			if !ok || !filters.allow(message) {
+				return
+			}
+			var offset string
+			if *elapsed {
+				offset = starts.annotate(message, runner.Clock.Now())
+			}
+			if *statusLines && *format == formatText {
+				if line, ok := status.format(message); ok {
+					if line != "" {
+						log.Print(line)
+					}
+					return
+				}
+			}
+			if dedup.hold(message, offset) {
+				return
+			}
+			printMessage(message, offset)
 		},
 	)
+	dedup.flush()
 
-	// Wait for all commands to complete
+	// Wait for all commands to complete. Only then nothing sends messages any
+	// more, also not the ones killed on shutdown, and the channel can be closed
 	wg.Wait()
+	mux.stop()
+	close(outputChan)
+	stops.print(systemLogger("OutputStopped"))
+	for _, stats := range runner.Throughput() {
+		logSystem("Throughput", "output of %s", stats)
+	}
+	for _, status := range runner.Snapshot() {
+		if status.FailedStarts > 0 {
+			logSystem("SystemError", "%s failed to start %d times", status.Name, status.FailedStarts)
+		}
+	}
+
+	// The exit code tells whether every command succeeded, and a launch that
+	// missed its startup deadline or was aborted failed either way
+	code := exits.code()
+	if code == 0 && (startupFailed.Load() || runner.Aborted()) {
+		code = 1
+	}
+	return code
 }
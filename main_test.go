@@ -2,6 +2,11 @@ package main
 
 import (
 	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -39,27 +44,879 @@ func TestExecute(t *testing.T) {
 	messageCount := make(map[MessageType]int)
 	mgs := make([]string, 0)
 
-	wg.Wait()
-
 	streamLogs(
 		outputChan, lenCommands,
 		func(message Message) {
 			messageCount[message.Type] += 1
-			if message.Content != "" {
+			if message.Content != "" && message.Type != OutputCommand {
 				mgs = append(mgs, message.Content)
 			}
 		},
 	)
 
+	// Every goroutine has sent its last message, so they all end
+	wg.Wait()
+
 	expectedMessageCount := map[MessageType]int{
-		OutputStart:  2,
-		OutputStdout: 4,
-		OutputEnd:    2,
-		SystemError:  2,
+		OutputStart:   2,
+		OutputCommand: 2,
+		OutputRunning: 2,
+		OutputStdout:  4,
+		OutputExited:  2,
+		OutputEnd:     2,
+		OutputStopped: 2,
 	}
-	expectedMessages := []string{"hello", "world", "hello", "world", "error waiting for command: signal: killed", "error waiting for command: signal: killed"}
+	expectedMessages := []string{"hello", "world", "hello", "world", "stopped gracefully", "stopped gracefully"}
 	assert.Equal(t, expectedMessageCount, messageCount)
 	assert.Equal(t, expectedMessages, mgs)
 
 	close(outputChan)
 }
+
+func TestExecuteKeepalive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	outputChan := make(chan Message, 2)
+	start := time.Now()
+	Execute(ctx, new(sync.WaitGroup), outputChan, Command{Name: "keepalive", Builtin: builtinKeepalive})
+
+	messageTypes := make([]MessageType, 0)
+	streamLogs(outputChan, 1, func(message Message) {
+		messageTypes = append(messageTypes, message.Type)
+	})
+
+	assert.Equal(t, []MessageType{OutputStart, OutputEnd}, messageTypes)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestExecuteReadyWhen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	command := Command{
+		Name:      "server",
+		Command:   "sh",
+		Args:      []string{"-c", "echo booting; echo 'Listening on :8080'; sleep 0.2"},
+		ReadyWhen: `Listening on :\d+`,
+	}
+
+	outputChan := make(chan Message, 10)
+	Execute(ctx, new(sync.WaitGroup), outputChan, command)
+
+	messages := make([]string, 0)
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type != OutputCommand {
+			messages = append(messages, message.Type.Name()+":"+message.Content)
+		}
+	})
+
+	assert.Equal(t, []string{
+		"OutputStart:",
+		"OutputRunning:",
+		"OutputStdout:booting",
+		"OutputStdout:Listening on :8080",
+		"OutputReady:",
+		"OutputExited:",
+		"OutputEnd:",
+	}, messages)
+}
+
+func TestExecuteReadyWhenNeverMatched(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	command := Command{
+		Name:      "server",
+		Command:   "sh",
+		Args:      []string{"-c", "echo crashed; sleep 0.2"},
+		ReadyWhen: `Listening`,
+	}
+
+	outputChan := make(chan Message, 10)
+	Execute(ctx, new(sync.WaitGroup), outputChan, command)
+
+	messageCount := make(map[MessageType]int)
+	errs := make([]string, 0)
+	streamLogs(outputChan, 1, func(message Message) {
+		messageCount[message.Type]++
+		if message.Type == SystemError {
+			errs = append(errs, message.Content)
+		}
+	})
+
+	assert.Zero(t, messageCount[OutputReady])
+	assert.Equal(t, []string{"command exited before matching ready_when"}, errs)
+}
+
+func TestExecuteOutputFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	outputFile := filepath.Join(t.TempDir(), "out.bin")
+	command := Command{
+		Name:       "backup",
+		Command:    "sh",
+		Args:       []string{"-c", "printf 'a\\000b\\r'; echo oops >&2; sleep 0.2"},
+		OutputFile: outputFile,
+	}
+
+	outputChan := make(chan Message, 10)
+	Execute(ctx, new(sync.WaitGroup), outputChan, command)
+
+	messages := make([]string, 0)
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Content != "" && message.Type != OutputCommand {
+			messages = append(messages, message.Type.Name()+":"+message.Content)
+		}
+	})
+
+	content, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a\x00b\r"), content)
+	assert.Equal(t, []string{"OutputStderr:oops"}, messages)
+}
+
+func TestExecuteFastExit(t *testing.T) {
+	// Commands exiting immediately must still deliver all their output before OutputEnd
+	for i := 0; i < 20; i++ {
+		outputChan := make(chan Message, 2)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:    "fast",
+			Command: "sh",
+			Args:    []string{"-c", "echo out; echo err >&2"},
+		})
+
+		var messageTypes []MessageType
+		seqs := make(map[MessageType]uint64)
+		streamLogs(outputChan, 1, func(message Message) {
+			messageTypes = append(messageTypes, message.Type)
+			seqs[message.Type] = message.Seq
+		})
+
+		assert.Len(t, messageTypes, 7)
+		assert.Equal(t, []MessageType{OutputStart, OutputCommand, OutputRunning}, messageTypes[:3])
+		assert.ElementsMatch(t, []MessageType{OutputStdout, OutputStderr}, messageTypes[3:5])
+		assert.Equal(t, []MessageType{OutputExited, OutputEnd}, messageTypes[5:])
+
+		// Lines are numbered in the order they were produced, between start and end
+		assert.Less(t, seqs[OutputStart], seqs[OutputRunning])
+		assert.NotEqual(t, seqs[OutputStdout], seqs[OutputStderr])
+		assert.Less(t, seqs[OutputStdout], seqs[OutputEnd])
+		assert.Less(t, seqs[OutputStderr], seqs[OutputEnd])
+	}
+}
+
+func TestExecuteOutputBeforeEnd(t *testing.T) {
+	// The lines printed while a command stops on shutdown, as many as there may
+	// be, also arrive before its OutputEnd
+	ctx, cancel := context.WithCancel(context.Background())
+	outputChan := make(chan Message, 2)
+	Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+		Name:    "graceful",
+		Command: "sh",
+		Args:    []string{"-c", `trap 'seq 1000; echo done >&2; exit 0' TERM; echo up; while :; do :; done`},
+	})
+
+	var stdout int
+	var stderr []string
+	ended := false
+	streamLogs(outputChan, 1, func(message Message) {
+		assert.False(t, ended, "%s after OutputEnd", message.Type.Name())
+		switch message.Type {
+		case OutputStdout:
+			if stdout++; message.Content == "up" {
+				cancel()
+			}
+		case OutputStderr:
+			stderr = append(stderr, message.Content)
+		case OutputEnd:
+			ended = true
+		}
+	})
+	assert.Equal(t, 1001, stdout)
+	assert.Equal(t, []string{"done"}, stderr)
+}
+
+func TestExecuteStartDelay(t *testing.T) {
+	begin := time.Now()
+	outputChan := make(chan Message, 10)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:       "delayed",
+		Command:    "echo",
+		Args:       []string{"hello"},
+		StartDelay: 300 * time.Millisecond,
+	})
+
+	arrived := make(map[MessageType]time.Duration)
+	streamLogs(outputChan, 1, func(message Message) {
+		arrived[message.Type] = time.Since(begin)
+	})
+	assert.Less(t, arrived[OutputStart], 300*time.Millisecond)
+	assert.GreaterOrEqual(t, arrived[OutputStdout], 300*time.Millisecond)
+
+	// Shutting down during the delay doesn't start the process
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+		Name:       "delayed",
+		Command:    "echo",
+		Args:       []string{"hello"},
+		StartDelay: time.Minute,
+	})
+	var types []MessageType
+	streamLogs(outputChan, 1, func(message Message) {
+		types = append(types, message.Type)
+	})
+	assert.Equal(t, []MessageType{OutputStart, OutputEnd}, types)
+}
+
+func TestExecuteChildHoldsOutput(t *testing.T) {
+	// A child keeping the output open after the process exited doesn't hold
+	// up its OutputEnd for longer than its stop_timeout
+	begin := time.Now()
+	outputChan := make(chan Message, 10)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:        "forks",
+		Command:     "sh",
+		Args:        []string{"-c", "sleep 3 & echo started; exit 0"},
+		StopTimeout: 200 * time.Millisecond,
+	})
+
+	var stdout []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputStdout {
+			stdout = append(stdout, message.Content)
+		}
+	})
+	assert.Equal(t, []string{"started"}, stdout)
+	assert.Less(t, time.Since(begin), 2*time.Second)
+}
+
+func TestExecuteStderrIsError(t *testing.T) {
+	for _, escalate := range []bool{false, true} {
+		outputChan := make(chan Message, 2)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:          "stderr",
+			Command:       "sh",
+			Args:          []string{"-c", "echo out; echo err >&2"},
+			StderrIsError: escalate,
+		})
+
+		errors := make(map[MessageType]bool)
+		streamLogs(outputChan, 1, func(message Message) {
+			if message.Type == OutputStdout || message.Type == OutputStderr {
+				errors[message.Type] = message.IsError
+			}
+		})
+
+		assert.Equal(t, map[MessageType]bool{OutputStdout: false, OutputStderr: escalate}, errors)
+	}
+}
+
+func TestExecuteEnv(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "env",
+		Command: "sh",
+		Args:    []string{"-c", "echo $FOO"},
+		Env:     map[string]string{"FOO": "bar"},
+	})
+
+	var stdout []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputStdout {
+			stdout = append(stdout, message.Content)
+		}
+	})
+
+	assert.Equal(t, []string{"bar"}, stdout)
+}
+
+func TestExecuteWorkingDir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		workingDir string
+		stdout     []string
+		errors     []string
+	}{
+		{dir, []string{dir}, nil},
+		{filepath.Join(dir, "missing"), nil, []string{"working_dir does not exist: " + filepath.Join(dir, "missing")}},
+	} {
+		outputChan := make(chan Message, 2)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:       "pwd",
+			Command:    "pwd",
+			WorkingDir: test.workingDir,
+		})
+
+		var stdout, errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputStdout:
+				stdout = append(stdout, message.Content)
+			case SystemError:
+				errors = append(errors, message.Content)
+			}
+		})
+
+		assert.Equal(t, test.stdout, stdout)
+		assert.Equal(t, test.errors, errors)
+	}
+}
+
+func TestExecuteRunOnce(t *testing.T) {
+	runner := NewRunner()
+	migrate := Command{Name: "migrate", Command: "echo", Args: []string{"migrated"}, RunOnce: true}
+
+	var stdout []string
+	var ends []string
+	for i := 0; i < 2; i++ {
+		outputChan := make(chan Message, 2)
+		runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, migrate)
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputStdout:
+				stdout = append(stdout, message.Content)
+			case OutputEnd:
+				ends = append(ends, message.Content)
+			}
+		})
+	}
+
+	assert.Equal(t, []string{"migrated"}, stdout)
+	assert.Equal(t, []string{"", "already ran once, not running again"}, ends)
+}
+
+func TestExecuteExitCode(t *testing.T) {
+	for _, test := range []struct {
+		script   string
+		exitCode int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -KILL $$", -1},
+	} {
+		outputChan := make(chan Message, 2)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:    "exit",
+			Command: "sh",
+			Args:    []string{"-c", test.script},
+		})
+
+		var end Message
+		streamLogs(outputChan, 1, func(message Message) {
+			if message.Type == OutputEnd {
+				end = message
+			}
+		})
+
+		assert.Equal(t, test.exitCode, end.ExitCode, test.script)
+	}
+}
+
+func TestExecuteRestart(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:       "flaky",
+		Command:    "sh",
+		Args:       []string{"-c", "echo run; exit 1"},
+		Restart:    restartOnFailure,
+		MaxRetries: 2,
+	})
+
+	var stdout, errors []string
+	var end Message
+	streamLogs(outputChan, 1, func(message Message) {
+		switch message.Type {
+		case OutputStdout:
+			stdout = append(stdout, message.Content)
+		case SystemError:
+			errors = append(errors, message.Content)
+		case OutputEnd:
+			end = message
+		}
+	})
+
+	assert.Equal(t, []string{"run", "run", "run"}, stdout)
+	assert.Equal(t, []string{
+		"error waiting for command: exit status 1",
+		"exited with code 1, restarting in 100ms (restart 1 of 2)",
+		"error waiting for command: exit status 1",
+		"exited with code 1, restarting in 100ms (restart 2 of 2)",
+		"error waiting for command: exit status 1",
+		"exited with code 1, not restarting after max_retries of 2",
+	}, errors)
+	assert.Equal(t, 1, end.ExitCode)
+}
+
+func TestExecuteRestartBackoff(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:           "crashloop",
+		Command:        "false",
+		Restart:        restartOnFailure,
+		MaxRetries:     3,
+		RestartBackoff: 20 * time.Millisecond,
+	})
+
+	var restarts []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == SystemError && strings.Contains(message.Content, "restarting in") {
+			restarts = append(restarts, message.Content)
+		}
+	})
+
+	assert.Equal(t, []string{
+		"exited with code 1, restarting in 20ms (restart 1 of 3)",
+		"exited with code 1, restarting in 40ms (restart 2 of 3)",
+		"exited with code 1, restarting in 80ms (restart 3 of 3)",
+	}, restarts)
+}
+
+func TestExecuteMinUptime(t *testing.T) {
+	runner := NewRunner()
+	outputChan := make(chan Message, 2)
+	runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:                 "daemon",
+		Command:              "true",
+		Restart:              restartAlways,
+		MinUptime:            time.Minute,
+		UnhealthyBackoff:     time.Millisecond,
+		MaxUnhealthyRestarts: 1,
+	})
+
+	var errors []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == SystemError {
+			errors = append(errors, message.Content)
+		}
+	})
+
+	assert.Len(t, errors, 4)
+	assert.Contains(t, errors[0], "before min_uptime of 1m0s, failed to start")
+	assert.Equal(t, "exited with code 0, restarting in 101ms (restart 1)", errors[1])
+	assert.Contains(t, errors[2], "before min_uptime of 1m0s, failed to start")
+	assert.Equal(t, "exited 2 times in a row before becoming ready, not restarting", errors[3])
+	assert.Equal(t, 2, runner.Snapshot()[0].FailedStarts)
+}
+
+func TestExecuteRestartWith(t *testing.T) {
+	db := Command{Name: "db", Command: "sh", Args: []string{"-c", "sleep 0.2; exit 1"}, Restart: restartOnFailure, MaxRetries: 1, RestartWith: []string{"web"}}
+	web := Command{Name: "web", Command: "sleep", Args: []string{"5"}}
+	runner := NewRunner()
+	runner.Apps = []Command{db, web}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outputChan := make(chan Message, 2)
+	runner.Execute(ctx, new(sync.WaitGroup), outputChan, db)
+	runner.Execute(ctx, new(sync.WaitGroup), outputChan, web)
+
+	var webErrors []string
+	webRuns := 0
+	streamLogs(outputChan, 2, func(message Message) {
+		if message.CommandName() != "web" {
+			return
+		}
+		switch message.Type {
+		case SystemError:
+			webErrors = append(webErrors, message.Content)
+		case OutputRunning:
+			// Stop once web runs again after db restarted
+			if webRuns++; webRuns == 2 {
+				cancel()
+			}
+		}
+	})
+
+	assert.Equal(t, 2, webRuns)
+	assert.Equal(t, "restarting along with db", webErrors[0])
+}
+
+func TestExecuteStopTimeout(t *testing.T) {
+	for _, test := range []struct {
+		script     string
+		stopSignal string
+		stopped    string
+		errors     []string
+	}{
+		{"echo ready; exec sleep 5", "", "stopped gracefully", nil},
+		{"trap '' TERM; echo ready; sleep 5", "", "killed after stop_timeout of 200ms", []string{"did not stop within stop_timeout of 200ms, killed"}},
+		{"trap 'exit 0' QUIT; trap '' TERM; echo ready; while :; do sleep 0.01; done", "SIGQUIT", "stopped gracefully", nil},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		outputChan := make(chan Message, 2)
+		start := time.Now()
+		Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+			Name:        "stubborn",
+			Command:     "sh",
+			Args:        []string{"-c", test.script},
+			StopSignal:  test.stopSignal,
+			StopTimeout: 200 * time.Millisecond,
+		})
+
+		var stopped string
+		var errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputStdout:
+				cancel()
+			case OutputStopped:
+				stopped = message.Content
+			case SystemError:
+				errors = append(errors, message.Content)
+			}
+		})
+		cancel()
+
+		assert.Equal(t, test.stopped, stopped)
+		assert.Equal(t, test.errors, errors)
+		assert.Less(t, time.Since(start), 2*time.Second)
+	}
+}
+
+func TestExecuteOutputCommand(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "login",
+		Command: "true",
+		Args:    []string{"--user", "admin", "--password=hunter2"},
+		Path:    []string{"/bin", "/usr/bin"},
+	})
+
+	var lines []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputCommand {
+			lines = append(lines, message.Content)
+		}
+	})
+
+	assert.Len(t, lines, 1)
+	assert.Regexp(t, `^/(usr/)?bin/true --user admin --password=\*\*\*$`, lines[0])
+}
+
+func TestLoadConfigWithoutApps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("version: \"1\"\napps: []\n"), 0o644))
+	_, err := loadConfig(path)
+	assert.EqualError(t, err, "no apps defined, pass --allow-empty to exit cleanly instead")
+
+	*allowEmpty = true
+	defer func() { *allowEmpty = false }()
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Empty(t, config.Apps)
+
+	// Streaming the logs of no commands returns right away
+	messages := 0
+	streamLogs(make(chan Message), 0, func(message Message) { messages++ })
+	assert.Zero(t, messages)
+}
+
+func TestStreamLogsDrains(t *testing.T) {
+	web := &Command{Name: "web"}
+	worker := &Command{Name: "worker"}
+	outputChan := make(chan Message, 10)
+	outputChan <- Message{Type: OutputEnd, Command: web}
+	outputChan <- Message{Type: OutputEnd, Command: worker}
+	outputChan <- Message{Content: "trailing", Type: OutputStdout, Command: web}
+	outputChan <- Message{Content: "lines", Type: OutputStdout, Command: web}
+
+	// The lines buffered behind the last OutputEnd aren't dropped
+	var lines []string
+	streamLogs(outputChan, 2, func(message Message) {
+		if message.Type == OutputStdout {
+			lines = append(lines, message.Content)
+		}
+	})
+	assert.Equal(t, []string{"trailing", "lines"}, lines)
+	assert.Empty(t, outputChan)
+
+	// Draining stops at a closed channel
+	outputChan <- Message{Type: OutputEnd, Command: web}
+	close(outputChan)
+	streamLogs(outputChan, 1, func(message Message) {})
+}
+
+func TestLoadConfigShutdownTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := `version: "1"
+shutdown_timeout: 30s
+apps:
+  - name: web
+    command: web
+  - name: db
+    command: db
+    stop_timeout: 1m
+`
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+	loaded, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, stopTimeout(loaded.Apps[0]))
+	assert.Equal(t, time.Minute, stopTimeout(loaded.Apps[1]))
+}
+
+func TestExecuteOnCrash(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "crasher",
+		Command: "sh",
+		Args:    []string{"-c", "exit 2"},
+		OnCrash: []string{"sh", "-c", "echo collecting after $PSMGMT_EXIT_CODE"},
+	})
+
+	var lines []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.CommandName() == "crasher:on_crash" {
+			lines = append(lines, message.Content)
+		}
+	})
+	assert.Equal(t, []string{"collecting after 2"}, lines)
+}
+
+func TestLoadConfigDependsOnCycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	config := `version: "1"
+apps:
+  - name: web
+    command: web
+    depends_on: [api]
+  - name: api
+    command: api
+    depends_on: [web]
+`
+	assert.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+	_, err := loadConfig(path)
+	assert.EqualError(t, err, "apps depend on each other in a cycle: web -> api -> web")
+}
+
+func TestExecuteTimestamps(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	before := time.Now()
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "stamped",
+		Command: "sh",
+		Args:    []string{"-c", "echo out; echo err >&2; exit 1"},
+	})
+
+	var types []MessageType
+	streamLogs(outputChan, 1, func(message Message) {
+		types = append(types, message.Type)
+		assert.False(t, message.Timestamp.Before(before), message.Type.Name())
+		assert.False(t, message.Timestamp.After(time.Now()), message.Type.Name())
+	})
+	assert.Contains(t, types, OutputStdout)
+	assert.Contains(t, types, OutputStderr)
+	assert.Contains(t, types, SystemError)
+}
+
+func TestExecuteTimestampsClock(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	runner := NewRunner()
+	runner.Clock = NewFakeClock(now)
+	outputChan := make(chan Message, 2)
+	runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{Name: "stamped", Command: "echo", Args: []string{"out"}})
+
+	streamLogs(outputChan, 1, func(message Message) {
+		assert.Equal(t, now, message.Timestamp, message.Type.Name())
+	})
+}
+
+func TestTimestampWriter(t *testing.T) {
+	var b strings.Builder
+	logger := log.New(timestampWriter{clock: NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)), w: &b}, "", 0)
+	logger.Print("[system::SystemError]: reload: keeping the current config")
+	assert.Equal(t, "2024-05-01T12:00:00.000Z [system::SystemError]: reload: keeping the current config\n", b.String())
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	outputChan := make(chan Message, 2)
+	start := time.Now()
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "job",
+		Command: "sleep",
+		Args:    []string{"5"},
+		Timeout: 200 * time.Millisecond,
+	})
+
+	var errors []string
+	exitCode := 0
+	streamLogs(outputChan, 1, func(message Message) {
+		switch message.Type {
+		case SystemError:
+			errors = append(errors, message.Content)
+		case OutputEnd:
+			exitCode = message.ExitCode
+		}
+	})
+	assert.Equal(t, []string{"command timed out after 200ms"}, errors)
+	assert.Equal(t, -1, exitCode)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// Shutting down before the timeout is no timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	outputChan = make(chan Message, 2)
+	Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+		Name:    "job",
+		Command: "sh",
+		Args:    []string{"-c", "echo started; exec sleep 5"},
+		Timeout: 5 * time.Second,
+	})
+	errors = nil
+	var stopped string
+	streamLogs(outputChan, 1, func(message Message) {
+		switch message.Type {
+		case OutputStdout:
+			cancel()
+		case OutputStopped:
+			stopped = message.Content
+		case SystemError:
+			errors = append(errors, message.Content)
+		}
+	})
+	assert.Equal(t, "stopped gracefully", stopped)
+	assert.Empty(t, errors)
+}
+
+func TestResolveConfigPath(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		path string
+		err  string
+	}{
+		{nil, defaultConfigPath, ""},
+		{[]string{"apps.yml"}, "apps.yml", ""},
+		{[]string{"-config", "apps.yml"}, "apps.yml", ""},
+		{[]string{"-c", "apps.yml"}, "apps.yml", ""},
+		{[]string{"-config", "apps.yml", "other.yml"}, "", "give the config file either with -config or as an argument, not both"},
+		{[]string{"apps.yml", "other.yml"}, "", "expected a single config file, got 2 arguments"},
+	} {
+		flags := flag.NewFlagSet("psmgmt", flag.ContinueOnError)
+		path := flags.String("config", defaultConfigPath, "")
+		flags.StringVar(path, "c", defaultConfigPath, "")
+		assert.NoError(t, flags.Parse(test.args))
+
+		resolved, err := resolveConfigPath(flags)
+		if test.err != "" {
+			assert.EqualError(t, err, test.err, test.args)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, test.path, resolved, test.args)
+	}
+}
+
+func TestLoadConfigPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apps.yml")
+	assert.NoError(t, os.WriteFile(path, []byte("version: \"1\"\napps:\n  - name: web\n    command: web\n"), 0o644))
+
+	config, err := loadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "web", config.Apps[0].Name)
+
+	_, err = loadConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.ErrorContains(t, err, "config file does not exist")
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		yaml string
+		err  string
+	}{
+		{"unsupported version", "version: \"2\"\napps:\n  - name: web\n    command: web\n", "unsupported config version"},
+		{"missing version", "apps:\n  - name: web\n    command: web\n", "unsupported config version"},
+		{"empty", "", "unsupported config version"},
+		{"broken YAML", "version: \"1\"\napps: [\n", "error parsing YAML content: yaml: line 2: did not find expected node content"},
+	} {
+		_, err := parseConfig(strings.NewReader(test.yaml))
+		assert.EqualError(t, err, test.err, test.name)
+	}
+
+	config, err := parseConfig(strings.NewReader("version: \"1\"\nshutdown_timeout: 5s\napps:\n  - name: web\n    command: web\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Second, config.Apps[0].StopTimeout)
+}
+
+func TestParseConfigUnknownField(t *testing.T) {
+	_, err := parseConfig(strings.NewReader("version: \"1\"\napps:\n  - name: web\n    comand: web\n"))
+	assert.ErrorContains(t, err, "field comand not found")
+}
+
+func TestParseConfigApps(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		apps string
+		err  string
+	}{
+		{"empty command", "  - name: web\n    command: web\n  - name: worker\n    args: [--queue, jobs]\n", `apps[1] "worker": command is required`},
+		{"shell without command", "  - name: idle\n    builtin: keepalive\n    shell: true\n", `apps[0] "idle": shell requires command`},
+		{"negative max_line_bytes", "  - name: web\n    command: web\n    max_line_bytes: -1\n", `apps[0] "web": max_line_bytes must not be negative`},
+		{"negative start_delay", "  - name: web\n    command: web\n    start_delay: -1s\n", `apps[0] "web": start_delay must not be negative`},
+		{"negative head_lines", "  - name: web\n    command: web\n    head_lines: -1\n", `apps[0] "web": head_lines must not be negative`},
+		{"duplicate name", "  - name: web\n    command: web\n  - name: Web\n    command: web\n", `apps[1] "Web": duplicate name, already used by apps[0] "web"`},
+		{"missing pipe_through", "  - name: web\n    command: web\n    pipe_through: [psmgmt-test-missing]\n", `apps[0] "web": invalid pipe_through: exec: "psmgmt-test-missing": executable file not found in $PATH`},
+		{"pipe_through outside path", "  - name: web\n    command: web\n    path: [/psmgmt-test-missing]\n    pipe_through: [sh]\n", `apps[0] "web": invalid pipe_through: executable "sh" not found in path [/psmgmt-test-missing]`},
+		{"empty pipe_through", "  - name: web\n    command: web\n    pipe_through: [\"\"]\n", `apps[0] "web": invalid pipe_through: pipe_through requires a command`},
+	} {
+		_, err := parseConfig(strings.NewReader("version: \"1\"\napps:\n" + test.apps))
+		assert.EqualError(t, err, test.err, test.name)
+	}
+
+	// Builtins and containers don't need a command
+	_, err := parseConfig(strings.NewReader("version: \"1\"\napps:\n  - name: idle\n    builtin: keepalive\n  - name: db\n    image: postgres:16\n    working_dir: /var/lib/psmgmt-test-missing\n"))
+	assert.NoError(t, err)
+}
+
+func TestRun(t *testing.T) {
+	defer func() { *noSignalHandling = false }()
+	for _, test := range []struct {
+		apps string
+		code int
+	}{
+		{"  - name: ok\n    command: \"true\"\n", 0},
+		{"  - name: ok\n    command: \"true\"\n  - name: lint\n    command: sh\n    args: [-c, exit 2]\n  - name: test\n    command: sh\n    args: [-c, exit 5]\n", 5},
+		{"  - name: missing\n    command: psmgmt-test-missing\n", 1},
+		// Truncated lines and restarts of runs that succeed are only notices
+		{"  - name: long\n    command: sh\n    args: [-c, echo toolong]\n    max_line_bytes: 4\n    restart: always\n    max_retries: 2\n    restart_backoff: 1ms\n", 0},
+		{"  - name: flaky\n    command: sh\n    args: [-c, exit 3]\n    restart: on-failure\n    max_retries: 1\n    restart_backoff: 1ms\n", 3},
+		// Apps stopped to be restarted along with another one didn't fail
+		{"  - name: a\n    command: \"true\"\n    restart: always\n    max_retries: 1\n    restart_backoff: 100ms\n    restart_with: [b]\n  - name: b\n    command: sleep\n    args: [\"0.5\"]\n", 0},
+	} {
+		path := filepath.Join(t.TempDir(), "psmgmt.yml")
+		assert.NoError(t, os.WriteFile(path, []byte("version: \"1\"\napps:\n"+test.apps), 0o644))
+		assert.Equal(t, test.code, run([]string{"--no-signal-handling", path}), test.apps)
+	}
+
+	// A config that doesn't load fails before anything runs
+	assert.Equal(t, 1, run([]string{"--no-signal-handling", filepath.Join(t.TempDir(), "missing.yml")}))
+}
+
+func TestRunShutdown(t *testing.T) {
+	defer func() { *noSignalHandling = false }()
+
+	// Missing the startup deadline shuts down mid-run: one app prints while it
+	// stops, one is killed for ignoring its stop signal, one waits for another
+	path := filepath.Join(t.TempDir(), "psmgmt.yml")
+	assert.NoError(t, os.WriteFile(path, []byte(`version: "1"
+startup_deadline: 200ms
+apps:
+  - name: chatty
+    command: sh
+    args: [-c, "trap 'seq 500; exit 0' TERM; while :; do :; done"]
+    ready_when: never printed
+  - name: stubborn
+    command: sh
+    args: [-c, "trap '' TERM; while :; do :; done"]
+    stop_timeout: 100ms
+  - name: waiting
+    command: "true"
+    depends_on: [chatty]
+`), 0o644))
+	assert.NotPanics(t, func() {
+		assert.Equal(t, 1, run([]string{"--no-signal-handling", path}))
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memorySampleInterval is the interval at which the memory usage of processes
+// with a restart_memory_threshold is sampled.
+const memorySampleInterval = 5 * time.Second
+
+// sizeUnits are the multipliers of the suffixes accepted by parseSize.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier uint64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// parseSize parses a size in bytes with an optional binary suffix, like "512M",
+// "1.5GiB" or "800KB".
+func parseSize(s string) (uint64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	multiplier := uint64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(value * float64(multiplier)), nil
+}
+
+// formatSize formats a size in bytes as mebibytes.
+func formatSize(size uint64) string {
+	return fmt.Sprintf("%.1fMiB", float64(size)/(1<<20))
+}
+
+// watchMemory samples the resident memory of the process until exited is closed.
+// When it exceeds threshold, the process is stopped with stop so that it is
+// restarted, which is reported as true along with an error giving the reason.
+func (r *Runner) watchMemory(process *os.Process, threshold uint64, exited <-chan struct{}, stop func()) (bool, error) {
+	for {
+		select {
+		case <-exited:
+			return false, nil
+		case <-r.Clock.After(memorySampleInterval):
+		}
+
+		rss, err := processRSS(process.Pid)
+		if err != nil {
+			select {
+			case <-exited:
+				return false, nil
+			default:
+				return false, fmt.Errorf("error sampling memory usage: %w", err)
+			}
+		}
+		if rss > threshold {
+			stop()
+			return true, fmt.Errorf("memory usage of %s exceeded restart_memory_threshold of %s, restarting", formatSize(rss), formatSize(threshold))
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// validateMemoryThreshold checks the restart_memory_threshold of a command at load.
+func validateMemoryThreshold(threshold string) error {
+	if _, err := parseSize(threshold); err != nil {
+		return fmt.Errorf("invalid restart_memory_threshold: %w", err)
+	}
+	return nil
+}
+
+// processRSS returns the resident memory of the process in bytes, read from
+// /proc. Zombies that exited but weren't waited for yet use no memory.
+func processRSS(pid int) (uint64, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "VmRSS:")
+		if !ok {
+			continue
+		}
+		kib, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing VmRSS: %w", err)
+		}
+		return kib * 1024, nil
+	}
+	return 0, scanner.Err()
+}
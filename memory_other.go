@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// validateMemoryThreshold reports an error when restart_memory_threshold is set,
+// since memory sampling is only implemented on Linux.
+func validateMemoryThreshold(threshold string) error {
+	if threshold != "" {
+		return errors.New("restart_memory_threshold is only supported on Linux")
+	}
+	return nil
+}
+
+// processRSS reports an error, since memory sampling is only implemented on Linux.
+func processRSS(pid int) (uint64, error) {
+	return 0, errors.New("memory sampling is only supported on Linux")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for input, expected := range map[string]uint64{
+		"4096":   4096,
+		"512M":   512 << 20,
+		"1.5GiB": 3 << 29,
+		"800KB":  800 << 10,
+		"2g":     2 << 30,
+	} {
+		size, err := parseSize(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+
+	for _, input := range []string{"", "M", "-1G", "12X"} {
+		_, err := parseSize(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestWatchMemory(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory sampling is only supported on Linux")
+	}
+
+	rss, err := processRSS(os.Getpid())
+	assert.NoError(t, err)
+	assert.NotZero(t, rss)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sleep", "5")
+	assert.NoError(t, cmd.Start())
+
+	clock := NewFakeClock(time.Now())
+	runner := &Runner{Clock: clock}
+	result := make(chan error)
+	go func() {
+		exceeded, err := runner.watchMemory(cmd.Process, 1, make(chan struct{}), cancel)
+		assert.True(t, exceeded)
+		result <- err
+	}()
+
+	assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+	clock.Advance(memorySampleInterval)
+	assert.ErrorContains(t, <-result, "exceeded restart_memory_threshold of 0.0MiB, restarting")
+	assert.EqualError(t, cmd.Wait(), "signal: killed")
+}
+
+func TestExecuteRestartMemoryThresholdKills(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory sampling is only supported on Linux")
+	}
+
+	// A process ignoring its stop signal is killed after its stop_timeout
+	clock := NewFakeClock(time.Now())
+	runner := NewRunner()
+	runner.Clock, runner.NoRestart = clock, true
+	outputChan := make(chan Message, 10)
+	runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:                   "leaky",
+		Command:                "sh",
+		Args:                   []string{"-c", "trap '' TERM; echo up; while :; do sleep 0.1; done"},
+		RestartMemoryThreshold: "1K",
+		StopTimeout:            100 * time.Millisecond,
+	})
+
+	var reported []string
+	streamLogs(outputChan, 1, func(message Message) {
+		switch message.Type {
+		case OutputStdout:
+			assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+			clock.Advance(memorySampleInterval)
+		case SystemError:
+			reported = append(reported, message.Content)
+		}
+	})
+	assert.Len(t, reported, 2)
+	assert.Contains(t, reported[0], "exceeded restart_memory_threshold of 0.0MiB, restarting")
+	assert.Equal(t, "did not stop within stop_timeout of 100ms, killed", reported[1])
+}
+
+func TestValidateMemoryThreshold(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		assert.EqualError(t, validateMemoryThreshold("512M"), "restart_memory_threshold is only supported on Linux")
+		return
+	}
+	assert.NoError(t, validateMemoryThreshold("512M"))
+	assert.ErrorContains(t, validateMemoryThreshold("12X"), "invalid restart_memory_threshold: ")
+}
+
+func TestExecuteRestartMemoryThresholdStops(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory sampling is only supported on Linux")
+	}
+
+	// A process stopping on its stop signal is restarted without failing
+	clock := NewFakeClock(time.Now())
+	runner := NewRunner()
+	runner.Clock, runner.NoRestart = clock, true
+	outputChan := make(chan Message, 10)
+	runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:                   "leaky",
+		Command:                "sh",
+		Args:                   []string{"-c", "echo up; while :; do sleep 0.1; done"},
+		RestartMemoryThreshold: "1K",
+	})
+
+	var failed []string
+	streamLogs(outputChan, 1, func(message Message) {
+		switch {
+		case message.Type == OutputStdout:
+			assert.Eventually(t, func() bool { return clock.Waiters() == 1 }, time.Second, time.Millisecond)
+			clock.Advance(memorySampleInterval)
+		case message.Failed:
+			failed = append(failed, message.Content)
+		}
+	})
+	assert.Empty(t, failed)
+}
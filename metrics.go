@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lifetimeBuckets are the upper bounds, in seconds, of the buckets of the
+// process lifetime histogram.
+var lifetimeBuckets = []float64{1, 5, 10, 30, 60, 300, 900, 3600, 21600, 86400}
+
+// appMetrics are the metrics of a single app.
+type appMetrics struct {
+	restarts int
+	// runs counts the processes started since the last OutputStart, every one
+	// after the first a restart.
+	runs int
+	// up is set while a process runs, which started at since.
+	up    bool
+	since time.Time
+	// lifetimes counts the processes that lived up to each of lifetimeBuckets,
+	// plus the ones that lived longer.
+	lifetimes    []int
+	lifetimeSum  float64
+	lifetimeRuns int
+}
+
+// metricsServer is a Sink serving Prometheus metrics of the apps derived from
+// the messages: their restarts, whether they are up and how long their
+// processes lived, along with their output lines counted by the Runner.
+type metricsServer struct {
+	server   *http.Server
+	listener net.Listener
+	// throughput returns the output counted by the Runner
+	throughput func() []ThroughputStats
+
+	mu    sync.Mutex
+	order []string
+	apps  map[string]*appMetrics
+}
+
+// newMetricsServer starts serving the metrics of the apps on addr, e.g.
+// ":9090", under /metrics, with their output lines taken from throughput.
+func newMetricsServer(addr string, apps []Command, throughput func() []ThroughputStats) (*metricsServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting metrics server: %w", err)
+	}
+
+	metrics := &metricsServer{listener: listener, throughput: throughput, apps: make(map[string]*appMetrics, len(apps))}
+	for _, command := range apps {
+		metrics.order = append(metrics.order, command.Name)
+		metrics.apps[command.Name] = &appMetrics{lifetimes: make([]int, len(lifetimeBuckets)+1)}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.serveMetrics)
+	metrics.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := metrics.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logSystem("SystemError", "error serving metrics: %v", err)
+		}
+	}()
+	return metrics, nil
+}
+
+// setApps changes the apps served to the ones of a reloaded config. The apps
+// that were already served keep their metrics.
+func (m *metricsServer) setApps(apps []Command) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.order = m.order[:0]
+	for _, command := range apps {
+		m.order = append(m.order, command.Name)
+		if _, ok := m.apps[command.Name]; !ok {
+			m.apps[command.Name] = &appMetrics{lifetimes: make([]int, len(lifetimeBuckets)+1)}
+		}
+	}
+}
+
+// Write updates the metrics of the message's app.
+func (m *metricsServer) Write(message Message) error {
+	if message.Command == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	app, ok := m.apps[message.Command.Name]
+	if !ok {
+		return nil
+	}
+	switch message.Type {
+	case OutputStart:
+		app.runs = 0
+	case OutputRunning:
+		app.exited(message.Timestamp)
+		if app.runs++; app.runs > 1 {
+			app.restarts++
+		}
+		app.up, app.since = true, message.Timestamp
+	case OutputExited, OutputEnd:
+		app.exited(message.Timestamp)
+	}
+	return nil
+}
+
+// exited records that the running process, if any, exited at end.
+func (a *appMetrics) exited(end time.Time) {
+	if !a.up {
+		return
+	}
+	a.up = false
+	lifetime := end.Sub(a.since).Seconds()
+	bucket := len(lifetimeBuckets)
+	for i, bound := range lifetimeBuckets {
+		if lifetime <= bound {
+			bucket = i
+			break
+		}
+	}
+	a.lifetimes[bucket]++
+	a.lifetimeSum += lifetime
+	a.lifetimeRuns++
+}
+
+// serveMetrics responds with the metrics in the Prometheus text format.
+func (m *metricsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	lines := make(map[string]ThroughputStats)
+	for _, stats := range m.throughput() {
+		lines[stats.Name] = stats
+	}
+
+	var buf bytes.Buffer
+	m.mu.Lock()
+	buf.WriteString("# HELP psmgmt_restarts_total Number of times the process of the app was restarted.\n# TYPE psmgmt_restarts_total counter\n")
+	for _, name := range m.order {
+		fmt.Fprintf(&buf, "psmgmt_restarts_total{app=%s} %d\n", quoteLabel(name), m.apps[name].restarts)
+	}
+	buf.WriteString("# HELP psmgmt_output_lines_total Number of lines the app wrote.\n# TYPE psmgmt_output_lines_total counter\n")
+	for _, name := range m.order {
+		fmt.Fprintf(&buf, "psmgmt_output_lines_total{app=%s,stream=\"stdout\"} %d\n", quoteLabel(name), lines[name].StdoutLines)
+		fmt.Fprintf(&buf, "psmgmt_output_lines_total{app=%s,stream=\"stderr\"} %d\n", quoteLabel(name), lines[name].StderrLines)
+	}
+	buf.WriteString("# HELP psmgmt_up Whether the process of the app is running.\n# TYPE psmgmt_up gauge\n")
+	for _, name := range m.order {
+		up := 0
+		if m.apps[name].up {
+			up = 1
+		}
+		fmt.Fprintf(&buf, "psmgmt_up{app=%s} %d\n", quoteLabel(name), up)
+	}
+	buf.WriteString("# HELP psmgmt_process_lifetime_seconds How long the processes of the app ran before exiting.\n# TYPE psmgmt_process_lifetime_seconds histogram\n")
+	for _, name := range m.order {
+		app := m.apps[name]
+		count := 0
+		for i, bound := range lifetimeBuckets {
+			count += app.lifetimes[i]
+			fmt.Fprintf(&buf, "psmgmt_process_lifetime_seconds_bucket{app=%s,le=\"%s\"} %d\n", quoteLabel(name), strconv.FormatFloat(bound, 'g', -1, 64), count)
+		}
+		fmt.Fprintf(&buf, "psmgmt_process_lifetime_seconds_bucket{app=%s,le=\"+Inf\"} %d\n", quoteLabel(name), app.lifetimeRuns)
+		fmt.Fprintf(&buf, "psmgmt_process_lifetime_seconds_sum{app=%s} %s\n", quoteLabel(name), strconv.FormatFloat(app.lifetimeSum, 'g', -1, 64))
+		fmt.Fprintf(&buf, "psmgmt_process_lifetime_seconds_count{app=%s} %d\n", quoteLabel(name), app.lifetimeRuns)
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		logSystem("SystemError", "error serving metrics: %v", err)
+	}
+}
+
+// quoteLabel quotes a label value, escaping backslashes, double quotes and
+// newlines as the Prometheus text format requires.
+func quoteLabel(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// Close stops serving the metrics.
+func (m *metricsServer) Close() error {
+	return m.server.Close()
+}
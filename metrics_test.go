@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsServer(t *testing.T) {
+	web := &Command{Name: "web"}
+	job := &Command{Name: "job"}
+	lines := []ThroughputStats{{Name: "web", StdoutLines: 2, StderrLines: 1}}
+	metrics, err := newMetricsServer("127.0.0.1:0", []Command{*web, *job}, func() []ThroughputStats { return lines })
+	assert.NoError(t, err)
+	defer metrics.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	for _, message := range []Message{
+		{Type: OutputStart, Command: web, Timestamp: at(0)},
+		{Type: OutputRunning, Command: web, Pid: 42, Timestamp: at(0)},
+		{Type: OutputExited, Command: web, ExitCode: 1, Timestamp: at(3)},
+		{Type: SystemError, Content: "exited with code 1, restarting in 100ms (restart 1)", Command: web, Timestamp: at(3)},
+		{Type: OutputRunning, Command: web, Pid: 43, Timestamp: at(4)},
+		{Type: OutputStart, Command: job, Timestamp: at(0)},
+		{Type: OutputRunning, Command: job, Pid: 44, Timestamp: at(0)},
+		{Type: OutputEnd, Command: job, Timestamp: at(120)},
+		{Type: SystemError, Content: "unrelated", Timestamp: at(120)},
+	} {
+		assert.NoError(t, metrics.Write(message))
+	}
+
+	resp, err := http.Get("http://" + metrics.listener.Addr().String() + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+
+	for _, line := range []string{
+		"# TYPE psmgmt_restarts_total counter",
+		`psmgmt_restarts_total{app="web"} 1`,
+		`psmgmt_restarts_total{app="job"} 0`,
+		"# TYPE psmgmt_output_lines_total counter",
+		`psmgmt_output_lines_total{app="web",stream="stdout"} 2`,
+		`psmgmt_output_lines_total{app="web",stream="stderr"} 1`,
+		`psmgmt_output_lines_total{app="job",stream="stdout"} 0`,
+		"# TYPE psmgmt_up gauge",
+		`psmgmt_up{app="web"} 1`,
+		`psmgmt_up{app="job"} 0`,
+		"# TYPE psmgmt_process_lifetime_seconds histogram",
+		`psmgmt_process_lifetime_seconds_bucket{app="web",le="1"} 0`,
+		`psmgmt_process_lifetime_seconds_bucket{app="web",le="5"} 1`,
+		`psmgmt_process_lifetime_seconds_sum{app="web"} 3`,
+		`psmgmt_process_lifetime_seconds_count{app="web"} 1`,
+		`psmgmt_process_lifetime_seconds_bucket{app="job",le="60"} 0`,
+		`psmgmt_process_lifetime_seconds_bucket{app="job",le="300"} 1`,
+		`psmgmt_process_lifetime_seconds_bucket{app="job",le="+Inf"} 1`,
+	} {
+		assert.Contains(t, string(body), line+"\n")
+	}
+
+	// A reload adds apps and removes others, keeping the metrics of the rest
+	db := &Command{Name: "db"}
+	metrics.setApps([]Command{*web, *db})
+	lines = append(lines, ThroughputStats{Name: "db", StdoutLines: 1})
+	resp, err = http.Get("http://" + metrics.listener.Addr().String() + "/metrics")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `psmgmt_output_lines_total{app="web",stream="stdout"} 2`+"\n")
+	assert.Contains(t, string(body), `psmgmt_output_lines_total{app="db",stream="stdout"} 1`+"\n")
+	assert.NotContains(t, string(body), `app="job"`)
+}
+
+func TestQuoteLabel(t *testing.T) {
+	assert.Equal(t, `"web"`, quoteLabel("web"))
+	assert.Equal(t, `"a\\b\"c\nd"`, quoteLabel("a\\b\"c\nd"))
+}
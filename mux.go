@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+// fairMuxBuffer is the number of messages a command can produce ahead of the
+// consumer before it blocks.
+const fairMuxBuffer = 64
+
+// fairMux merges the messages of the commands, each sending on a channel of its
+// own, into a single channel round-robin: every round forwards at most one
+// message of every command. A command flooding its output only fills its own
+// buffer, so that the lifecycle messages of the others still get through.
+type fairMux struct {
+	out chan<- Message
+
+	mu     sync.Mutex
+	inputs map[string]chan Message
+	order  []chan Message
+
+	added   chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// newFairMux returns a running multiplexer into out.
+func newFairMux(out chan<- Message) *fairMux {
+	m := &fairMux{
+		out:     out,
+		inputs:  make(map[string]chan Message),
+		added:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+// input returns the channel the named command sends its messages on.
+func (m *fairMux) input(name string) chan<- Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if input, ok := m.inputs[name]; ok {
+		return input
+	}
+	input := make(chan Message, fairMuxBuffer)
+	m.inputs[name] = input
+	m.order = append(m.order, input)
+	select {
+	case m.added <- struct{}{}:
+	default:
+	}
+	return input
+}
+
+// stop stops forwarding messages, dropping the ones that weren't forwarded yet.
+func (m *fairMux) stop() {
+	close(m.done)
+	<-m.stopped
+}
+
+// run forwards the messages until the multiplexer is stopped.
+func (m *fairMux) run() {
+	defer close(m.stopped)
+	for {
+		m.mu.Lock()
+		inputs := m.order
+		m.mu.Unlock()
+
+		// Take one message of every command that has one
+		forwarded := false
+		for _, input := range inputs {
+			select {
+			case message := <-input:
+				if !m.forward(message) {
+					return
+				}
+				forwarded = true
+			default:
+			}
+		}
+		if forwarded {
+			continue
+		}
+
+		// Wait until any command sends a message or a command is added
+		cases := make([]reflect.SelectCase, 0, len(inputs)+2)
+		cases = append(cases,
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.done)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(m.added)},
+		)
+		for _, input := range inputs {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(input)})
+		}
+		chosen, value, _ := reflect.Select(cases)
+		switch chosen {
+		case 0:
+			return
+		case 1:
+		default:
+			if !m.forward(value.Interface().(Message)) {
+				return
+			}
+		}
+	}
+}
+
+// forward sends the message to out, reporting false if the multiplexer was
+// stopped first.
+func (m *fairMux) forward(message Message) bool {
+	select {
+	case m.out <- message:
+		return true
+	case <-m.done:
+		return false
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFairMux(t *testing.T) {
+	out := make(chan Message)
+	mux := newFairMux(out)
+	defer mux.stop()
+
+	// A command flooding its output fills its buffer
+	chatty := &Command{Name: "chatty"}
+	quiet := &Command{Name: "quiet"}
+	chattyInput := mux.input(chatty.Name)
+	go func() {
+		for i := 0; i < 10*fairMuxBuffer; i++ {
+			chattyInput <- Message{Content: "spam", Type: OutputStdout, Command: chatty}
+		}
+	}()
+	assert.Eventually(t, func() bool { return len(chattyInput) == fairMuxBuffer }, time.Second, time.Millisecond)
+
+	// The quiet command gets its turn in the next round
+	mux.input(quiet.Name) <- Message{Type: OutputEnd, Command: quiet}
+	var names []string
+	for len(names) < 4 {
+		names = append(names, (<-out).CommandName())
+	}
+	assert.Contains(t, names[:3], "quiet")
+
+	// The same name gets the same channel
+	assert.Equal(t, mux.input(quiet.Name), mux.input(quiet.Name))
+}
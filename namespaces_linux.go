@@ -0,0 +1,67 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// namespaceFlags maps the supported namespace names to their clone flags.
+var namespaceFlags = map[string]uintptr{
+	"ipc":   syscall.CLONE_NEWIPC,
+	"mount": syscall.CLONE_NEWNS,
+	"net":   syscall.CLONE_NEWNET,
+	"pid":   syscall.CLONE_NEWPID,
+	"user":  syscall.CLONE_NEWUSER,
+	"uts":   syscall.CLONE_NEWUTS,
+}
+
+// validateNamespaces checks that every requested namespace is supported and
+// requested once.
+func validateNamespaces(namespaces []string) error {
+	seen := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		if _, ok := namespaceFlags[namespace]; !ok {
+			return fmt.Errorf("unknown namespace %q", namespace)
+		}
+		if seen[namespace] {
+			return fmt.Errorf("duplicate namespace %q", namespace)
+		}
+		seen[namespace] = true
+	}
+	return nil
+}
+
+// setupNamespaces arranges for cmd to be started in the namespaces requested by the command.
+// Unprivileged users can only create namespaces together with a user namespace,
+// in which case the current user is mapped to root inside it.
+func setupNamespaces(cmd *exec.Cmd, command Command) error {
+	if len(command.Namespaces) == 0 {
+		return nil
+	}
+
+	var flags uintptr
+	for _, namespace := range command.Namespaces {
+		flag, ok := namespaceFlags[namespace]
+		if !ok {
+			return fmt.Errorf("unknown namespace %q", namespace)
+		}
+		flags |= flag
+	}
+
+	if flags&syscall.CLONE_NEWUSER == 0 && os.Geteuid() != 0 {
+		return errors.New(`creating namespaces requires root or the "user" namespace`)
+	}
+
+	attr := sysProcAttr(cmd)
+	attr.Cloneflags |= flags
+	if flags&syscall.CLONE_NEWUSER != 0 {
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	}
+	return nil
+}
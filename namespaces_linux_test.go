@@ -0,0 +1,50 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupNamespaces(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		namespaces []string
+		flags      uintptr
+		mapped     bool
+		err        string
+	}{
+		{"none", nil, 0, false, ""},
+		{"user", []string{"user", "net"}, syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET, true, ""},
+		{"unknown", []string{"cgroup"}, 0, false, `unknown namespace "cgroup"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cmd := exec.Command("true")
+			err := setupNamespaces(cmd, Command{Namespaces: test.namespaces})
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			if test.flags == 0 {
+				assert.Nil(t, cmd.SysProcAttr)
+				return
+			}
+			assert.Equal(t, test.flags, cmd.SysProcAttr.Cloneflags)
+			assert.Equal(t, test.mapped, len(cmd.SysProcAttr.UidMappings) == 1)
+		})
+	}
+
+	// Without a user namespace only root may create namespaces
+	err := setupNamespaces(exec.Command("true"), Command{Namespaces: []string{"net"}})
+	if os.Geteuid() == 0 {
+		assert.NoError(t, err)
+	} else {
+		assert.EqualError(t, err, `creating namespaces requires root or the "user" namespace`)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// validateNamespaces reports an error when namespaces are requested, since they only exist on Linux.
+func validateNamespaces(namespaces []string) error {
+	if len(namespaces) > 0 {
+		return errors.New("namespaces are only supported on Linux")
+	}
+	return nil
+}
+
+// setupNamespaces is a no-op outside Linux; validateNamespaces rejects any request at load.
+func setupNamespaces(cmd *exec.Cmd, command Command) error {
+	if len(command.Namespaces) > 0 {
+		return errors.New("namespaces are only supported on Linux")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNamespaces(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		assert.NoError(t, validateNamespaces(nil))
+		assert.EqualError(t, validateNamespaces([]string{"net"}), "namespaces are only supported on Linux")
+		assert.EqualError(t, setupNamespaces(exec.Command("true"), Command{Namespaces: []string{"net"}}), "namespaces are only supported on Linux")
+		return
+	}
+
+	for _, test := range []struct {
+		name       string
+		namespaces []string
+		err        string
+	}{
+		{"none", nil, ""},
+		{"valid", []string{"ipc", "mount", "net", "pid", "user", "uts"}, ""},
+		{"unknown", []string{"net", "cgroup"}, `unknown namespace "cgroup"`},
+		{"duplicate", []string{"user", "net", "user"}, `duplicate namespace "user"`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateNamespaces(test.namespaces)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.err)
+			}
+		})
+	}
+}
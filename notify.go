@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state, like "READY=1", to the service manager over the socket in
+// $NOTIFY_SOCKET, as sd_notify(3) does. It does nothing when psmgmt wasn't started
+// by systemd with Type=notify, which includes every other platform.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Go maps a leading "@" to the abstract namespace by itself
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the interval at which the service manager expects
+// WATCHDOG=1, or 0 if no watchdog is configured for psmgmt.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runWatchdog sends WATCHDOG=1 twice per watchdog interval until ctx is done.
+// It returns right away if no watchdog is configured.
+func runWatchdog(ctx context.Context, clock Clock, logf func(format string, args ...any)) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(interval / 2):
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				logf("error notifying watchdog: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLP severity numbers of the log data model.
+const (
+	otlpSeverityInfo  = 9
+	otlpSeverityError = 17
+)
+
+// otlpMaxPending bounds the records an OTLP sink holds while its collector is
+// slow or down, like bulkMaxPending does for bulk sinks.
+const otlpMaxPending = bulkMaxPending
+
+// otlpSink is a Sink exporting every message as an OpenTelemetry log record to a
+// collector, in batches over OTLP/HTTP with JSON encoding.
+type otlpSink struct {
+	url     string
+	client  *http.Client
+	clock   Clock
+	batcher *batcher
+
+	mu      sync.Mutex
+	batch   []otlpLogRecord
+	dropped int
+}
+
+// newOTLPSink returns a sink exporting to the collector at endpoint, like
+// "http://localhost:4318". Records are sent to its /v1/logs path in batches.
+func newOTLPSink(endpoint string, clock Clock, batching Batching) *otlpSink {
+	sink := &otlpSink{
+		url:    strings.TrimSuffix(endpoint, "/") + "/v1/logs",
+		client: &http.Client{Timeout: 10 * time.Second},
+		clock:  clock,
+	}
+	sink.batcher = startBatcher(batching, clock, sink.flush)
+	return sink
+}
+
+// Write adds the message to the current batch, or drops it if the collector
+// fell too far behind.
+func (s *otlpSink) Write(message Message) error {
+	severity, severityText := otlpSeverityInfo, "INFO"
+	if message.IsError || message.Type == SystemError {
+		severity, severityText = otlpSeverityError, "ERROR"
+	}
+	// The time the message was produced, like on the console and in the audit log
+	produced := message.Timestamp
+	if produced.IsZero() {
+		produced = s.clock.Now()
+	}
+	record := otlpLogRecord{
+		TimeUnixNano:   strconv.FormatInt(produced.UnixNano(), 10),
+		SeverityNumber: severity,
+		SeverityText:   severityText,
+		Body:           otlpValue{StringValue: message.Content},
+		Attributes: []otlpAttribute{
+			{Key: "psmgmt.command", Value: otlpValue{StringValue: message.CommandName()}},
+			{Key: "psmgmt.type", Value: otlpValue{StringValue: message.Type.Name()}},
+			{Key: "psmgmt.seq", Value: otlpValue{IntValue: strconv.FormatUint(message.Seq, 10)}},
+		},
+	}
+	if message.Pid != 0 {
+		record.Attributes = append(record.Attributes, otlpAttribute{Key: "process.pid", Value: otlpValue{IntValue: strconv.Itoa(message.Pid)}})
+	}
+	if message.Type == OutputEnd {
+		record.Attributes = append(record.Attributes, otlpAttribute{Key: "process.exit_code", Value: otlpValue{IntValue: strconv.Itoa(message.ExitCode)}})
+	}
+
+	s.mu.Lock()
+	if len(s.batch) >= otlpMaxPending {
+		s.dropped++
+		s.mu.Unlock()
+		return nil
+	}
+	s.batch = append(s.batch, record)
+	pending := len(s.batch)
+	s.mu.Unlock()
+	s.batcher.added(pending)
+	return nil
+}
+
+// Close sends the records that are left and stops the sink.
+func (s *otlpSink) Close() error {
+	s.batcher.stop()
+	return nil
+}
+
+// flush sends the current batch, retrying on transient failures.
+func (s *otlpSink) flush() {
+	s.mu.Lock()
+	batch, dropped := s.batch, s.dropped
+	s.batch, s.dropped = nil, 0
+	s.mu.Unlock()
+	if dropped > 0 {
+		logSystem("SystemError", "dropped %d messages, the OTLP endpoint fell behind", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(otlpRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: "psmgmt"}},
+		}},
+		ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: "psmgmt"}, LogRecords: batch}},
+	}}})
+	if err != nil {
+		logSystem("SystemError", "error encoding OTLP logs: %v", err)
+		return
+	}
+
+	err = sendWithRetries(s.clock, func() (bool, error) {
+		_, retry, err := postBatch(s.client, s.url, "application/json", body)
+		return retry, err
+	})
+	if err != nil {
+		logSystem("SystemError", "error exporting %d OTLP log records: %v", len(batch), err)
+	}
+}
+
+// The OTLP/HTTP JSON encoding of an ExportLogsServiceRequest, limited to the
+// fields psmgmt sets.
+type (
+	otlpRequest struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		TimeUnixNano   string          `json:"timeUnixNano"`
+		SeverityNumber int             `json:"severityNumber"`
+		SeverityText   string          `json:"severityText"`
+		Body           otlpValue       `json:"body"`
+		Attributes     []otlpAttribute `json:"attributes"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue,omitempty"`
+		IntValue    string `json:"intValue,omitempty"`
+	}
+)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOTLPSink(t *testing.T) {
+	var mu sync.Mutex
+	var requests []otlpRequest
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, "/v1/logs", r.URL.Path)
+
+		// The first attempt fails transiently and is retried
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var request otlpRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+	}))
+	defer server.Close()
+
+	sink := newOTLPSink(server.URL, realClock{}, Batching{Size: defaultBatchSize, Interval: defaultFlushInterval})
+	web := &Command{Name: "web"}
+	assert.NoError(t, sink.Write(Message{Type: OutputRunning, Command: web, Pid: 42}))
+	produced := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, sink.Write(Message{Content: "listening", Type: OutputStdout, Command: web, Timestamp: produced}))
+	assert.NoError(t, sink.Write(Message{Content: "boom", Type: SystemError, Command: web}))
+	assert.NoError(t, sink.Close())
+
+	assert.Equal(t, 2, attempts)
+	assert.Len(t, requests, 1)
+	records := requests[0].ResourceLogs[0].ScopeLogs[0].LogRecords
+	assert.Len(t, records, 3)
+	assert.Equal(t, otlpAttribute{Key: "process.pid", Value: otlpValue{IntValue: "42"}}, records[0].Attributes[3])
+	assert.Equal(t, "listening", records[1].Body.StringValue)
+	assert.Equal(t, strconv.FormatInt(produced.UnixNano(), 10), records[1].TimeUnixNano)
+	assert.Equal(t, otlpAttribute{Key: "psmgmt.command", Value: otlpValue{StringValue: "web"}}, records[1].Attributes[0])
+	assert.Equal(t, otlpSeverityInfo, records[1].SeverityNumber)
+	assert.Equal(t, "ERROR", records[2].SeverityText)
+}
+
+func TestOTLPSinkDrops(t *testing.T) {
+	var mu sync.Mutex
+	var records int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request otlpRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		mu.Lock()
+		defer mu.Unlock()
+		records += len(request.ResourceLogs[0].ScopeLogs[0].LogRecords)
+	}))
+	defer server.Close()
+
+	// The records beyond the cap are dropped rather than held
+	sink := newOTLPSink(server.URL, NewFakeClock(time.Now()), Batching{Size: 2 * otlpMaxPending, Interval: time.Hour})
+	web := &Command{Name: "web"}
+	for i := 0; i < otlpMaxPending+5; i++ {
+		assert.NoError(t, sink.Write(Message{Content: "line", Type: OutputStdout, Command: web}))
+	}
+	assert.Equal(t, 5, sink.dropped)
+	assert.NoError(t, sink.Close())
+	assert.Equal(t, otlpMaxPending, records)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// lookPath searches the given directories, in order, for an executable named file,
+// like exec.LookPath does with $PATH. Names containing a slash are returned as is.
+// The result is absolute so that exec doesn't search $PATH for it again.
+func lookPath(file string, dirs []string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for _, dir := range dirs {
+		path, err := filepath.Abs(filepath.Join(dir, file))
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() && info.Mode()&0o111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("executable %q not found in path %v", file, dirs)
+}
+
+// localWorkingDir returns the working_dir of the command on the machine psmgmt
+// runs on, which containers don't have, their working_dir being inside of them.
+func localWorkingDir(command Command) string {
+	if command.Image != "" {
+		return ""
+	}
+	return command.WorkingDir
+}
+
+// checkWorkingDir checks that dir can be used as the working directory of a process.
+func checkWorkingDir(dir string) error {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("working_dir does not exist: %s", dir)
+	} else if err != nil {
+		return fmt.Errorf("invalid working_dir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("working_dir is not a directory: %s", dir)
+	}
+	return nil
+}
+
+// setEnv returns env, a list of KEY=value entries, with key set to value.
+// Existing entries for key are replaced.
+func setEnv(env []string, key string, value string) []string {
+	result := make([]string, 0, len(env)+1)
+	for _, entry := range env {
+		if !strings.HasPrefix(entry, key+"=") {
+			result = append(result, entry)
+		}
+	}
+	return append(result, key+"="+value)
+}
+
+// commandEnv returns the environment the command's process is started with, the
+// inherited one with the command's env and path applied. It returns nil, which
+// makes exec inherit the environment as is, when the command changes nothing.
+func commandEnv(command Command) []string {
+	if len(command.Env) == 0 && len(command.Path) == 0 {
+		return nil
+	}
+	env := os.Environ()
+	for _, key := range sortedKeys(command.Env) {
+		env = setEnv(env, key, command.Env[key])
+	}
+	if len(command.Path) > 0 {
+		env = setEnv(env, "PATH", strings.Join(command.Path, string(os.PathListSeparator)))
+	}
+	return env
+}
+
+// sortedKeys returns the keys of env in order, so that environments are built
+// the same way every time.
+func sortedKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
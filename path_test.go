@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookPath(t *testing.T) {
+	empty := t.TempDir()
+	bin := t.TempDir()
+	tool := filepath.Join(bin, "tool")
+	assert.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\n"), 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "data"), nil, 0o644))
+
+	path, err := lookPath("tool", []string{empty, bin})
+	assert.NoError(t, err)
+	assert.Equal(t, tool, path)
+
+	_, err = lookPath("data", []string{bin})
+	assert.ErrorContains(t, err, "not found")
+
+	path, err = lookPath("./local/tool", []string{bin})
+	assert.NoError(t, err)
+	assert.Equal(t, "./local/tool", path)
+}
+
+func TestCheckWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o644))
+
+	assert.NoError(t, checkWorkingDir(dir))
+	assert.EqualError(t, checkWorkingDir(filepath.Join(dir, "missing")), "working_dir does not exist: "+filepath.Join(dir, "missing"))
+	assert.EqualError(t, checkWorkingDir(file), "working_dir is not a directory: "+file)
+}
+
+func TestSetEnv(t *testing.T) {
+	env := setEnv([]string{"HOME=/root", "PATH=/usr/bin", "PATHEXT=x"}, "PATH", "/opt/bin")
+	assert.Equal(t, []string{"HOME=/root", "PATHEXT=x", "PATH=/opt/bin"}, env)
+}
+
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("FOO", "inherited")
+	assert.Nil(t, commandEnv(Command{}))
+	assert.Nil(t, commandEnv(Command{Env: map[string]string{}}))
+
+	env := commandEnv(Command{Env: map[string]string{"FOO": "bar", "PORT": "8080"}, Path: []string{"/opt/bin"}})
+	assert.Contains(t, env, "FOO=bar")
+	assert.NotContains(t, env, "FOO=inherited")
+	assert.Contains(t, env, "PORT=8080")
+	assert.Equal(t, "PATH=/opt/bin", env[len(env)-1])
+}
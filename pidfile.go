@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// pidFile keeps a file listing the PID of every running command, one "name pid" per line.
+// The file is rewritten whenever a process starts, restarts or exits.
+// A nil pidFile does nothing.
+type pidFile struct {
+	path string
+	pids map[string]int
+}
+
+// newPidFile returns a pidFile writing to path, or nil if path is empty.
+func newPidFile(path string) *pidFile {
+	if path == "" {
+		return nil
+	}
+	return &pidFile{path: path, pids: make(map[string]int)}
+}
+
+// update records the PID carried by an OutputRunning message, or forgets the
+// PID of a command on OutputEnd, and rewrites the file if anything changed.
+func (f *pidFile) update(message Message) error {
+	if f == nil {
+		return nil
+	}
+
+	name := message.CommandName()
+	switch message.Type {
+	case OutputRunning:
+		f.pids[name] = message.Pid
+	case OutputEnd:
+		if _, ok := f.pids[name]; !ok {
+			return nil
+		}
+		delete(f.pids, name)
+	default:
+		return nil
+	}
+	return f.write()
+}
+
+// write replaces the file with the current PIDs, sorted by command name.
+// It writes a temporary file first so that readers never see a partial file.
+func (f *pidFile) write() error {
+	names := make([]string, 0, len(f.pids))
+	for name := range f.pids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s %d\n", name, f.pids[name])
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// remove deletes the file once psmgmt stops.
+func (f *pidFile) remove() {
+	if f != nil {
+		os.Remove(f.path)
+	}
+}
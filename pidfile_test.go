@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "psmgmt.pids")
+	pids := newPidFile(path)
+	web := &Command{Name: "web"}
+	db := &Command{Name: "db"}
+
+	assert.NoError(t, pids.update(Message{Type: OutputRunning, Command: web, Pid: 42}))
+	assert.NoError(t, pids.update(Message{Type: OutputRunning, Command: db, Pid: 7}))
+	content, _ := os.ReadFile(path)
+	assert.Equal(t, "db 7\nweb 42\n", string(content))
+
+	// A restarted process replaces the old PID
+	assert.NoError(t, pids.update(Message{Type: OutputRunning, Command: web, Pid: 43}))
+	content, _ = os.ReadFile(path)
+	assert.Equal(t, "db 7\nweb 43\n", string(content))
+
+	assert.NoError(t, pids.update(Message{Type: OutputEnd, Command: db}))
+	content, _ = os.ReadFile(path)
+	assert.Equal(t, "web 43\n", string(content))
+
+	pids.remove()
+	assert.NoFileExists(t, path)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// knownGOOS and knownGOARCH are the values of runtime.GOOS and runtime.GOARCH
+// that overrides may be keyed by.
+var (
+	knownGOOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"illumos": true, "ios": true, "js": true, "linux": true, "netbsd": true,
+		"openbsd": true, "plan9": true, "solaris": true, "wasip1": true, "windows": true,
+	}
+	knownGOARCH = map[string]bool{
+		"386": true, "amd64": true, "arm": true, "arm64": true, "loong64": true,
+		"mips": true, "mips64": true, "mips64le": true, "mipsle": true, "ppc64": true,
+		"ppc64le": true, "riscv64": true, "s390x": true, "wasm": true,
+	}
+)
+
+// Override replaces or extends the command line of a command on some platforms.
+type Override struct {
+	// Command replaces the command.
+	Command string `yaml:"command"`
+	// Args replaces the arguments.
+	Args []string `yaml:"args"`
+	// ExtraArgs are appended to the arguments.
+	ExtraArgs []string `yaml:"extra_args"`
+}
+
+// validateOverrides checks that the overrides are keyed by a known GOOS,
+// optionally followed by a known GOARCH, like "linux" or "darwin/arm64".
+func validateOverrides(overrides map[string]Override) error {
+	for key := range overrides {
+		goos, goarch, hasArch := strings.Cut(key, "/")
+		if !knownGOOS[goos] {
+			return fmt.Errorf("overrides: unknown GOOS %q", goos)
+		}
+		if hasArch && !knownGOARCH[goarch] {
+			return fmt.Errorf("overrides: unknown GOARCH %q", goarch)
+		}
+	}
+	return nil
+}
+
+// applyOverrides returns the command with the overrides matching goos and goarch
+// applied, first the one keyed by goos alone, then the one keyed by goos/goarch.
+func applyOverrides(command Command, goos string, goarch string) Command {
+	keys := make([]string, 0, 2)
+	for key := range command.Overrides {
+		if key == goos || key == goos+"/"+goarch {
+			keys = append(keys, key)
+		}
+	}
+	// The shorter key is the less specific one
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) < len(keys[j]) })
+
+	for _, key := range keys {
+		override := command.Overrides[key]
+		if override.Command != "" {
+			command.Command = override.Command
+		}
+		if override.Args != nil {
+			command.Args = override.Args
+		}
+		command.Args = append(append([]string(nil), command.Args...), override.ExtraArgs...)
+	}
+	return command
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateOverrides(t *testing.T) {
+	assert.NoError(t, validateOverrides(map[string]Override{"linux": {}, "darwin/arm64": {}}))
+	assert.EqualError(t, validateOverrides(map[string]Override{"macos": {}}), `overrides: unknown GOOS "macos"`)
+	assert.EqualError(t, validateOverrides(map[string]Override{"linux/x64": {}}), `overrides: unknown GOARCH "x64"`)
+}
+
+func TestApplyOverrides(t *testing.T) {
+	command := Command{
+		Name:    "sed",
+		Command: "sed",
+		Args:    []string{"-i", "s/a/b/", "file"},
+		Overrides: map[string]Override{
+			"darwin":       {Command: "gsed"},
+			"darwin/arm64": {ExtraArgs: []string{"--debug"}},
+			"linux":        {Args: []string{"-i", "s/x/y/", "file"}},
+		},
+	}
+
+	assert.Equal(t, "gsed", applyOverrides(command, "darwin", "amd64").Command)
+	darwin := applyOverrides(command, "darwin", "arm64")
+	assert.Equal(t, "gsed", darwin.Command)
+	assert.Equal(t, []string{"-i", "s/a/b/", "file", "--debug"}, darwin.Args)
+	assert.Equal(t, []string{"-i", "s/x/y/", "file"}, applyOverrides(command, "linux", "amd64").Args)
+
+	// Non-matching overrides are ignored and the base is left alone
+	windows := applyOverrides(command, "windows", "amd64")
+	assert.Equal(t, "sed", windows.Command)
+	assert.Equal(t, []string{"-i", "s/a/b/", "file"}, windows.Args)
+	assert.Equal(t, []string{"-i", "s/a/b/", "file"}, command.Args)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"unicode/utf8"
+)
+
+// defaultPrefix is the prefix of lines of commands that don't set their own.
+const defaultPrefix = "[{{.Name}}::{{.Type}}]:"
+
+// prefixes is the prefixer of the output of the apps running, which psmgmt's
+// own lines are prefixed by too. Reloading the config swaps it.
+var prefixes atomic.Pointer[prefixer]
+
+// prefixData is what prefix templates are rendered with.
+type prefixData struct {
+	// Name is the name of the command.
+	Name string
+	// Type is the name of the message type, e.g. "OutputStdout".
+	Type string
+}
+
+// parsePrefix parses a prefix template, like "{{.Name}} |".
+func parsePrefix(text string) (*template.Template, error) {
+	return template.New("prefix").Option("missingkey=error").Parse(text)
+}
+
+// commandColors are the ANSI colors the prefixes of the apps' output lines
+// cycle through, leaving out the red and green of status lines.
+var commandColors = []string{
+	"\x1b[36m", // cyan
+	"\x1b[33m", // yellow
+	"\x1b[35m", // magenta
+	"\x1b[34m", // blue
+	"\x1b[96m", // bright cyan
+	"\x1b[93m", // bright yellow
+	"\x1b[95m", // bright magenta
+	"\x1b[94m", // bright blue
+}
+
+// commandColor returns the color of the app at the index in the config.
+func commandColor(index int) string {
+	return commandColors[index%len(commandColors)]
+}
+
+// prefixer renders the prefix of every printed line from the command's template.
+type prefixer struct {
+	fallback  *template.Template
+	templates map[string]*template.Template
+	// colors holds the color of every app when output is colored.
+	colors map[string]string
+	// width is the width names are padded to in aligned prefixes, which are
+	// only used if it is set.
+	width int
+}
+
+// newPrefixer parses the prefix templates of the apps. If color is set, the
+// prefixes of output lines are colored by app.
+func newPrefixer(apps []Command, color bool) (*prefixer, error) {
+	fallback, err := parsePrefix(defaultPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &prefixer{fallback: fallback, templates: make(map[string]*template.Template)}
+	if color {
+		p.colors = make(map[string]string, len(apps))
+		for i, command := range apps {
+			p.colors[command.Name] = commandColor(i)
+		}
+	}
+	for _, command := range apps {
+		if command.Prefix == "" {
+			continue
+		}
+		tmpl, err := parsePrefix(command.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		p.templates[command.Name] = tmpl
+	}
+	return p, nil
+}
+
+// format returns the prefix of the message, falling back to the default prefix
+// if the command's template fails to render.
+func (p *prefixer) format(message Message) string {
+	if p.width > 0 {
+		return p.colorize(message, p.column(message))
+	}
+	return p.colorize(message, p.render(message))
+}
+
+// align replaces the prefix templates with the name of the command padded to
+// the longest name of the apps, their hooks and "system", followed by " |", so
+// that the lines of all apps line up.
+func (p *prefixer) align(apps []Command) {
+	p.width = utf8.RuneCountInString(systemName)
+	for _, command := range apps {
+		width := utf8.RuneCountInString(command.Name)
+		if len(command.OnRestart) > 0 {
+			width = max(width, utf8.RuneCountInString(command.Name+":on_restart"))
+		}
+		if len(command.OnCrash) > 0 {
+			width = max(width, utf8.RuneCountInString(command.Name+":on_crash"))
+		}
+		p.width = max(p.width, width)
+	}
+}
+
+// column returns the aligned prefix of the message. Messages other than output
+// lines name their type, like "web    | [OutputEnd]".
+func (p *prefixer) column(message Message) string {
+	prefix := fmt.Sprintf("%-*s |", p.width, message.CommandName())
+	if message.Type != OutputStdout && message.Type != OutputStderr {
+		prefix += " [" + message.Type.Name() + "]"
+	}
+	return prefix
+}
+
+// system returns the prefix of psmgmt's own lines of the kind, the name of a
+// message type or "Throughput", the way format does for messages of the system.
+// A nil prefixer, before the output is prepared, returns the default prefix.
+func (p *prefixer) system(kind string) string {
+	if p == nil {
+		return "[" + systemName + "::" + kind + "]:"
+	}
+	if p.width > 0 {
+		return fmt.Sprintf("%-*s | [%s]", p.width, systemName, kind)
+	}
+	var b strings.Builder
+	p.fallback.Execute(&b, prefixData{Name: systemName, Type: kind})
+	return b.String()
+}
+
+// logSystem logs a line of psmgmt itself, of the kind, with the system prefix of
+// the current prefixes, see prefixer.system.
+func logSystem(kind string, format string, args ...any) {
+	log.Print(prefixes.Load().system(kind) + " " + fmt.Sprintf(format, args...))
+}
+
+// systemLogger returns a logf logging lines of the kind with logSystem.
+func systemLogger(kind string) func(format string, args ...any) {
+	return func(format string, args ...any) {
+		logSystem(kind, format, args...)
+	}
+}
+
+// render renders the prefix template of the message's command.
+func (p *prefixer) render(message Message) string {
+	data := prefixData{Name: message.CommandName(), Type: message.Type.Name()}
+
+	var b strings.Builder
+	if tmpl, ok := p.templates[data.Name]; ok && message.Command != nil {
+		if err := tmpl.Execute(&b, data); err == nil {
+			return b.String()
+		}
+		b.Reset()
+	}
+	p.fallback.Execute(&b, data)
+	return b.String()
+}
+
+// colorize colors the prefix of an output line in the color of its command.
+func (p *prefixer) colorize(message Message, prefix string) string {
+	if message.Type != OutputStdout && message.Type != OutputStderr || message.Command == nil {
+		return prefix
+	}
+	color, ok := p.colors[message.Command.Name]
+	if !ok {
+		return prefix
+	}
+	return color + prefix + ansiReset
+}
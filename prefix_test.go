@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixer(t *testing.T) {
+	p, err := newPrefixer([]Command{
+		{Name: "web", Prefix: "{{.Name}} |"},
+		{Name: "worker"},
+	}, false)
+	assert.NoError(t, err)
+
+	web := &Command{Name: "web"}
+	worker := &Command{Name: "worker"}
+	assert.Equal(t, "web |", p.format(Message{Type: OutputStdout, Command: web}))
+	assert.Equal(t, "[worker::OutputStderr]:", p.format(Message{Type: OutputStderr, Command: worker}))
+	assert.Equal(t, "[system::SystemError]:", p.format(Message{Type: SystemError}))
+
+	_, err = newPrefixer([]Command{{Name: "web", Prefix: "{{.Name"}}, false)
+	assert.Error(t, err)
+}
+
+func TestCommandColor(t *testing.T) {
+	assert.Equal(t, "\x1b[36m", commandColor(0))
+	assert.Equal(t, "\x1b[33m", commandColor(1))
+	assert.Equal(t, commandColor(2), commandColor(2+len(commandColors)))
+	assert.NotEqual(t, commandColor(0), commandColor(1))
+}
+
+func TestPrefixerColor(t *testing.T) {
+	p, err := newPrefixer([]Command{{Name: "web"}, {Name: "worker", Prefix: "{{.Name}} |"}}, true)
+	assert.NoError(t, err)
+
+	web := &Command{Name: "web"}
+	worker := &Command{Name: "worker"}
+	assert.Equal(t, "\x1b[36m[web::OutputStdout]:\x1b[0m", p.format(Message{Type: OutputStdout, Command: web}))
+	assert.Equal(t, "\x1b[33mworker |\x1b[0m", p.format(Message{Type: OutputStderr, Command: worker}))
+	// Lifecycle messages keep their plain prefix
+	assert.Equal(t, "[web::OutputEnd]:", p.format(Message{Type: OutputEnd, Command: web}))
+	assert.Equal(t, "[system::SystemError]:", p.format(Message{Type: SystemError}))
+}
+
+func TestPrefixerAlign(t *testing.T) {
+	apps := []Command{{Name: "web", Prefix: "{{.Name}} >"}, {Name: "worker-eu"}}
+	p, err := newPrefixer(apps, false)
+	assert.NoError(t, err)
+	p.align(apps)
+
+	web := &Command{Name: "web"}
+	worker := &Command{Name: "worker-eu"}
+	assert.Equal(t, "web       |", p.format(Message{Type: OutputStdout, Command: web}))
+	assert.Equal(t, "worker-eu |", p.format(Message{Type: OutputStderr, Command: worker}))
+	assert.Equal(t, "web       | [OutputEnd]", p.format(Message{Type: OutputEnd, Command: web}))
+	assert.Equal(t, "system    | [SystemError]", p.format(Message{Type: SystemError}))
+
+	// Names are padded to at least the width of "system"
+	p.align([]Command{{Name: "db"}})
+	assert.Equal(t, "db     |", p.format(Message{Type: OutputStdout, Command: &Command{Name: "db"}}))
+
+	// and to the names of the hooks
+	p.align([]Command{{Name: "web", OnRestart: []string{"true"}}})
+	assert.Equal(t, "web:on_restart |", p.format(Message{Type: OutputStdout, Command: &Command{Name: "web:on_restart"}}))
+	assert.Equal(t, "web            |", p.format(Message{Type: OutputStdout, Command: web}))
+
+	colored, err := newPrefixer(apps, true)
+	assert.NoError(t, err)
+	colored.align(apps)
+	assert.Equal(t, "\x1b[36mweb       |\x1b[0m", colored.format(Message{Type: OutputStdout, Command: web}))
+}
+
+func TestPrefixerSystem(t *testing.T) {
+	var unprepared *prefixer
+	assert.Equal(t, "[system::SystemError]:", unprepared.system("SystemError"))
+
+	apps := []Command{{Name: "web", Prefix: "{{.Name}} >"}, {Name: "worker-eu"}}
+	p, err := newPrefixer(apps, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "[system::Throughput]:", p.system("Throughput"))
+
+	p.align(apps)
+	assert.Equal(t, "system    | [OutputStopped]", p.system("OutputStopped"))
+	assert.Equal(t, p.format(Message{Type: SystemError}), p.system("SystemError"))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os/exec"
+	"time"
+)
+
+// Defaults of the readiness probe settings.
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = time.Second
+	defaultProbeDeadline = time.Minute
+)
+
+// ReadinessProbe checks that a command is available, like a database accepting
+// connections, before the apps depending on it start.
+type ReadinessProbe struct {
+	// TCP is an address, like "localhost:5432", that is ready once it accepts
+	// connections.
+	TCP string `yaml:"tcp"`
+	// Exec is a command, with its arguments, that is ready once it exits with 0.
+	Exec []string `yaml:"exec"`
+	// Interval is the time between two checks. It defaults to 1s.
+	Interval time.Duration `yaml:"interval"`
+	// MaxInterval, if set, makes the interval double after every failed check
+	// up to it, with jitter, so that checks start frequent and back off.
+	MaxInterval time.Duration `yaml:"max_interval"`
+	// Timeout is the time a single check may take. It defaults to 1s.
+	Timeout time.Duration `yaml:"timeout"`
+	// Deadline is the time after the process started that the probe must
+	// succeed within before it is reported. It defaults to 1m.
+	Deadline time.Duration `yaml:"deadline"`
+}
+
+// validateReadinessProbe checks the command's readiness_probe, if any.
+func validateReadinessProbe(command Command) error {
+	probe := command.ReadinessProbe
+	if probe == nil {
+		return nil
+	}
+	if (probe.TCP == "") == (len(probe.Exec) == 0) {
+		return errors.New("readiness_probe needs exactly one of tcp or exec")
+	}
+	if probe.TCP != "" {
+		if _, _, err := net.SplitHostPort(probe.TCP); err != nil {
+			return fmt.Errorf("invalid readiness_probe tcp: %w", err)
+		}
+	}
+	if probe.Interval < 0 || probe.MaxInterval < 0 || probe.Timeout < 0 || probe.Deadline < 0 {
+		return errors.New("readiness_probe durations must not be negative")
+	}
+	if probe.MaxInterval > 0 && probe.MaxInterval < orDefault(probe.Interval, defaultProbeInterval) {
+		return errors.New("readiness_probe max_interval must not be less than interval")
+	}
+	if command.ReadyWhen != "" {
+		return errors.New("ready_when and readiness_probe cannot be combined")
+	}
+	return nil
+}
+
+// orDefault returns the duration, or fallback if it isn't set.
+func orDefault(duration time.Duration, fallback time.Duration) time.Duration {
+	if duration > 0 {
+		return duration
+	}
+	return fallback
+}
+
+// probeBackoff computes the time between the checks of a readiness_probe. With
+// a max_interval it doubles from the interval up to it after every failed
+// check, less a random part of up to half of it, so that apps started together
+// don't check in lockstep. Without one it stays at the interval.
+type probeBackoff struct {
+	next time.Duration
+	max  time.Duration
+	rand *rand.Rand
+}
+
+// newProbeBackoff returns the probeBackoff of the probe, drawing the jitter
+// from source.
+func newProbeBackoff(probe *ReadinessProbe, source rand.Source) *probeBackoff {
+	return &probeBackoff{next: orDefault(probe.Interval, defaultProbeInterval), max: probe.MaxInterval, rand: rand.New(source)}
+}
+
+// delay returns the time to wait before the next check.
+func (b *probeBackoff) delay() time.Duration {
+	if b.max <= 0 {
+		return b.next
+	}
+	delay := b.next
+	if b.next > b.max/2 {
+		b.next = b.max
+	} else {
+		b.next *= 2
+	}
+	return delay - time.Duration(b.rand.Int63n(int64(delay/2)+1))
+}
+
+// probeOnce runs a single check of the command's readiness_probe.
+func probeOnce(ctx context.Context, command Command) error {
+	probe := command.ReadinessProbe
+	ctx, cancel := context.WithTimeout(ctx, orDefault(probe.Timeout, defaultProbeTimeout))
+	defer cancel()
+
+	if probe.TCP != "" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", probe.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	name := probe.Exec[0]
+	if len(command.Path) > 0 {
+		var err error
+		name, err = lookPath(name, command.Path)
+		if err != nil {
+			return err
+		}
+	}
+	cmd := exec.CommandContext(ctx, name, probe.Exec[1:]...)
+	cmd.Env = commandEnv(command)
+	cmd.Dir = localWorkingDir(command)
+	return cmd.Run()
+}
+
+// probeReadiness checks the command's readiness_probe every interval, see
+// probeBackoff, until it succeeds, then marks the gate as ready. It gives up once exited is closed or
+// ctx is done. It reports a SystemError if the probe didn't succeed within its
+// deadline, and keeps checking, so that apps depending on a slow command still
+// start eventually.
+func (r *Runner) probeReadiness(ctx context.Context, outputChan chan<- Message, command Command, gate *readyGate, exited <-chan struct{}) {
+	probe := command.ReadinessProbe
+	// The jitter is seeded by the clock, so that a FakeClock makes it repeatable
+	backoff := newProbeBackoff(probe, rand.NewSource(r.Clock.Now().UnixNano()))
+	deadline := r.Clock.After(orDefault(probe.Deadline, defaultProbeDeadline))
+	for {
+		err := probeOnce(ctx, command)
+		if err == nil {
+			gate.markReady(r.Clock, outputChan, &command)
+			return
+		}
+
+		next := r.Clock.After(backoff.delay())
+	wait:
+		for {
+			select {
+			case <-exited:
+				return
+			case <-ctx.Done():
+				return
+			case <-deadline:
+				deadline = nil
+				send(r.Clock, outputChan, Message{
+					Content: fmt.Sprintf("not ready within readiness_probe deadline of %s: %v", orDefault(probe.Deadline, defaultProbeDeadline), err),
+					Type:    SystemError,
+					Command: &command,
+					Failed:  true,
+				})
+			case <-next:
+				break wait
+			}
+		}
+	}
+}
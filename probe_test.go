@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateReadinessProbe(t *testing.T) {
+	assert.NoError(t, validateReadinessProbe(Command{}))
+	assert.NoError(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{TCP: "localhost:5432"}}))
+	assert.NoError(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{Exec: []string{"pg_isready"}}}))
+
+	assert.EqualError(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{}}),
+		"readiness_probe needs exactly one of tcp or exec")
+	assert.EqualError(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{TCP: "localhost:5432", Exec: []string{"pg_isready"}}}),
+		"readiness_probe needs exactly one of tcp or exec")
+	assert.ErrorContains(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{TCP: "localhost"}}),
+		"invalid readiness_probe tcp")
+	assert.EqualError(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{TCP: "localhost:5432", Interval: -time.Second}}),
+		"readiness_probe durations must not be negative")
+	assert.EqualError(t, validateReadinessProbe(Command{ReadinessProbe: &ReadinessProbe{TCP: "localhost:5432", MaxInterval: 500 * time.Millisecond}}),
+		"readiness_probe max_interval must not be less than interval")
+	assert.EqualError(t, validateReadinessProbe(Command{ReadyWhen: "listening", ReadinessProbe: &ReadinessProbe{TCP: "localhost:5432"}}),
+		"ready_when and readiness_probe cannot be combined")
+}
+
+func TestExecuteReadinessProbe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	ready := filepath.Join(t.TempDir(), "ready")
+
+	for _, test := range []struct {
+		name   string
+		script string
+		probe  ReadinessProbe
+		errors []string
+	}{
+		{"tcp", "sleep 5", ReadinessProbe{TCP: listener.Addr().String()}, nil},
+		{"exec", "sleep 0.2; touch " + ready + "; sleep 5", ReadinessProbe{Exec: []string{"test", "-f", ready}, Interval: 50 * time.Millisecond}, nil},
+		{"deadline", "sleep 0.3; touch " + ready + "2; sleep 5", ReadinessProbe{Exec: []string{"test", "-f", ready + "2"}, Interval: 50 * time.Millisecond, Deadline: 100 * time.Millisecond},
+			[]string{"not ready within readiness_probe deadline of 100ms: exit status 1"}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		outputChan := make(chan Message, 2)
+		Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+			Name:           test.name,
+			Command:        "sh",
+			Args:           []string{"-c", test.script},
+			ReadinessProbe: &test.probe,
+		})
+
+		isReady := false
+		var errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputReady:
+				isReady = true
+				cancel()
+			case SystemError:
+				errors = append(errors, message.Content)
+			}
+		})
+		cancel()
+
+		assert.True(t, isReady, test.name)
+		assert.Equal(t, test.errors, errors, test.name)
+	}
+}
+
+func TestProbeBackoff(t *testing.T) {
+	fixed := newProbeBackoff(&ReadinessProbe{Interval: time.Second}, rand.NewSource(1))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Second, fixed.delay())
+	}
+
+	// Every delay doubles up to max_interval, less up to half of it
+	backoff := newProbeBackoff(&ReadinessProbe{Interval: 100 * time.Millisecond, MaxInterval: time.Second}, rand.NewSource(1))
+	for _, base := range []time.Duration{100, 200, 400, 800, 1000, 1000, 1000} {
+		base *= time.Millisecond
+		delay := backoff.delay()
+		assert.LessOrEqual(t, delay, base)
+		assert.GreaterOrEqual(t, delay, base/2)
+	}
+}
+
+func TestExecuteReadinessProbeBackoff(t *testing.T) {
+	dir := t.TempDir()
+	checks, ready := filepath.Join(dir, "checks"), filepath.Join(dir, "ready")
+	probe := ReadinessProbe{
+		Exec:        []string{"sh", "-c", "echo >> " + checks + "; test -f " + ready},
+		Interval:    time.Second,
+		MaxInterval: 4 * time.Second,
+		Deadline:    time.Hour,
+	}
+	now := time.Now()
+	clock := NewFakeClock(now)
+	runner := NewRunner()
+	runner.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outputChan := make(chan Message, 2)
+	runner.Execute(ctx, new(sync.WaitGroup), outputChan, Command{
+		Name:           "db",
+		Command:        "sleep",
+		Args:           []string{"5"},
+		ReadinessProbe: &probe,
+	})
+
+	// The probe waits for the deadline and the next check
+	countChecks := func() int {
+		content, _ := os.ReadFile(checks)
+		return strings.Count(string(content), "\n")
+	}
+	waiting := func(count int) bool { return countChecks() == count && clock.Waiters() == 2 }
+	go func() {
+		backoff := newProbeBackoff(&probe, rand.NewSource(now.UnixNano()))
+		for count := 1; count <= 4; count++ {
+			if !assert.Eventually(t, func() bool { return waiting(count) }, 2*time.Second, time.Millisecond) {
+				cancel()
+				return
+			}
+			if count == 4 {
+				assert.NoError(t, os.WriteFile(ready, nil, 0o644))
+			}
+			// The checks back off, taking the jitter drawn from the clock
+			delay := backoff.delay()
+			clock.Advance(delay - time.Nanosecond)
+			time.Sleep(20 * time.Millisecond)
+			assert.Equal(t, count, countChecks())
+			clock.Advance(time.Nanosecond)
+		}
+	}()
+
+	isReady := false
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputReady {
+			isReady = true
+			cancel()
+		}
+	})
+	assert.True(t, isReady)
+	assert.Equal(t, 5, countChecks())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sync"
+)
+
+// startProfiling profiles psmgmt itself: it serves the net/http/pprof endpoints
+// on pprofAddr and records a CPU profile to cpuFile, and the returned function
+// writes a heap profile to memFile. Empty settings are skipped, so that nothing
+// runs unless asked for. The returned function stops profiling and may be called
+// more than once.
+func startProfiling(pprofAddr string, cpuFile string, memFile string) (func(), error) {
+	if pprofAddr != "" {
+		listener, err := net.Listen("tcp", pprofAddr)
+		if err != nil {
+			return nil, fmt.Errorf("error starting pprof server: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			if err := http.Serve(listener, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+				logSystem("SystemError", "error serving pprof: %v", err)
+			}
+		}()
+	}
+
+	var cpu *os.File
+	if cpuFile != "" {
+		var err error
+		cpu, err = os.Create(cpuFile)
+		if err != nil {
+			return nil, fmt.Errorf("error creating CPU profile: %w", err)
+		}
+		if err := runtimepprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, fmt.Errorf("error starting CPU profile: %w", err)
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if cpu != nil {
+				runtimepprof.StopCPUProfile()
+				cpu.Close()
+			}
+			if memFile != "" {
+				if err := writeHeapProfile(memFile); err != nil {
+					logSystem("SystemError", "%v", err)
+				}
+			}
+		})
+	}, nil
+}
+
+// writeHeapProfile writes a profile of the memory in use to path.
+func writeHeapProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating memory profile: %w", err)
+	}
+	defer file.Close()
+
+	// Collect garbage first so that the profile only shows live memory
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(file); err != nil {
+		return fmt.Errorf("error writing memory profile: %w", err)
+	}
+	return nil
+}
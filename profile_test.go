@@ -0,0 +1,31 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartProfiling(t *testing.T) {
+	dir := t.TempDir()
+	cpuFile := filepath.Join(dir, "cpu.pprof")
+	memFile := filepath.Join(dir, "mem.pprof")
+
+	stop, err := startProfiling("127.0.0.1:0", cpuFile, memFile)
+	assert.NoError(t, err)
+	stop()
+	stop()
+
+	for _, path := range []string{cpuFile, memFile} {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.NotZero(t, info.Size())
+	}
+
+	// Nothing is profiled by default
+	stop, err = startProfiling("", "", "")
+	assert.NoError(t, err)
+	stop()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// readyGate watches the output of a command for its ready_when pattern, or
+// waits for its readiness_probe, and emits OutputReady the first time a line
+// matches it or the probe succeeds.
+type readyGate struct {
+	pattern *regexp.Regexp
+	ready   atomic.Bool
+}
+
+// newReadyGate returns a gate for the command's ready_when pattern or
+// readiness_probe, or nil if the command has neither.
+func newReadyGate(command Command) (*readyGate, error) {
+	if command.ReadinessProbe != nil {
+		return &readyGate{}, nil
+	}
+	if command.ReadyWhen == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(command.ReadyWhen)
+	if err != nil {
+		return nil, err
+	}
+	return &readyGate{pattern: pattern}, nil
+}
+
+// check sends an OutputReady message if line is the first one to match the pattern.
+// It is safe to call on a nil gate, which never matches, and on the gate of a
+// readiness_probe, which ignores the output.
+func (g *readyGate) check(line string, clock Clock, outputChan chan<- Message, command *Command) {
+	if g == nil || g.pattern == nil || g.ready.Load() || !g.pattern.MatchString(line) {
+		return
+	}
+	g.markReady(clock, outputChan, command)
+}
+
+// markReady sends an OutputReady message unless the gate is ready already.
+func (g *readyGate) markReady(clock Clock, outputChan chan<- Message, command *Command) {
+	if g.ready.CompareAndSwap(false, true) {
+		send(clock, outputChan, Message{
+			Type:    OutputReady,
+			Command: command,
+		})
+	}
+}
+
+// isReady reports whether the pattern has matched. A nil gate is never ready.
+func (g *readyGate) isReady() bool {
+	return g != nil && g.ready.Load()
+}
+
+// readyMessage returns the message type that marks the command as ready: matching
+// ready_when or passing readiness_probe if it has one, being started for builtins,
+// and running otherwise.
+func readyMessage(command Command) MessageType {
+	switch {
+	case command.ReadyWhen != "" || command.ReadinessProbe != nil:
+		return OutputReady
+	case command.Builtin != "":
+		return OutputStart
+	default:
+		return OutputRunning
+	}
+}
+
+// allReady follows the apps until every one of them became ready once.
+type allReady struct {
+	mu      sync.Mutex
+	pending map[string]int
+	done    bool
+}
+
+// newAllReady returns an allReady waiting for the apps.
+func newAllReady(apps []Command) *allReady {
+	pending := make(map[string]int, len(apps))
+	for _, command := range apps {
+		pending[command.Name]++
+	}
+	return &allReady{pending: pending}
+}
+
+// observe records the message and reports whether it made the last app ready.
+// It reports true at most once, as later restarts don't make psmgmt unready.
+func (a *allReady) observe(message Message) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.done || message.Command == nil || message.Type != readyMessage(*message.Command) {
+		return false
+	}
+	name := message.Command.Name
+	if a.pending[name] == 0 {
+		return false
+	}
+	a.pending[name]--
+	if a.pending[name] == 0 {
+		delete(a.pending, name)
+	}
+	a.done = len(a.pending) == 0
+	return a.done
+}
+
+// notReady returns the sorted names of the apps that didn't become ready yet.
+func (a *allReady) notReady() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	names := make([]string, 0, len(a.pending))
+	for name := range a.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllReady(t *testing.T) {
+	web := Command{Name: "web", Command: "web", ReadyWhen: "listening"}
+	worker := Command{Name: "worker", Command: "worker"}
+	idle := Command{Name: "idle", Builtin: builtinKeepalive}
+	ready := newAllReady([]Command{web, worker, idle})
+
+	assert.False(t, ready.observe(Message{Type: OutputStart, Command: &idle}))
+	assert.False(t, ready.observe(Message{Type: OutputRunning, Command: &web}))
+	assert.False(t, ready.observe(Message{Type: OutputRunning, Command: &worker}))
+	assert.False(t, ready.observe(Message{Type: OutputStart, Command: &web}))
+	assert.Equal(t, []string{"web"}, ready.notReady())
+	assert.True(t, ready.observe(Message{Type: OutputReady, Command: &web}))
+
+	// Readiness is only reported once
+	assert.False(t, ready.observe(Message{Type: OutputReady, Command: &web}))
+	assert.Empty(t, ready.notReady())
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// reloadAttempts is the number of times a config reload is attempted before giving up.
+	reloadAttempts = 3
+	// reloadRetryDelay is the pause between two reload attempts.
+	reloadRetryDelay = 200 * time.Millisecond
+)
+
+// reloadConfig loads the configuration for a reload, retrying up to attempts times
+// with the given delay, measured by clock, in between. Editors often truncate the file before writing
+// it, so a first failure is frequently transient. Every failed attempt is reported
+// through logf. The error of the last attempt is returned if they all fail, in
+// which case the caller keeps running the old configuration.
+func reloadConfig(clock Clock, load func() (*Config, error), attempts int, delay time.Duration, logf func(format string, args ...any)) (*Config, error) {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var config *Config
+		config, err = load()
+		if err == nil {
+			return config, nil
+		}
+
+		logf("reload attempt %d/%d failed: %v", attempt, attempts, err)
+		if attempt < attempts {
+			clock.Sleep(delay)
+		}
+	}
+	return nil, err
+}
+
+// sameApp reports whether two commands are versions of the same app.
+// Apps are identified by name, ignoring case.
+func sameApp(a, b Command) bool {
+	return strings.EqualFold(a.Name, b.Name)
+}
+
+// sameExecution reports whether two versions of an app run the same way,
+// in which case a reload leaves the running process alone.
+func sameExecution(a, b Command) bool {
+	return reflect.DeepEqual(executionKey(a), executionKey(b))
+}
+
+// executionKey returns a copy of the command that only keeps the fields affecting
+// how it is executed. Fields that a reload applies to the running process, or
+// that only identify it, are cleared, so that changing them doesn't restart the
+// process. The others, including the ones the process is watched by like
+// ready_when, are only read when it starts. Empty lists are normalized to nil
+// and the order of namespaces is ignored.
+func executionKey(command Command) Command {
+	command.Name = ""
+	command.ReloadSignal = ""
+	command.Prefix = ""
+	command.Replace = nil
+	command.Tags = nil
+	// The overrides for this platform are already applied to the command line,
+	// the variables of the env file to the env
+	command.Overrides = nil
+	command.EnvFile = ""
+
+	if len(command.Args) == 0 {
+		command.Args = nil
+	}
+	if len(command.PipeThrough) == 0 {
+		command.PipeThrough = nil
+	}
+	if len(command.Namespaces) == 0 {
+		command.Namespaces = nil
+	} else {
+		command.Namespaces = append([]string(nil), command.Namespaces...)
+		sort.Strings(command.Namespaces)
+	}
+	return command
+}
+
+// appChange pairs the running version of an app with its reloaded one.
+type appChange struct {
+	current, reloaded Command
+}
+
+// reloadPlan is what reloading the config does to the running apps.
+type reloadPlan struct {
+	// start are the apps added to the config.
+	start []Command
+	// stop are the apps removed from the config.
+	stop []Command
+	// restart are the apps whose execution changed.
+	restart []appChange
+	// keep are the apps left running, which receive their reload_signal.
+	keep []appChange
+}
+
+// planReload compares the running apps to the reloaded ones, in the order of
+// the reloaded config.
+func planReload(current, reloaded []Command) reloadPlan {
+	var plan reloadPlan
+	for _, command := range reloaded {
+		previous, ok := findApp(current, command)
+		switch {
+		case !ok:
+			plan.start = append(plan.start, command)
+		case sameExecution(previous, command):
+			plan.keep = append(plan.keep, appChange{current: previous, reloaded: command})
+		default:
+			plan.restart = append(plan.restart, appChange{current: previous, reloaded: command})
+		}
+	}
+	for _, command := range current {
+		if _, ok := findApp(reloaded, command); !ok {
+			plan.stop = append(plan.stop, command)
+		}
+	}
+	return plan
+}
+
+// findApp returns the version of the command's app among apps.
+func findApp(apps []Command, command Command) (Command, bool) {
+	for _, app := range apps {
+		if sameApp(app, command) {
+			return app, true
+		}
+	}
+	return Command{}, false
+}
+
+// reloader applies reloaded configs to the running apps. Apps whose execution
+// changed are stopped, and their new version started once the old one ended.
+type reloader struct {
+	lifecycles *lifecycles
+	// launch starts an app, again if it ran before, see lifecycles.start.
+	launch func(command Command, again bool) error
+	// launchable reports why an app can't be started at runtime, if it can't.
+	launchable func(command Command) error
+	// signal sends the reload_signal of the command to its process.
+	signal func(command Command) error
+	// updates are called with the apps after every reload, see notify.
+	updates []func(apps []Command)
+
+	mu   sync.Mutex
+	apps []Command
+	// ended holds the apps whose OutputEnd arrived.
+	ended map[string]bool
+	// replacing holds the new versions of the apps to start once they ended.
+	replacing map[string]Command
+}
+
+// newReloader returns the reloader of the running apps.
+func newReloader(apps []Command, lifecycles *lifecycles, launch func(command Command, again bool) error, launchable func(command Command) error, signal func(command Command) error) *reloader {
+	return &reloader{
+		lifecycles: lifecycles,
+		launch:     launch,
+		launchable: launchable,
+		signal:     signal,
+		apps:       apps,
+		ended:      make(map[string]bool),
+		replacing:  make(map[string]Command),
+	}
+}
+
+// lookup returns the current version of the named app.
+func (r *reloader) lookup(name string) (Command, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return findApp(r.apps, Command{Name: name})
+}
+
+// notify has update called with the apps after every reload, for the parts of
+// psmgmt that know the apps, like the control API. It must be called before
+// the first reload.
+func (r *reloader) notify(update func(apps []Command)) {
+	r.updates = append(r.updates, update)
+}
+
+// reload applies the reloaded apps, returning what it did to them. Apps that
+// fail to be started keep running in their current version, if any.
+func (r *reloader) reload(reloaded []Command) []string {
+	actions, apps := r.apply(reloaded)
+	// Outside of the lock, as the updated parts may look apps up meanwhile
+	for _, update := range r.updates {
+		update(apps)
+	}
+	return actions
+}
+
+// apply applies the reloaded apps, returning what it did to them and the apps
+// that run now.
+func (r *reloader) apply(reloaded []Command) ([]string, []Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	plan := planReload(r.apps, reloaded)
+	var actions []string
+	var apps []Command
+
+	for _, command := range plan.stop {
+		delete(r.replacing, command.Name)
+		if !r.ended[command.Name] {
+			r.lifecycles.stop(command.Name)
+		}
+		actions = append(actions, fmt.Sprintf("stopping %s, removed from the config", command.Name))
+	}
+	for _, command := range plan.start {
+		if err := r.start(command); err != nil {
+			actions = append(actions, fmt.Sprintf("not starting %s, added to the config: %v", command.Name, err))
+			continue
+		}
+		apps = append(apps, command)
+		actions = append(actions, fmt.Sprintf("starting %s, added to the config", command.Name))
+	}
+	for _, change := range plan.restart {
+		if err := r.restart(change); err != nil {
+			apps = append(apps, change.current)
+			actions = append(actions, fmt.Sprintf("not restarting %s, which changed: %v", change.current.Name, err))
+			continue
+		}
+		apps = append(apps, change.reloaded)
+		actions = append(actions, fmt.Sprintf("restarting %s, which changed", change.current.Name))
+	}
+	for _, change := range plan.keep {
+		// The process keeps running under the name it was started with
+		command := change.reloaded
+		command.Name = change.current.Name
+		apps = append(apps, command)
+		if command.ReloadSignal == "" || r.ended[command.Name] {
+			continue
+		}
+		if err := r.signal(command); err != nil {
+			actions = append(actions, fmt.Sprintf("error sending reload_signal to %s: %v", command.Name, err))
+			continue
+		}
+		actions = append(actions, fmt.Sprintf("sent %s to %s", command.ReloadSignal, command.Name))
+	}
+	r.apps = apps
+	return actions, apps
+}
+
+// start starts an app added to the config.
+func (r *reloader) start(command Command) error {
+	if err := r.launchable(command); err != nil {
+		return err
+	}
+	return r.launch(command, true)
+}
+
+// restart stops the current version of an app that changed, to start the
+// reloaded one once it ended. An app that ended already is started right away.
+func (r *reloader) restart(change appChange) error {
+	if err := r.launchable(change.reloaded); err != nil {
+		return err
+	}
+	name := change.current.Name
+	if r.ended[name] {
+		return r.launch(change.reloaded, true)
+	}
+	if _, ok := r.replacing[name]; !ok {
+		// Keep psmgmt from exiting when the app was the last one running
+		if err := r.lifecycles.expect(); err != nil {
+			return err
+		}
+		r.lifecycles.stop(name)
+	}
+	r.replacing[name] = change.reloaded
+	return nil
+}
+
+// observe starts the new versions of the apps once their current one ended.
+func (r *reloader) observe(message Message) {
+	if message.Command == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := message.Command.Name
+	switch message.Type {
+	case OutputStart:
+		delete(r.ended, name)
+	case OutputEnd:
+		r.ended[name] = true
+		if command, ok := r.replacing[name]; ok {
+			delete(r.replacing, name)
+			// The OutputEnd to come was added by restart, so this can't fail
+			_ = r.launch(command, false)
+		}
+	}
+}
+
+// signalApp sends the reload_signal of the command to its running process,
+// looked up in snapshot.
+func signalApp(snapshot []CommandStatus, command Command) error {
+	sig, err := parseSignal(command.ReloadSignal)
+	if err != nil {
+		return err
+	}
+	for _, status := range snapshot {
+		if status.Name == command.Name && status.Pid != 0 {
+			process, err := os.FindProcess(status.Pid)
+			if err != nil {
+				return err
+			}
+			return process.Signal(sig)
+		}
+	}
+	return fmt.Errorf("%s has no running process", command.Name)
+}
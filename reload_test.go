@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadConfig(t *testing.T) {
+	calls := 0
+	load := func() (*Config, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("error parsing YAML content")
+		}
+		return &Config{Version: "1"}, nil
+	}
+
+	logs := make([]string, 0)
+	logf := func(format string, args ...any) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+
+	config, err := reloadConfig(NewFakeClock(time.Now()), load, 3, 0, logf)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", config.Version)
+	assert.Equal(t, []string{
+		"reload attempt 1/3 failed: error parsing YAML content",
+		"reload attempt 2/3 failed: error parsing YAML content",
+	}, logs)
+
+	calls = -10
+	_, err = reloadConfig(NewFakeClock(time.Now()), load, 3, 0, logf)
+	assert.EqualError(t, err, "error parsing YAML content")
+}
+
+func TestSameExecution(t *testing.T) {
+	base := Command{
+		Name:       "web",
+		Command:    "./server",
+		Args:       []string{"--port", "8080"},
+		Namespaces: []string{"net", "mount"},
+	}
+
+	cosmetic := base
+	cosmetic.Name = "Web"
+	cosmetic.ReloadSignal = "SIGHUP"
+	cosmetic.Prefix = "{{.Name}} |"
+	cosmetic.Tags = []string{"frontend"}
+	cosmetic.Namespaces = []string{"mount", "net"}
+	assert.True(t, sameApp(base, cosmetic))
+	assert.True(t, sameExecution(base, cosmetic))
+
+	noArgs := Command{Name: "web", Command: "./server"}
+	emptyArgs := Command{Name: "web", Command: "./server", Args: []string{}}
+	assert.True(t, sameExecution(noArgs, emptyArgs))
+
+	changedArgs := base
+	changedArgs.Args = []string{"--port", "9090"}
+	assert.False(t, sameExecution(base, changedArgs))
+
+	// The running process is watched with the settings it started with
+	changedReady := base
+	changedReady.ReadyWhen = "Listening"
+	assert.False(t, sameExecution(base, changedReady))
+	changedLabels := base
+	changedLabels.Labels = map[string]string{"team": "web"}
+	assert.False(t, sameExecution(base, changedLabels))
+
+	changedCgroup := base
+	changedCgroup.Cgroup = &CgroupConfig{MemoryMax: "256M"}
+	assert.False(t, sameExecution(base, changedCgroup))
+
+	assert.False(t, sameApp(base, Command{Name: "worker"}))
+}
+
+func TestPlanReload(t *testing.T) {
+	web := Command{Name: "web", Command: "./server", Args: []string{"--port", "8080"}}
+	worker := Command{Name: "worker", Command: "./worker"}
+	cron := Command{Name: "cron", Command: "./cron"}
+	current := []Command{web, worker, cron}
+
+	renamed := web
+	renamed.Name, renamed.ReloadSignal = "Web", "SIGHUP"
+	changed := worker
+	changed.Env = map[string]string{"QUEUE": "jobs"}
+	db := Command{Name: "db", Command: "./db"}
+	plan := planReload(current, []Command{renamed, changed, db})
+
+	assert.Equal(t, []Command{db}, plan.start)
+	assert.Equal(t, []Command{cron}, plan.stop)
+	assert.Equal(t, []appChange{{current: worker, reloaded: changed}}, plan.restart)
+	assert.Equal(t, []appChange{{current: web, reloaded: renamed}}, plan.keep)
+
+	assert.Equal(t, reloadPlan{keep: []appChange{{current: web, reloaded: web}}}, planReload([]Command{web}, []Command{web}))
+}
+
+func TestReloader(t *testing.T) {
+	web := Command{Name: "web", Command: "./server", ReloadSignal: "SIGHUP"}
+	worker := Command{Name: "worker", Command: "./worker"}
+	cron := Command{Name: "cron", Command: "./cron"}
+	apps := newLifecycles(3)
+	var launched, signalled []string
+	reloads := newReloader([]Command{web, worker, cron}, apps, func(command Command, again bool) error {
+		launched = append(launched, fmt.Sprintf("%s again=%t", command.Command, again))
+		_, err := apps.start(context.Background(), command.Name, again)
+		return err
+	}, func(command Command) error {
+		if len(command.DependsOn) > 0 {
+			return errors.New("untracked")
+		}
+		return nil
+	}, func(command Command) error {
+		signalled = append(signalled, command.Name)
+		return nil
+	})
+	for _, command := range []Command{web, worker, cron} {
+		_, err := apps.start(context.Background(), command.Name, false)
+		assert.NoError(t, err)
+	}
+	var updated [][]Command
+	reloads.notify(func(apps []Command) { updated = append(updated, apps) })
+
+	// cron already ended, and restarts right away
+	cronEnd := Message{Type: OutputEnd, Command: &cron}
+	reloads.observe(cronEnd)
+	assert.False(t, apps.end(cronEnd))
+
+	changedWorker := worker
+	changedWorker.Command = "./worker2"
+	changedCron := cron
+	changedCron.Command = "./cron2"
+	db := Command{Name: "db", Command: "./db"}
+	blocked := Command{Name: "cache", Command: "./cache", DependsOn: []string{"db"}}
+	actions := reloads.reload([]Command{web, changedWorker, changedCron, db, blocked})
+	assert.Equal(t, []string{
+		"starting db, added to the config",
+		"not starting cache, added to the config: untracked",
+		"restarting worker, which changed",
+		"restarting cron, which changed",
+		"sent SIGHUP to web",
+	}, actions)
+	assert.Equal(t, []string{"./db again=true", "./cron2 again=true"}, launched)
+	assert.Equal(t, []string{"web"}, signalled)
+	assert.Equal(t, [][]Command{{db, changedWorker, changedCron, web}}, updated)
+
+	// The new version of the worker starts once the old one ended
+	workerEnd := Message{Type: OutputEnd, Command: &worker}
+	reloads.observe(workerEnd)
+	assert.False(t, apps.end(workerEnd))
+	assert.Equal(t, "./worker2 again=false", launched[2])
+	command, ok := reloads.lookup("worker")
+	assert.True(t, ok)
+	assert.Equal(t, changedWorker, command)
+	_, ok = reloads.lookup("cache")
+	assert.False(t, ok)
+
+	// Removed apps are stopped, and psmgmt waits for all the others
+	assert.Equal(t, []string{"stopping db, removed from the config", "sent SIGHUP to web"}, reloads.reload([]Command{web, changedWorker, changedCron}))
+	for _, name := range []string{"db", "cron", "worker"} {
+		assert.False(t, apps.end(Message{Type: OutputEnd, Command: &Command{Name: name}}))
+	}
+	assert.True(t, apps.end(Message{Type: OutputEnd, Command: &web}))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// safeShellWord matches words that need no quoting for a POSIX shell.
+var safeShellWord = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// commandLine returns the program and arguments that run the command.
+// Commands with shell set run in a shell, commands with an image are run
+// through docker, and commands with a host are wrapped in an invocation of the
+// ssh subcommand, see runSSH, that runs them, possibly in docker, remotely.
+func commandLine(command Command) (string, []string) {
+	command = applyShell(command)
+	name, args := command.Command, command.Args
+	if command.Image != "" {
+		name, args = "docker", dockerRunArgs(command)
+	}
+	if command.Host != "" {
+		name, args = sshExecutable(), sshArgs(command.Host, name, args)
+	}
+	return name, args
+}
+
+// sshExecutable is psmgmt itself, which runs the ssh subcommand.
+var sshExecutable = sync.OnceValue(func() string {
+	if path, err := os.Executable(); err == nil {
+		return path
+	}
+	return os.Args[0]
+})
+
+// sshArgs returns the arguments of the ssh subcommand that run name with args on host.
+func sshArgs(host string, name string, args []string) []string {
+	// The remote side runs the command through the user's login shell,
+	// so every word has to be quoted for it
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{name}, args...) {
+		words = append(words, shellQuote(word))
+	}
+	return []string{"ssh", host, "--", strings.Join(words, " ")}
+}
+
+// shellQuote quotes s so that a POSIX shell reads it as a single word.
+func shellQuote(s string) string {
+	if safeShellWord.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandLine(t *testing.T) {
+	name, args := commandLine(Command{Command: "echo", Args: []string{"hello world"}})
+	assert.Equal(t, "echo", name)
+	assert.Equal(t, []string{"hello world"}, args)
+
+	name, args = commandLine(Command{Name: "db", Image: "postgres:16", Env: map[string]string{"POSTGRES_PASSWORD": "secret", "PGDATA": "/data"}})
+	assert.Equal(t, "docker", name)
+	assert.Equal(t, []string{"run", "--rm", "-i", "--name", containerName(Command{Name: "db"}), "-e", "PGDATA", "-e", "POSTGRES_PASSWORD", "postgres:16"}, args)
+
+	// The working_dir is inside the container
+	_, args = commandLine(Command{Name: "db", Image: "postgres:16", WorkingDir: "/var/lib/postgresql"})
+	assert.Equal(t, []string{"run", "--rm", "-i", "--name", containerName(Command{Name: "db"}), "-w", "/var/lib/postgresql", "postgres:16"}, args)
+	assert.Empty(t, localWorkingDir(Command{Image: "postgres:16", WorkingDir: "/var/lib/postgresql"}))
+	assert.Equal(t, "/srv", localWorkingDir(Command{Command: "./web", WorkingDir: "/srv"}))
+
+	name, args = commandLine(Command{Command: "echo", Args: []string{"it's", "$HOME", "ok"}, Host: "deploy@example.com"})
+	assert.Equal(t, sshExecutable(), name)
+	assert.Equal(t, []string{"ssh", "deploy@example.com", "--", `echo 'it'\''s' '$HOME' ok`}, args)
+
+	name, args = commandLine(Command{Name: "db", Image: "postgres:16", Args: []string{"-c", "fsync=off"}, Host: "deploy@example.com"})
+	assert.Equal(t, sshExecutable(), name)
+	assert.Equal(t, []string{"ssh", "deploy@example.com", "--", "docker run --rm -i --name " + containerName(Command{Name: "db"}) + " postgres:16 -c fsync=off"}, args)
+}
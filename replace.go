@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Replacement rewrites the parts of output lines matching Pattern to With, in which
+// $1 or ${name} refer to the groups of the pattern and $$ is a literal $.
+type Replacement struct {
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+}
+
+// compiledReplacement is a Replacement with its pattern compiled.
+type compiledReplacement struct {
+	pattern *regexp.Regexp
+	with    string
+}
+
+// compileReplacements compiles the patterns of the replacements.
+func compileReplacements(replacements []Replacement) ([]compiledReplacement, error) {
+	compiled := make([]compiledReplacement, 0, len(replacements))
+	for _, replacement := range replacements {
+		pattern, err := regexp.Compile(replacement.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replace pattern: %w", err)
+		}
+		compiled = append(compiled, compiledReplacement{pattern: pattern, with: replacement.With})
+	}
+	return compiled, nil
+}
+
+// replacer rewrites the output lines of commands with their replace rules.
+type replacer struct {
+	rules map[string][]compiledReplacement
+}
+
+// newReplacer compiles the replace rules of the apps.
+func newReplacer(apps []Command) (*replacer, error) {
+	r := &replacer{rules: make(map[string][]compiledReplacement)}
+	for _, command := range apps {
+		if len(command.Replace) == 0 {
+			continue
+		}
+		compiled, err := compileReplacements(command.Replace)
+		if err != nil {
+			return nil, err
+		}
+		r.rules[command.Name] = compiled
+	}
+	return r, nil
+}
+
+// apply returns the message with the rules of its command applied in order to
+// stdout and stderr lines. The original line is kept in Raw if it changed.
+func (r *replacer) apply(message Message) Message {
+	if message.Command == nil || (message.Type != OutputStdout && message.Type != OutputStderr) {
+		return message
+	}
+	rules, ok := r.rules[message.Command.Name]
+	if !ok {
+		return message
+	}
+
+	content := message.Content
+	for _, rule := range rules {
+		content = rule.pattern.ReplaceAllString(content, rule.with)
+	}
+	if content != message.Content {
+		message.Raw = message.Content
+		message.Content = content
+	}
+	return message
+}
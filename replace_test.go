@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplacer(t *testing.T) {
+	r, err := newReplacer([]Command{{
+		Name: "build",
+		Replace: []Replacement{
+			{Pattern: `/tmp/[\w.-]+`, With: "$$TMP"},
+			{Pattern: `took (\d+)ms`, With: "took ${1}ms"},
+			{Pattern: `token=\w+`, With: "token=***"},
+		},
+	}})
+	assert.NoError(t, err)
+
+	build := &Command{Name: "build"}
+	message := r.apply(Message{Content: "wrote /tmp/go-build123/out token=abc", Type: OutputStdout, Command: build})
+	assert.Equal(t, "wrote $TMP/out token=***", message.Content)
+	assert.Equal(t, "wrote /tmp/go-build123/out token=abc", message.Raw)
+
+	// Unchanged lines, other types and other commands are left alone
+	message = r.apply(Message{Content: "done", Type: OutputStderr, Command: build})
+	assert.Equal(t, Message{Content: "done", Type: OutputStderr, Command: build}, message)
+	message = r.apply(Message{Content: "/tmp/x", Type: SystemError, Command: build})
+	assert.Equal(t, "/tmp/x", message.Content)
+	message = r.apply(Message{Content: "/tmp/x", Type: OutputStdout, Command: &Command{Name: "web"}})
+	assert.Equal(t, "/tmp/x", message.Content)
+
+	_, err = newReplacer([]Command{{Name: "build", Replace: []Replacement{{Pattern: "("}}}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Restart policies of a command.
+const (
+	restartNo        = "no"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+const (
+	// restartDelay is the delay before every restart of a command without a
+	// restart_backoff, which keeps a command that exits right away from spinning.
+	restartDelay = 100 * time.Millisecond
+	// defaultRestartBackoffMax caps the restart_backoff of a command without a
+	// restart_backoff_max.
+	defaultRestartBackoffMax = time.Minute
+	// restartBackoffReset is the time a process of a command without a min_uptime
+	// has to stay alive for the delay before restarting it to start over.
+	restartBackoffReset = 10 * time.Second
+)
+
+// validateRestart checks the restart policy of the command.
+func validateRestart(command Command) error {
+	switch command.Restart {
+	case "", restartNo, restartOnFailure, restartAlways:
+	default:
+		return fmt.Errorf("invalid restart %q, expected %q, %q or %q", command.Restart, restartNo, restartOnFailure, restartAlways)
+	}
+	if command.MaxRetries < 0 {
+		return fmt.Errorf("max_retries must not be negative")
+	}
+	if command.RestartBackoff < 0 || command.RestartBackoffMax < 0 {
+		return fmt.Errorf("restart_backoff and restart_backoff_max must not be negative")
+	}
+	if command.RestartBackoffMax > 0 && command.RestartBackoffMax < command.RestartBackoff {
+		return fmt.Errorf("restart_backoff_max must not be less than restart_backoff")
+	}
+	return nil
+}
+
+// restartBackoff computes the delays between the restarts of a command, which
+// double from its restart_backoff up to its restart_backoff_max for as long as
+// its process keeps exiting soon after starting.
+type restartBackoff struct {
+	initial time.Duration
+	max     time.Duration
+	reset   time.Duration
+	next    time.Duration
+}
+
+// newRestartBackoff returns the restartBackoff for the command. Without a
+// restart_backoff the delay stays at restartDelay. The delay starts over once
+// a process stayed up for min_uptime.
+func newRestartBackoff(command Command) *restartBackoff {
+	backoff := &restartBackoff{initial: restartDelay, max: restartDelay, reset: command.MinUptime}
+	if backoff.reset <= 0 {
+		backoff.reset = restartBackoffReset
+	}
+	if command.RestartBackoff > 0 {
+		backoff.initial, backoff.max = command.RestartBackoff, command.RestartBackoffMax
+		if backoff.max <= 0 {
+			backoff.max = max(defaultRestartBackoffMax, backoff.initial)
+		}
+	}
+	return backoff
+}
+
+// delay returns the delay before restarting a process that stayed alive for uptime.
+func (b *restartBackoff) delay(uptime time.Duration) time.Duration {
+	if b.next == 0 || uptime >= b.reset {
+		b.next = b.initial
+	}
+	delay := b.next
+	b.next = min(b.next*2, b.max)
+	return delay
+}
+
+// shouldRestart reports whether the command's policy restarts it after a run
+// that ended with result. Commands psmgmt stopped to restart them are restarted
+// whatever their policy, except for commands that run once.
+func shouldRestart(command Command, result runResult) bool {
+	switch {
+	case !result.started || command.RunOnce:
+		return false
+	case result.restartRequested:
+		return true
+	case command.Restart == restartAlways:
+		return true
+	case command.Restart == restartOnFailure:
+		return result.err != nil
+	}
+	return false
+}
+
+// restart decides whether the command runs again after its attempt-th run ended
+// with result, and prepares that run: it reports the restart, restarts the apps
+// that restart with the command, waits for the backoff and runs the on_restart hook.
+// It returns false as soon as ctx is done.
+func (r *Runner) restart(ctx context.Context, outputChan chan<- Message, command Command, result runResult, guard *crashGuard, backoff *restartBackoff, attempt int) bool {
+	if ctx.Err() != nil || r.NoRestart || !shouldRestart(command, result) {
+		return false
+	}
+	fail := func(content string, failed bool) bool {
+		send(r.Clock, outputChan, Message{Content: content, Type: SystemError, Command: &command, Failed: failed})
+		return false
+	}
+	// Running out of retries only fails the command if its last run failed
+	if command.MaxRetries > 0 && attempt > command.MaxRetries {
+		return fail(fmt.Sprintf("exited with code %d, not restarting after max_retries of %d", result.exitCode, command.MaxRetries), result.err != nil)
+	}
+
+	// Back off from processes that keep exiting, and more so from the ones that
+	// crash before becoming ready
+	delay := backoff.delay(result.uptime)
+	if !result.restartRequested {
+		backoff, ok := guard.crashed(result.healthy)
+		if !ok {
+			return fail(fmt.Sprintf("exited %d times in a row before becoming ready, not restarting", guard.unhealthyStreak()), true)
+		}
+		delay += backoff
+	}
+	if !r.allowRestart() {
+		r.abort()
+		return fail(fmt.Sprintf("restart_limit of %d restarts within %s exceeded, shutting down", r.RestartLimit.Max, r.RestartLimit.Window), true)
+	}
+
+	r.restarting(command.Name)
+	retries := ""
+	if command.MaxRetries > 0 {
+		retries = fmt.Sprintf(" of %d", command.MaxRetries)
+	}
+	send(r.Clock, outputChan, Message{
+		Content: fmt.Sprintf("exited with code %d, restarting in %s (restart %d%s)", result.exitCode, delay, attempt, retries),
+		Type:    SystemError,
+		Command: &command,
+	})
+	if !result.cascaded {
+		for _, name := range restartCascade(r.apps(), command.Name) {
+			r.requestRestart(name, command.Name)
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-r.Clock.After(delay):
+	}
+	if err := runRestartHook(ctx, r.Clock, outputChan, command, attempt, result.exitCode); err != nil {
+		send(r.Clock, outputChan, Message{Content: err.Error(), Type: SystemError, Command: &command, Failed: true})
+	}
+	return true
+}
+
+// RestartLimit caps the number of restarts across all commands within a time window.
+// Exceeding it means something is systemically wrong, so the whole run is shut down.
+type RestartLimit struct {
+	// Max is the number of restarts allowed within Window.
+	Max int `yaml:"max"`
+	// Window is the period restarts are counted over, e.g. "1m".
+	Window time.Duration `yaml:"window"`
+}
+
+// restartLimiter counts restarts across all commands against a RestartLimit.
+// A nil limiter allows every restart.
+type restartLimiter struct {
+	limit    RestartLimit
+	mu       sync.Mutex
+	restarts []time.Time
+}
+
+// newRestartLimiter returns a limiter for the given limit, or nil if limit is nil.
+func newRestartLimiter(limit *RestartLimit) *restartLimiter {
+	if limit == nil {
+		return nil
+	}
+	return &restartLimiter{limit: *limit}
+}
+
+// allow records a restart happening at now and reports whether the number of
+// restarts within the window still stays within the limit.
+func (l *restartLimiter) allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the restarts that fell out of the window
+	cutoff := now.Add(-l.limit.Window)
+	kept := l.restarts[:0]
+	for _, restart := range l.restarts {
+		if restart.After(cutoff) {
+			kept = append(kept, restart)
+		}
+	}
+	l.restarts = append(kept, now)
+
+	return len(l.restarts) <= l.limit.Max
+}
+
+const (
+	// defaultUnhealthyBackoff is the first delay before restarting a command that
+	// crashed before becoming healthy.
+	defaultUnhealthyBackoff = time.Second
+	// defaultMaxUnhealthyRestarts is the number of consecutive restarts of a command
+	// that never becomes healthy after which psmgmt gives up on it.
+	defaultMaxUnhealthyRestarts = 5
+)
+
+// crashGuard follows the consecutive runs of a command that crashed before
+// becoming healthy, i.e. ready. Such unhealthy restarts back off exponentially
+// and are eventually given up, while a crash after the command became healthy
+// starts over with a clean slate.
+type crashGuard struct {
+	backoff time.Duration
+	// maxBackoff caps the doubled backoff, like restart_backoff_max does for
+	// the restart_backoff.
+	maxBackoff time.Duration
+	max        int
+	streak     int
+}
+
+// newCrashGuard returns the crashGuard for the command, applying the defaults
+// to the settings it leaves unset.
+func newCrashGuard(command Command) *crashGuard {
+	guard := &crashGuard{
+		backoff:    command.UnhealthyBackoff,
+		maxBackoff: command.RestartBackoffMax,
+		max:        command.MaxUnhealthyRestarts,
+	}
+	if guard.backoff <= 0 {
+		guard.backoff = defaultUnhealthyBackoff
+	}
+	if guard.maxBackoff <= 0 {
+		guard.maxBackoff = max(defaultRestartBackoffMax, guard.backoff)
+	}
+	if guard.max <= 0 {
+		guard.max = defaultMaxUnhealthyRestarts
+	}
+	return guard
+}
+
+// crashed records a crash of the command and returns the extra delay before
+// restarting it, or false if it crashed unhealthy too many times in a row.
+func (g *crashGuard) crashed(healthy bool) (time.Duration, bool) {
+	if healthy {
+		g.streak = 0
+		return 0, true
+	}
+
+	g.streak++
+	if g.streak > g.max {
+		return 0, false
+	}
+	// Doubling stops at the cap, before the delay could overflow
+	delay := g.backoff
+	for i := 1; i < g.streak && delay < g.maxBackoff; i++ {
+		if delay > g.maxBackoff/2 {
+			delay = g.maxBackoff
+		} else {
+			delay *= 2
+		}
+	}
+	return min(delay, g.maxBackoff), true
+}
+
+// unhealthyStreak returns the number of consecutive unhealthy crashes.
+func (g *crashGuard) unhealthyStreak() int {
+	return g.streak
+}
+
+// restartCascade returns the names of the apps to restart along with the named
+// app, following restart_with transitively. Every app is restarted at most once
+// per cascade, so that apps listing each other don't restart in a loop. The named
+// app itself is never part of the result.
+func restartCascade(apps []Command, name string) []string {
+	dependents := make(map[string][]string, len(apps))
+	for _, command := range apps {
+		dependents[command.Name] = command.RestartWith
+	}
+
+	seen := map[string]bool{name: true}
+	var cascade []string
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, dependent := range dependents[current] {
+			if seen[dependent] {
+				continue
+			}
+			seen[dependent] = true
+			cascade = append(cascade, dependent)
+			queue = append(queue, dependent)
+		}
+	}
+	return cascade
+}
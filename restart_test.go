@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestartLimiter(t *testing.T) {
+	limiter := newRestartLimiter(&RestartLimit{Max: 2, Window: time.Minute})
+	now := time.Now()
+
+	assert.True(t, limiter.allow(now))
+	assert.True(t, limiter.allow(now.Add(10*time.Second)))
+	assert.False(t, limiter.allow(now.Add(20*time.Second)))
+
+	// Restarts older than the window no longer count
+	assert.True(t, limiter.allow(now.Add(2*time.Minute)))
+
+	var unlimited *restartLimiter
+	assert.True(t, unlimited.allow(now))
+}
+
+func TestCrashGuard(t *testing.T) {
+	guard := newCrashGuard(Command{UnhealthyBackoff: time.Second, MaxUnhealthyRestarts: 3})
+
+	delay, ok := guard.crashed(false)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, delay)
+
+	delay, ok = guard.crashed(false)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, delay)
+	assert.Equal(t, 2, guard.unhealthyStreak())
+
+	// Crashing after becoming healthy doesn't count against the command
+	delay, ok = guard.crashed(true)
+	assert.True(t, ok)
+	assert.Zero(t, delay)
+	assert.Zero(t, guard.unhealthyStreak())
+
+	for i := 0; i < 3; i++ {
+		_, ok = guard.crashed(false)
+		assert.True(t, ok)
+	}
+	_, ok = guard.crashed(false)
+	assert.False(t, ok)
+
+	// The delay stops doubling at restart_backoff_max, however long the streak
+	guard = newCrashGuard(Command{UnhealthyBackoff: time.Second, RestartBackoffMax: 3 * time.Second, MaxUnhealthyRestarts: 100})
+	var delays []time.Duration
+	for i := 0; i < 100; i++ {
+		delay, ok = guard.crashed(false)
+		assert.True(t, ok)
+		delays = append(delays, delay)
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, delays[:4])
+	assert.Equal(t, 3*time.Second, delays[99])
+
+	// and at 1m without it
+	guard = newCrashGuard(Command{MaxUnhealthyRestarts: 100})
+	for i := 0; i < 100; i++ {
+		delay, _ = guard.crashed(false)
+	}
+	assert.Equal(t, time.Minute, delay)
+}
+
+func TestRestartCascade(t *testing.T) {
+	apps := []Command{
+		{Name: "db", RestartWith: []string{"api", "worker"}},
+		{Name: "api", RestartWith: []string{"web", "db"}},
+		{Name: "worker"},
+		{Name: "web", RestartWith: []string{"api"}},
+	}
+
+	assert.Equal(t, []string{"api", "worker", "web"}, restartCascade(apps, "db"))
+	assert.Equal(t, []string{"api", "db", "worker"}, restartCascade(apps, "web"))
+	assert.Empty(t, restartCascade(apps, "worker"))
+}
+
+func TestShouldRestart(t *testing.T) {
+	failed := runResult{started: true, err: errors.New("exit status 1"), exitCode: 1}
+	succeeded := runResult{started: true}
+	requested := runResult{started: true, restartRequested: true}
+
+	for _, test := range []struct {
+		command Command
+		result  runResult
+		restart bool
+	}{
+		{Command{}, failed, false},
+		{Command{Restart: restartNo}, failed, false},
+		{Command{Restart: restartOnFailure}, failed, true},
+		{Command{Restart: restartOnFailure}, succeeded, false},
+		{Command{Restart: restartAlways}, succeeded, true},
+		{Command{Restart: restartAlways}, runResult{}, false},
+		{Command{}, requested, true},
+		{Command{Restart: restartAlways, RunOnce: true}, requested, false},
+	} {
+		assert.Equal(t, test.restart, shouldRestart(test.command, test.result), "%+v %+v", test.command, test.result)
+	}
+
+	assert.NoError(t, validateRestart(Command{Restart: restartOnFailure, MaxRetries: 3}))
+	assert.ErrorContains(t, validateRestart(Command{Restart: "sometimes"}), `invalid restart "sometimes"`)
+	assert.ErrorContains(t, validateRestart(Command{MaxRetries: -1}), "max_retries must not be negative")
+}
+
+func TestRestartBackoff(t *testing.T) {
+	backoff := newRestartBackoff(Command{})
+	assert.Equal(t, restartDelay, backoff.delay(0))
+	assert.Equal(t, restartDelay, backoff.delay(0))
+
+	backoff = newRestartBackoff(Command{RestartBackoff: 500 * time.Millisecond, RestartBackoffMax: 3 * time.Second})
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, backoff.delay(time.Second))
+	}
+	assert.Equal(t, []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}, delays)
+
+	// A process that stayed alive long enough starts over
+	assert.Equal(t, 500*time.Millisecond, backoff.delay(restartBackoffReset))
+
+	backoff = newRestartBackoff(Command{RestartBackoff: time.Second, MinUptime: time.Minute})
+	backoff.delay(0)
+	assert.Equal(t, 2*time.Second, backoff.delay(restartBackoffReset))
+	assert.Equal(t, time.Second, backoff.delay(time.Minute))
+
+	assert.Equal(t, defaultRestartBackoffMax, newRestartBackoff(Command{RestartBackoff: time.Second}).max)
+	assert.ErrorContains(t, validateRestart(Command{RestartBackoff: time.Second, RestartBackoffMax: time.Millisecond}), "restart_backoff_max must not be less than restart_backoff")
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// defaultLogMaxFiles is the number of rotated log files kept when a log_file
+// has a log_max_size but no log_max_files.
+const defaultLogMaxFiles = 5
+
+// rotatingWriter appends to a file, and rotates it to "<file>.1" before a
+// write would make it exceed maxSize, shifting older rotations to "<file>.2"
+// and so on up to maxFiles. Every write goes to a single file as a whole, so
+// that no line is split or lost by a rotation.
+type rotatingWriter struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// openRotatingWriter opens the file at path for appending, creating it if
+// needed. A maxSize of 0 never rotates it.
+func openRotatingWriter(path string, maxSize int64, maxFiles int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// open opens the file at the writer's path, keeping track of its size.
+func (w *rotatingWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file, w.size = file, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p doesn't fit anymore.
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("error rotating %s: %w", w.path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file to "<file>.1" after shifting the older rotations, drops
+// the oldest one beyond maxFiles and starts a new file. If the file can't be
+// moved, writing goes on to the same file.
+func (w *rotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	err := w.shift()
+	if openErr := w.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	return err
+}
+
+// shift renames the file and its rotations to the next number.
+func (w *rotatingWriter) shift() error {
+	if err := os.Remove(w.rotation(w.maxFiles)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for i := w.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(w.rotation(i), w.rotation(i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(w.path, w.rotation(1))
+}
+
+// rotation returns the path of the n-th rotation of the file.
+func (w *rotatingWriter) rotation(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
+
+// Close closes the file.
+func (w *rotatingWriter) Close() error {
+	return w.file.Close()
+}
+
+// validateLogRotation checks the log rotation settings of the command.
+func validateLogRotation(command Command) error {
+	if command.LogMaxSize == "" {
+		if command.LogMaxFiles != 0 {
+			return errors.New("log_max_files requires log_max_size")
+		}
+		return nil
+	}
+	if command.LogFile == "" {
+		return errors.New("log_max_size requires log_file")
+	}
+	if _, err := parseSize(command.LogMaxSize); err != nil {
+		return fmt.Errorf("invalid log_max_size: %w", err)
+	}
+	if command.LogMaxFiles < 0 {
+		return errors.New("log_max_files must not be negative")
+	}
+	return nil
+}
+
+// logRotation returns the maximum size of the command's log_file, 0 if it is
+// never rotated, and the number of rotations kept.
+func logRotation(command Command) (int64, int) {
+	size, err := parseSize(command.LogMaxSize)
+	if err != nil {
+		return 0, 0
+	}
+	files := command.LogMaxFiles
+	if files == 0 {
+		files = defaultLogMaxFiles
+	}
+	return int64(size), files
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotatingWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.log")
+	w, err := openRotatingWriter(path, 10, 2)
+	assert.NoError(t, err)
+	for _, line := range []string{"aaaa", "bbbb", "cccc", "dddd", "eeee", "ffff", "gggg"} {
+		_, err := w.Write([]byte(line + "\n"))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	read := func(path string) string {
+		content, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		return string(content)
+	}
+	assert.Equal(t, "gggg\n", read(path))
+	assert.Equal(t, "eeee\nffff\n", read(path+".1"))
+	assert.Equal(t, "cccc\ndddd\n", read(path+".2"))
+	assert.NoFileExists(t, path+".3")
+
+	// Reopening goes on with the size of the existing file
+	w, err = openRotatingWriter(path, 10, 2)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("hhhh\n"))
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("iiii\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "iiii\n", read(path))
+	assert.Equal(t, "gggg\nhhhh\n", read(path+".1"))
+}
+
+func TestValidateLogRotation(t *testing.T) {
+	assert.NoError(t, validateLogRotation(Command{}))
+	assert.NoError(t, validateLogRotation(Command{LogFile: "web.log", LogMaxSize: "10M", LogMaxFiles: 3}))
+	assert.EqualError(t, validateLogRotation(Command{LogMaxSize: "10M"}), "log_max_size requires log_file")
+	assert.EqualError(t, validateLogRotation(Command{LogFile: "web.log", LogMaxFiles: 3}), "log_max_files requires log_max_size")
+	assert.ErrorContains(t, validateLogRotation(Command{LogFile: "web.log", LogMaxSize: "lots"}), "invalid log_max_size")
+	assert.EqualError(t, validateLogRotation(Command{LogFile: "web.log", LogMaxSize: "10M", LogMaxFiles: -1}), "log_max_files must not be negative")
+
+	size, files := logRotation(Command{LogFile: "web.log", LogMaxSize: "1K"})
+	assert.Equal(t, int64(1024), size)
+	assert.Equal(t, defaultLogMaxFiles, files)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Runner runs commands and holds what they share, like the source of time. It
+// installs no signal handlers, so that a program embedding it keeps ownership
+// of its signals: commands are stopped by cancelling the context passed to
+// Execute.
+type Runner struct {
+	// Clock is the source of time of the runner and of everything it drives.
+	Clock Clock
+	// Shutdown stops all commands, e.g. when one of them aborts the run. It
+	// may be nil, in which case aborting only marks the run as failed.
+	Shutdown func()
+	// Apps are the apps of the config, whose restart_with is followed when one
+	// of them restarts. Use SetApps to change them while commands run.
+	Apps []Command
+	// RestartLimit caps the number of restarts across all commands, exceeding
+	// it aborts the run. It may be nil to allow any number of restarts.
+	RestartLimit *RestartLimit
+	// NoRestart runs every command to completion once, whatever its restart policy.
+	NoRestart bool
+
+	aborted    atomic.Bool
+	throughput *throughput
+
+	mu       sync.Mutex
+	ran      map[string]bool
+	limiter  *restartLimiter
+	restarts map[string]chan string
+	states   map[string]*commandState
+}
+
+// NewRunner returns a Runner using the real clock.
+func NewRunner() *Runner {
+	clock := realClock{}
+	return &Runner{Clock: clock, throughput: newThroughput(clock)}
+}
+
+// SetApps replaces the Apps, e.g. with the ones of a reloaded config.
+func (r *Runner) SetApps(apps []Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Apps = apps
+}
+
+// apps returns the Apps.
+func (r *Runner) apps() []Command {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Apps
+}
+
+// claimRun records that the named run_once command runs. It reports false if
+// it already ran, in which case it must not run again.
+func (r *Runner) claimRun(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ran[name] {
+		return false
+	}
+	if r.ran == nil {
+		r.ran = make(map[string]bool)
+	}
+	r.ran[name] = true
+	return true
+}
+
+// abort fails the whole run and shuts down all commands.
+func (r *Runner) abort() {
+	r.aborted.Store(true)
+	if r.Shutdown != nil {
+		r.Shutdown()
+	}
+}
+
+// Aborted reports whether a command aborted the run.
+func (r *Runner) Aborted() bool {
+	return r.aborted.Load()
+}
+
+// Throughput returns the output volume of every command run so far, sorted by name.
+func (r *Runner) Throughput() []ThroughputStats {
+	return r.throughput.stats()
+}
+
+// allowRestart records a restart and reports whether it stays within the restart limit.
+func (r *Runner) allowRestart() bool {
+	r.mu.Lock()
+	if r.limiter == nil {
+		r.limiter = newRestartLimiter(r.RestartLimit)
+	}
+	limiter := r.limiter
+	r.mu.Unlock()
+	return limiter.allow(r.Clock.Now())
+}
+
+// watchRestarts returns the channel on which the name of an app arrives when the
+// named command has to restart along with it.
+func (r *Runner) watchRestarts(name string) <-chan string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.restarts == nil {
+		r.restarts = make(map[string]chan string)
+	}
+	restarts := make(chan string, 1)
+	r.restarts[name] = restarts
+	return restarts
+}
+
+// unwatchRestarts stops delivering restarts of the named command to restarts.
+func (r *Runner) unwatchRestarts(name string, restarts <-chan string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.restarts[name] == restarts {
+		delete(r.restarts, name)
+	}
+}
+
+// requestRestart asks the named command to restart along with cause. Commands
+// that don't run, or already have a restart pending, are left alone.
+func (r *Runner) requestRestart(name string, cause string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case r.restarts[name] <- cause:
+	default:
+	}
+}
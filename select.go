@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// stringList is a flag that may be given several times, each time with one or
+// more comma-separated values.
+type stringList []string
+
+// stringListFlag defines a stringList flag with the name and usage.
+func stringListFlag(name, usage string) *stringList {
+	list := new(stringList)
+	flag.Var(list, name, usage)
+	return list
+}
+
+// String returns the values, separated by commas.
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set adds the comma-separated values of a single flag.
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// appFilter selects the apps psmgmt runs. Without tags and names every app is
+// selected, otherwise the ones with any of the tags and the named ones. The
+// excepted apps are left out either way.
+type appFilter struct {
+	tags   []string
+	only   []string
+	except []string
+}
+
+// apply returns the selected apps, in the order of the config. Unknown names
+// and tags, and selected apps depending on apps that were left out, are errors.
+func (f appFilter) apply(apps []Command) ([]Command, error) {
+	if len(f.tags) == 0 && len(f.only) == 0 && len(f.except) == 0 {
+		return apps, nil
+	}
+	for _, name := range append(append([]string(nil), f.only...), f.except...) {
+		if _, ok := findApp(apps, Command{Name: name}); !ok {
+			return nil, fmt.Errorf("unknown app %q", name)
+		}
+	}
+	for _, tag := range f.tags {
+		if !slices.ContainsFunc(apps, func(command Command) bool { return slices.Contains(command.Tags, tag) }) {
+			return nil, fmt.Errorf("no app has tag %q", tag)
+		}
+	}
+
+	named := func(names []string, command Command) bool {
+		return slices.ContainsFunc(names, func(name string) bool { return sameApp(command, Command{Name: name}) })
+	}
+	var selected []Command
+	for _, command := range apps {
+		chosen := len(f.tags) == 0 && len(f.only) == 0
+		chosen = chosen || named(f.only, command) || slices.ContainsFunc(f.tags, func(tag string) bool { return slices.Contains(command.Tags, tag) })
+		if chosen && !named(f.except, command) {
+			selected = append(selected, command)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no apps selected")
+	}
+
+	// The apps left out would never start, so the ones waiting for them wouldn't either
+	for _, command := range selected {
+		for _, name := range dependsOn(command) {
+			if _, ok := findApp(selected, Command{Name: name}); !ok {
+				return nil, fmt.Errorf("%s depends on %s, which is not selected", command.Name, name)
+			}
+		}
+	}
+	return selected, nil
+}
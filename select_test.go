@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppFilter(t *testing.T) {
+	config, err := parseConfig(strings.NewReader(`version: "1"
+apps:
+  - name: db
+    command: postgres
+    tags: [backend, storage]
+  - name: api
+    command: ./api
+    tags: [backend]
+    depends_on: [db]
+  - name: web
+    command: ./web
+    tags: [frontend]
+  - name: docs
+    command: ./docs
+`))
+	assert.NoError(t, err)
+	names := func(filter appFilter) []string {
+		apps, err := filter.apply(config.Apps)
+		assert.NoError(t, err)
+		var names []string
+		for _, command := range apps {
+			names = append(names, command.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, []string{"db", "api", "web", "docs"}, names(appFilter{}))
+	assert.Equal(t, []string{"db", "api"}, names(appFilter{tags: []string{"backend"}}))
+	assert.Equal(t, []string{"db", "web"}, names(appFilter{tags: []string{"storage", "frontend"}}))
+	assert.Equal(t, []string{"db", "docs"}, names(appFilter{tags: []string{"storage"}, only: []string{"Docs"}}))
+	assert.Equal(t, []string{"web", "docs"}, names(appFilter{except: []string{"api", "db"}}))
+	assert.Equal(t, []string{"db"}, names(appFilter{tags: []string{"backend"}, except: []string{"api"}}))
+
+	for _, test := range []struct {
+		filter appFilter
+		err    string
+	}{
+		{appFilter{only: []string{"cache"}}, `unknown app "cache"`},
+		{appFilter{except: []string{"cache"}}, `unknown app "cache"`},
+		{appFilter{tags: []string{"mobile"}}, `no app has tag "mobile"`},
+		{appFilter{only: []string{"web"}, except: []string{"web"}}, "no apps selected"},
+		{appFilter{only: []string{"api"}}, "api depends on db, which is not selected"},
+	} {
+		_, err := test.filter.apply(config.Apps)
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func TestStringList(t *testing.T) {
+	var list stringList
+	assert.NoError(t, list.Set("web"))
+	assert.NoError(t, list.Set("api, db,"))
+	assert.Equal(t, stringList{"web", "api", "db"}, list)
+	assert.Equal(t, "web,api,db", list.String())
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// The modes commands are run in.
+const (
+	// modeParallel runs all commands at once, the default.
+	modeParallel = "parallel"
+	// modeSequential runs the commands one at a time, in order, for a pipeline
+	// of one-shot tasks.
+	modeSequential = "sequential"
+)
+
+// validateMode checks the mode of the config, and that in sequential mode no
+// app waits for one that only runs after it.
+func validateMode(config *Config) error {
+	switch config.Mode {
+	case "", modeParallel:
+		return nil
+	case modeSequential:
+	default:
+		return fmt.Errorf("unknown mode %q, expected %q or %q", config.Mode, modeParallel, modeSequential)
+	}
+	position := make(map[string]int, len(config.Apps))
+	for i, command := range config.Apps {
+		position[command.Name] = i
+	}
+	for i, command := range config.Apps {
+		for _, name := range dependsOn(command) {
+			if j, ok := position[name]; ok && j >= i {
+				return fmt.Errorf("apps[%d] %q: waits for %q, which runs after it in sequential mode", i, command.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// sequence runs the commands of the sequential mode one at a time, starting
+// each once the one before it ended with exit code 0.
+type sequence struct {
+	commands []Command
+	ended    chan Message
+}
+
+// newSequence returns a sequence of the commands, in order.
+func newSequence(commands []Command) *sequence {
+	return &sequence{
+		commands: commands,
+		ended:    make(chan Message, len(commands)),
+	}
+}
+
+// observe notes the end of the running command.
+func (s *sequence) observe(message Message) {
+	if s != nil && message.Type == OutputEnd {
+		s.ended <- message
+	}
+}
+
+// run starts the commands in order with start. After the first failure, or
+// on shutdown, the remaining commands are reported as not run with skip.
+func (s *sequence) run(ctx context.Context, start func(Command), skip func(Command, string)) {
+	for i, command := range s.commands {
+		if ctx.Err() != nil {
+			for _, rest := range s.commands[i:] {
+				skip(rest, "not running, shut down before its turn")
+			}
+			return
+		}
+		start(command)
+		// On shutdown the command is stopped, which isn't a failure
+		end := <-s.ended
+		if end.ExitCode != 0 && ctx.Err() == nil {
+			for _, rest := range s.commands[i+1:] {
+				skip(rest, fmt.Sprintf("not running, %s failed", command.Name))
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequence(t *testing.T) {
+	ctx := context.Background()
+	commands := []Command{
+		{Name: "build", Command: "echo", Args: []string{"built"}},
+		{Name: "test", Command: "sh", Args: []string{"-c", "echo testing; exit 3"}},
+		{Name: "deploy", Command: "echo", Args: []string{"deployed"}},
+	}
+	outputChan := make(chan Message, 10)
+	seq := newSequence(commands)
+	go seq.run(ctx, func(command Command) {
+		Execute(ctx, new(sync.WaitGroup), outputChan, command)
+	}, func(command Command, reason string) {
+		send(realClock{}, outputChan, Message{Type: OutputStart, Command: &command})
+		send(realClock{}, outputChan, Message{Content: reason, Type: SystemError, Command: &command, Failed: true})
+		send(realClock{}, outputChan, Message{Type: OutputEnd, Command: &command})
+	})
+
+	var events []string
+	exits := newExitTracker()
+	streamLogs(outputChan, len(commands), func(message Message) {
+		seq.observe(message)
+		exits.observe(message)
+		switch message.Type {
+		case OutputStart, OutputStdout, SystemError:
+			events = append(events, message.CommandName()+" "+message.Type.Name()+" "+message.Content)
+		}
+	})
+
+	// Every command starts after the one before it ended, and the failure of
+	// the second keeps the third from running
+	assert.Equal(t, []string{
+		"build OutputStart ",
+		"build OutputStdout built",
+		"test OutputStart ",
+		"test OutputStdout testing",
+		"test SystemError error waiting for command: exit status 3",
+		"deploy OutputStart ",
+		"deploy SystemError not running, test failed",
+	}, events)
+	assert.Equal(t, 3, exits.code())
+}
+
+func TestValidateMode(t *testing.T) {
+	apps := []Command{
+		{Name: "migrate", Command: "migrate"},
+		{Name: "seed", Command: "seed", Args: []string{"$(token.stdout)"}},
+		{Name: "token", Command: "token"},
+	}
+	assert.NoError(t, validateMode(&Config{Apps: apps}))
+	assert.NoError(t, validateMode(&Config{Mode: modeParallel, Apps: apps}))
+	assert.EqualError(t, validateMode(&Config{Mode: modeSequential, Apps: apps}), `apps[1] "seed": waits for "token", which runs after it in sequential mode`)
+	assert.NoError(t, validateMode(&Config{Mode: modeSequential, Apps: []Command{apps[0], apps[2], apps[1]}}))
+	assert.EqualError(t, validateMode(&Config{Mode: "serial"}), `unknown mode "serial", expected "parallel" or "sequential"`)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// envName matches the names environment variables can be set under.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SetEnv is a command run when the config is loaded whose trimmed stdout
+// becomes the value of an environment variable of psmgmt, and so of every app.
+type SetEnv struct {
+	// Name is the environment variable that is set.
+	Name string `yaml:"name"`
+	// Command is the program that computes the value.
+	Command string `yaml:"command"`
+	// Args are the arguments passed to the command.
+	Args []string `yaml:"args"`
+}
+
+// validateSetEnv checks that every setenv entry names a variable and a command.
+func validateSetEnv(entries []SetEnv) error {
+	for i, entry := range entries {
+		if !envName.MatchString(entry.Name) {
+			return fmt.Errorf("setenv[%d]: invalid name %q", i, entry.Name)
+		}
+		if entry.Command == "" {
+			return fmt.Errorf("setenv[%d] %q: command is required", i, entry.Name)
+		}
+	}
+	return nil
+}
+
+// runSetEnv runs the setenv entries in order and sets their variables, so that
+// every entry sees the ones before it. The first failing entry stops the run.
+func runSetEnv(entries []SetEnv) error {
+	for i, entry := range entries {
+		output, err := exec.Command(entry.Command, entry.Args...).Output()
+		if err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+				err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return fmt.Errorf("setenv[%d] %q: %w", i, entry.Name, err)
+		}
+		if err := os.Setenv(entry.Name, strings.TrimSpace(string(output))); err != nil {
+			return fmt.Errorf("setenv[%d] %q: %w", i, entry.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSetEnv(t *testing.T) {
+	assert.NoError(t, validateSetEnv([]SetEnv{{Name: "GIT_SHA", Command: "git"}}))
+	assert.ErrorContains(t, validateSetEnv([]SetEnv{{Name: "GIT-SHA", Command: "git"}}), `invalid name "GIT-SHA"`)
+	assert.ErrorContains(t, validateSetEnv([]SetEnv{{Name: "GIT_SHA"}}), "command is required")
+}
+
+func TestRunSetEnv(t *testing.T) {
+	t.Setenv("PSMGMT_TEST_FIRST", "")
+	t.Setenv("PSMGMT_TEST_SECOND", "")
+
+	err := runSetEnv([]SetEnv{
+		{Name: "PSMGMT_TEST_FIRST", Command: "echo", Args: []string{"  abc  "}},
+		{Name: "PSMGMT_TEST_SECOND", Command: "sh", Args: []string{"-c", "echo $PSMGMT_TEST_FIRST-2"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", os.Getenv("PSMGMT_TEST_FIRST"))
+	assert.Equal(t, "abc-2", os.Getenv("PSMGMT_TEST_SECOND"))
+
+	err = runSetEnv([]SetEnv{{Name: "PSMGMT_TEST_FIRST", Command: "sh", Args: []string{"-c", "echo nope >&2; exit 1"}}})
+	assert.ErrorContains(t, err, `setenv[0] "PSMGMT_TEST_FIRST": exit status 1: nope`)
+}
+
+func TestParseConfigSetEnv(t *testing.T) {
+	t.Setenv("PSMGMT_TEST_SHA", "")
+	os.Unsetenv("PSMGMT_TEST_SHA")
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	assert.NoError(t, os.WriteFile(envFile, []byte("PSMGMT_TEST_SHA=from-file\n"), 0o600))
+	config := `version: "1"
+env_file: ` + envFile + `
+setenv:
+  - name: PSMGMT_TEST_SHA
+    command: echo
+    args: [abc123]
+apps:
+  - name: web
+    command: web
+    args: ["--version", "${PSMGMT_TEST_SHA}"]
+`
+
+	// Checking the config keeps the references to setenv variables
+	*dryRun = true
+	parsed, err := parseConfig(strings.NewReader(config))
+	*dryRun = false
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--version", "${PSMGMT_TEST_SHA}"}, parsed.Apps[0].Args)
+	_, set := os.LookupEnv("PSMGMT_TEST_SHA")
+	assert.False(t, set)
+
+	// Variables of setenv are substituted, and take precedence over env_file
+	parsed, err = parseConfig(strings.NewReader(config))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--version", "abc123"}, parsed.Apps[0].Args)
+	assert.Equal(t, "abc123", os.Getenv("PSMGMT_TEST_SHA"))
+	assert.NotContains(t, parsed.Apps[0].Env, "PSMGMT_TEST_SHA")
+
+	_, err = parseConfig(strings.NewReader("version: \"1\"\nsetenv:\n  - name: PSMGMT_TEST_SHA\n    command: \"false\"\napps:\n  - name: web\n    command: web\n"))
+	assert.ErrorContains(t, err, `setenv[0] "PSMGMT_TEST_SHA": exit status 1`)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// shellCommandLine returns the program and arguments that run the command
+// string of a command with shell set, on goos. The app's args become the
+// positional parameters of the script, $1 and on, with the app's name as $0.
+//
+// Local commands run in $SHELL, read with getenv, falling back to /bin/sh, or
+// on Windows in %ComSpec%, falling back to cmd.exe, which appends the args to
+// the script. Commands in a container or on a remote host run in /bin/sh, as
+// the local shell may not exist there.
+func shellCommandLine(command Command, goos string, getenv func(key string) string) (string, []string) {
+	if command.Image == "" && command.Host == "" && goos == "windows" {
+		shell := getenv("ComSpec")
+		if shell == "" {
+			shell = "cmd.exe"
+		}
+		return shell, append([]string{"/C", command.Command}, command.Args...)
+	}
+
+	shell := "/bin/sh"
+	if command.Image == "" && command.Host == "" {
+		if local := getenv("SHELL"); local != "" {
+			shell = local
+		}
+	}
+	return shell, append([]string{"-c", command.Command, command.Name}, command.Args...)
+}
+
+// applyShell returns the command with its command string wrapped in a shell if
+// it sets shell.
+func applyShell(command Command) Command {
+	if command.Shell {
+		command.Command, command.Args = shellCommandLine(command, runtime.GOOS, os.Getenv)
+		command.Shell = false
+	}
+	return command
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellCommandLine(t *testing.T) {
+	command := Command{Name: "web", Command: "./run $1 | tee log", Args: []string{"--port"}, Shell: true}
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	name, args := shellCommandLine(command, "linux", env(map[string]string{"SHELL": "/bin/bash"}))
+	assert.Equal(t, "/bin/bash", name)
+	assert.Equal(t, []string{"-c", "./run $1 | tee log", "web", "--port"}, args)
+
+	name, _ = shellCommandLine(command, "darwin", env(nil))
+	assert.Equal(t, "/bin/sh", name)
+
+	name, args = shellCommandLine(command, "windows", env(nil))
+	assert.Equal(t, "cmd.exe", name)
+	assert.Equal(t, []string{"/C", "./run $1 | tee log", "--port"}, args)
+	name, _ = shellCommandLine(command, "windows", env(map[string]string{"ComSpec": `C:\Windows\system32\cmd.exe`}))
+	assert.Equal(t, `C:\Windows\system32\cmd.exe`, name)
+
+	// The local shell may not exist in containers and on remote hosts
+	remote := command
+	remote.Host = "deploy@example.com"
+	name, _ = shellCommandLine(remote, "linux", env(map[string]string{"SHELL": "/usr/bin/fish"}))
+	assert.Equal(t, "/bin/sh", name)
+	name, args = commandLine(Command{Name: "job", Command: "echo $1", Args: []string{"hi"}, Image: "alpine", Shell: true})
+	assert.Equal(t, "docker", name)
+	assert.Equal(t, []string{"/bin/sh", "-c", "echo $1", "job", "hi"}, args[len(args)-5:])
+}
+
+func TestExecuteShell(t *testing.T) {
+	t.Setenv("SHELL", "")
+	output := func(command Command) []string {
+		outputChan := make(chan Message, 10)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, command)
+		var lines []string
+		streamLogs(outputChan, 1, func(message Message) {
+			if message.Type == OutputStdout || message.Type == SystemError {
+				lines = append(lines, message.Content)
+			}
+		})
+		return lines
+	}
+
+	// A script runs the same as the shell invocation written out
+	direct := output(Command{Name: "exec", Command: "sh", Args: []string{"-c", "GREETING=hello; echo $GREETING $1 | tr a-z A-Z", "exec", "world"}})
+	shell := output(Command{Name: "shell", Command: "GREETING=hello; echo $GREETING $1 | tr a-z A-Z", Args: []string{"world"}, Shell: true})
+	assert.Equal(t, []string{"HELLO WORLD"}, direct)
+	assert.Equal(t, direct, shell)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// signalNames maps the signal names accepted in the config to their values.
+// Signals that only exist on some platforms are listed in platformSignals.
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGABRT": syscall.SIGABRT,
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// parseSignal returns the signal with the given name, such as "SIGHUP" or "HUP".
+func parseSignal(name string) (syscall.Signal, error) {
+	key := strings.ToUpper(name)
+	if !strings.HasPrefix(key, "SIG") {
+		key = "SIG" + key
+	}
+	if signal, ok := signalNames[key]; ok {
+		return signal, nil
+	}
+	if signal, ok := platformSignals[key]; ok {
+		return signal, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
+// signalName returns the name of the signal, like "SIGSEGV", or its number if
+// it has no name in the config.
+func signalName(signal syscall.Signal) string {
+	for _, names := range []map[string]syscall.Signal{signalNames, platformSignals} {
+		for name, value := range names {
+			if value == signal {
+				return name
+			}
+		}
+	}
+	return strconv.Itoa(int(signal))
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// platformSignals is empty on systems without Unix signals.
+var platformSignals = map[string]syscall.Signal{}
@@ -0,0 +1,9 @@
+package main
+
+// Sink receives every message of the output stream, e.g. to persist or forward it.
+type Sink interface {
+	// Write handles a single message.
+	Write(message Message) error
+	// Close flushes pending data and releases the resources of the sink.
+	Close() error
+}
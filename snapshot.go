@@ -0,0 +1,164 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// States of a command in a Snapshot.
+const (
+	statePending    = "pending"
+	stateStarting   = "starting"
+	stateRunning    = "running"
+	stateRestarting = "restarting"
+	stateExited     = "exited"
+	stateStopped    = "stopped"
+)
+
+// CommandStatus is the state of a command at the time of a Snapshot.
+type CommandStatus struct {
+	Name string `json:"name"`
+	// State is one of "pending", before the command was started, "starting",
+	// "running", "restarting", "exited" or "stopped", on shutdown.
+	State string `json:"state"`
+	// Since is when the command entered its state.
+	Since time.Time `json:"since"`
+	// Pid is the PID of the running process.
+	Pid int `json:"pid,omitempty"`
+	// Restarts is the number of times the command was restarted.
+	Restarts int `json:"restarts"`
+	// FailedStarts is the number of processes that exited before min_uptime.
+	FailedStarts int `json:"failed_starts"`
+	// ExitCode is the exit code of the last process that exited, or -1 if it was
+	// killed by a signal. It is nil until a process exited.
+	ExitCode *int `json:"exit_code"`
+	// Ready is set while the command is ready, by the criteria of --ready-file.
+	Ready bool `json:"ready"`
+	// RestartWith names the apps restarted along with the command.
+	RestartWith []string `json:"restart_with,omitempty"`
+	// DependsOn names the apps the command waits for before it starts.
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// commandState is the state of a command kept by the Runner.
+type commandState struct {
+	status CommandStatus
+	// gate tells whether a running command with ready_when is ready.
+	gate *readyGate
+}
+
+// Snapshot returns the state of every command, sorted by name. The apps that
+// were not started yet are pending.
+func (r *Runner) Snapshot() []CommandStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := make([]CommandStatus, 0, len(r.states))
+	seen := make(map[string]bool, len(r.states))
+	for name, state := range r.states {
+		seen[name] = true
+		status := state.status
+		if status.State == stateRunning {
+			status.Ready = state.gate == nil || state.gate.isReady()
+		}
+		status.RestartWith = append([]string(nil), status.RestartWith...)
+		status.DependsOn = append([]string(nil), status.DependsOn...)
+		if status.ExitCode != nil {
+			exitCode := *status.ExitCode
+			status.ExitCode = &exitCode
+		}
+		snapshot = append(snapshot, status)
+	}
+	for _, command := range r.Apps {
+		if !seen[command.Name] {
+			seen[command.Name] = true
+			snapshot = append(snapshot, newCommandStatus(command, statePending, time.Time{}))
+		}
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Name < snapshot[j].Name })
+	return snapshot
+}
+
+// newCommandStatus returns the status of a command that just entered state.
+func newCommandStatus(command Command, state string, since time.Time) CommandStatus {
+	return CommandStatus{
+		Name:        command.Name,
+		State:       state,
+		Since:       since,
+		RestartWith: command.RestartWith,
+		DependsOn:   dependsOn(command),
+	}
+}
+
+// track starts following the state of the command, which is starting.
+func (r *Runner) track(command Command) {
+	now := r.Clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = make(map[string]*commandState)
+	}
+	state, ok := r.states[command.Name]
+	if !ok {
+		state = &commandState{}
+		r.states[command.Name] = state
+	}
+	restarts, failedStarts := state.status.Restarts, state.status.FailedStarts
+	state.status = newCommandStatus(command, stateStarting, now)
+	state.status.Restarts, state.status.FailedStarts = restarts, failedStarts
+	state.gate = nil
+}
+
+// running records that the process of the named command was started.
+func (r *Runner) running(name string, pid int, gate *readyGate) {
+	r.update(name, stateRunning, func(state *commandState) {
+		state.status.Pid = pid
+		state.gate = gate
+	})
+}
+
+// exited records that the process of the named command exited with exitCode.
+func (r *Runner) exited(name string, exitCode int) {
+	r.update(name, stateExited, func(state *commandState) {
+		state.status.ExitCode = &exitCode
+	})
+}
+
+// failedStart records that a process of the named command exited before min_uptime.
+func (r *Runner) failedStart(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if state, ok := r.states[name]; ok {
+		state.status.FailedStarts++
+	}
+}
+
+// restarting records that the named command is about to be restarted.
+func (r *Runner) restarting(name string) {
+	r.update(name, stateRestarting, func(state *commandState) {
+		state.status.Restarts++
+	})
+}
+
+// update moves the named command to state, applying change to what it keeps.
+// Processes only run in the running state.
+func (r *Runner) update(name string, state string, change func(state *commandState)) {
+	now := r.Clock.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.states[name]
+	if !ok {
+		return
+	}
+	if current.status.State != state {
+		current.status.State = state
+		current.status.Since = now
+	}
+	if state != stateRunning {
+		current.status.Pid = 0
+		current.gate = nil
+	}
+	if change != nil {
+		change(current)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	runner := &Runner{Clock: clock}
+	web := Command{Name: "web", RestartWith: []string{"worker"}}
+	worker := Command{Name: "worker", Args: []string{"$(web.stdout)"}}
+	runner.Apps = []Command{web, worker}
+
+	gate, err := newReadyGate(Command{ReadyWhen: "listening"})
+	assert.NoError(t, err)
+	runner.track(web)
+	runner.running("web", 42, gate)
+
+	snapshot := runner.Snapshot()
+	assert.Equal(t, []CommandStatus{
+		{Name: "web", State: stateRunning, Since: clock.Now(), Pid: 42, RestartWith: []string{"worker"}},
+		{Name: "worker", State: statePending, DependsOn: []string{"web"}},
+	}, snapshot)
+
+	// The snapshot is a copy
+	snapshot[0].RestartWith[0] = "changed"
+	assert.Equal(t, []string{"worker"}, runner.Snapshot()[0].RestartWith)
+
+	gate.check("listening", realClock{}, make(chan Message, 1), &web)
+	assert.True(t, runner.Snapshot()[0].Ready)
+
+	clock.Advance(time.Second)
+	runner.exited("web", 3)
+	runner.restarting("web")
+	status := runner.Snapshot()[0]
+	assert.Equal(t, stateRestarting, status.State)
+	assert.Equal(t, clock.Now(), status.Since)
+	assert.Zero(t, status.Pid)
+	assert.False(t, status.Ready)
+	assert.Equal(t, 1, status.Restarts)
+	assert.Equal(t, 3, *status.ExitCode)
+}
+
+func TestSnapshotExecute(t *testing.T) {
+	runner := NewRunner()
+	outputChan := make(chan Message, 2)
+	runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{Name: "exit", Command: "sh", Args: []string{"-c", "exit 3"}})
+	streamLogs(outputChan, 1, func(message Message) {})
+
+	status := runner.Snapshot()[0]
+	assert.Equal(t, stateExited, status.State)
+	assert.Equal(t, 3, *status.ExitCode)
+}
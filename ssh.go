@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshFailed is the exit status of the ssh subcommand when it couldn't run the
+// remote command at all, like the one of the ssh client.
+const sshFailed = 255
+
+// sshErrorsFD is the file descriptor the ssh subcommand reports its own errors
+// on, so that they can't be mistaken for the output or the exit status of the
+// remote command. Without it they go to stderr.
+const sshErrorsFD = 3
+
+// sshDialTimeout bounds connecting and authenticating to a host.
+const sshDialTimeout = 30 * time.Second
+
+// sshKeyFiles are the private keys in ~/.ssh tried after the ones of the agent.
+var sshKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sshTarget is a host to run commands on, from a host like "deploy@example.com:2222".
+type sshTarget struct {
+	user string
+	// addr is the host and port to connect to.
+	addr string
+}
+
+// parseSSHTarget parses a [user@]host[:port] host. The user defaults to the
+// current one and the port to 22.
+func parseSSHTarget(host string) (sshTarget, error) {
+	var target sshTarget
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		target.user, host = host[:i], host[i+1:]
+	}
+	if host == "" {
+		return target, errors.New("missing host name")
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		target.addr = host
+	} else {
+		target.addr = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	if target.user == "" {
+		current, err := user.Current()
+		if err != nil {
+			return target, fmt.Errorf("error getting the current user: %w", err)
+		}
+		target.user = current.Username
+	}
+	return target, nil
+}
+
+// sshClientConfig returns the config connecting to target the way ssh does in
+// batch mode: authenticating with the keys of the agent at $SSH_AUTH_SOCK and
+// the unencrypted keys in ~/.ssh, and verifying the host against
+// ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts, without prompting. The
+// returned function releases the agent.
+func sshClientConfig(target sshTarget) (*ssh.ClientConfig, func(), error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() {}
+
+	var methods []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			release = func() { conn.Close() }
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range sshKeyFiles {
+		key, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		// Keys with a passphrase would need a prompt
+		if signer, err := ssh.ParsePrivateKey(key); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	var files []string
+	for _, path := range []string{filepath.Join(home, ".ssh", "known_hosts"), "/etc/ssh/ssh_known_hosts"} {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
+		release()
+		return nil, nil, fmt.Errorf("no known_hosts file to verify %s with", target.addr)
+	}
+	hostKeys, err := knownhosts.New(files...)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              target.user,
+		Auth:              methods,
+		HostKeyCallback:   hostKeys,
+		HostKeyAlgorithms: knownHostKeyAlgorithms(hostKeys, target.addr),
+		Timeout:           sshDialTimeout,
+	}, release, nil
+}
+
+// knownHostKeyAlgorithms returns the algorithms of the keys known for addr, so
+// that the host is asked for a key it can be verified with rather than the
+// first one both sides support. It returns nil, any algorithm, for unknown hosts.
+func knownHostKeyAlgorithms(hostKeys ssh.HostKeyCallback, addr string) []string {
+	// A key nobody knows makes the callback list the known ones
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil
+	}
+	placeholder, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(hostKeys(addr, &net.TCPAddr{}, placeholder), &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	return algorithms
+}
+
+// forwardedSignals are the signals the ssh subcommand passes on to the remote
+// command, like the stop_signal and reload_signal of its app.
+func forwardedSignals() []os.Signal {
+	var signals []os.Signal
+	for _, names := range []map[string]syscall.Signal{signalNames, platformSignals} {
+		for _, value := range names {
+			if value != syscall.SIGKILL {
+				signals = append(signals, value)
+			}
+		}
+	}
+	return signals
+}
+
+// runSSH implements the ssh subcommand, "psmgmt ssh <host> -- <command>", which
+// runs the command line on the host and exits with its exit status.
+// Apps with a host run through it. Its own errors, which exit with sshFailed,
+// are reported on sshErrorsFD.
+func runSSH(args []string) int {
+	if len(args) != 3 || args[1] != "--" {
+		return reportSSHError(errors.New("usage: psmgmt ssh <host> -- <command>"))
+	}
+	target, err := parseSSHTarget(args[0])
+	if err != nil {
+		return reportSSHError(err)
+	}
+	config, release, err := sshClientConfig(target)
+	if err != nil {
+		return reportSSHError(err)
+	}
+	defer release()
+	client, err := ssh.Dial("tcp", target.addr, config)
+	if err != nil {
+		return reportSSHError(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return reportSSHError(err)
+	}
+	defer session.Close()
+
+	// Wait only has to wait for the output, not for stdin to end
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return reportSSHError(err)
+	}
+	go func() {
+		io.Copy(stdin, os.Stdin)
+		stdin.Close()
+	}()
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals()...)
+	defer signal.Stop(signals)
+	go func() {
+		for received := range signals {
+			if value, ok := received.(syscall.Signal); ok {
+				session.Signal(ssh.Signal(strings.TrimPrefix(signalName(value), "SIG")))
+			}
+		}
+	}()
+
+	err = session.Run(args[2])
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exitErr) && exitErr.Signal() != "":
+		// Like a shell reports a command killed by a signal
+		if value, err := parseSignal(exitErr.Signal()); err == nil {
+			return 128 + int(value)
+		}
+		return reportSSHError(fmt.Errorf("remote command killed by signal %s", exitErr.Signal()))
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus()
+	}
+	return reportSSHError(err)
+}
+
+// reportSSHError reports an error of the ssh subcommand on sshErrorsFD, or on
+// stderr if it isn't open, and returns the exit status it exits with.
+func reportSSHError(err error) int {
+	report := os.NewFile(sshErrorsFD, "ssh errors")
+	if _, writeErr := fmt.Fprintln(report, err); writeErr != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return sshFailed
+}
+
+// sshErrors returns a pipe whose write end becomes sshErrorsFD of the ssh
+// subcommand run by cmd, adding it to writeEnds like outputPipe. Read the
+// errors with readSSHErrors. Windows passes no extra files to processes, so
+// there the errors show on stderr and nil is returned.
+func sshErrors(cmd *exec.Cmd, writeEnds *[]*os.File) (*os.File, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+	r, w, err := outputPipe(writeEnds)
+	if err != nil {
+		return nil, err
+	}
+	// The first of the extra files is fd 3
+	cmd.ExtraFiles = []*os.File{w}
+	return r, nil
+}
+
+// readSSHErrors returns what the ssh subcommand reported once it exited, empty
+// if it ran the remote command or r is nil, for apps without a host.
+func readSSHErrors(r *os.File) string {
+	if r == nil {
+		return ""
+	}
+	content, _ := io.ReadAll(r)
+	return strings.TrimSpace(string(content))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestMain(m *testing.M) {
+	// The test binary stands in for psmgmt running the ssh subcommand of remote apps
+	if len(os.Args) > 1 && os.Args[1] == "ssh" {
+		os.Exit(runSSH(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+func TestParseSSHTarget(t *testing.T) {
+	for _, test := range []struct {
+		host string
+		user string
+		addr string
+	}{
+		{"deploy@example.com", "deploy", "example.com:22"},
+		{"deploy@example.com:2222", "deploy", "example.com:2222"},
+		{"deploy@[::1]:2222", "deploy", "[::1]:2222"},
+		{"deploy@::1", "deploy", "[::1]:22"},
+	} {
+		target, err := parseSSHTarget(test.host)
+		assert.NoError(t, err, test.host)
+		assert.Equal(t, sshTarget{user: test.user, addr: test.addr}, target, test.host)
+	}
+
+	target, err := parseSSHTarget("example.com")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, target.user)
+
+	_, err = parseSSHTarget("deploy@")
+	assert.EqualError(t, err, "missing host name")
+}
+
+// startSSHServer starts an SSH server running the commands of the client key
+// with sh, and sets up a home directory with the client key and the server in
+// its known_hosts. It returns the address of the server.
+func startSSHServer(t *testing.T) string {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	assert.NoError(t, err)
+	clientPublic, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	addr := listener.Addr().String()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+	assert.NoError(t, os.Mkdir(filepath.Join(home, ".ssh"), 0o700))
+	block, err := ssh.MarshalPrivateKey(clientKey, "")
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(block), 0o600))
+	knownHosts := knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostSigner.PublicKey()) + "\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(knownHosts), 0o600))
+	return addr
+}
+
+// serveSSH runs the sessions of an SSH connection.
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSSHSession(channel, requests)
+	}
+}
+
+// serveSSHSession runs the command of a session with sh, passing on signals to
+// its process group, like to the user's login shell and the command it runs.
+func serveSSHSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	var mu sync.Mutex
+	var cmd *exec.Cmd
+	for request := range requests {
+		switch request.Type {
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(request.Payload, &payload)
+			mu.Lock()
+			cmd = exec.Command("sh", "-c", payload.Command)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+			setProcessGroup(cmd)
+			err := cmd.Start()
+			mu.Unlock()
+			request.Reply(err == nil, nil)
+			go func() {
+				cmd.Wait()
+				status := struct{ Status uint32 }{uint32(cmd.ProcessState.ExitCode())}
+				channel.SendRequest("exit-status", false, ssh.Marshal(&status))
+				channel.Close()
+			}()
+		case "signal":
+			var payload struct{ Signal string }
+			ssh.Unmarshal(request.Payload, &payload)
+			mu.Lock()
+			if signal, err := parseSignal(payload.Signal); err == nil && cmd != nil {
+				terminate(cmd.Process, signal)
+			}
+			mu.Unlock()
+		default:
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// executeRemote runs the command on host and returns its output lines and errors.
+func executeRemote(ctx context.Context, command Command) (stdout []string, errors []string, exitCode int) {
+	outputChan := make(chan Message, 10)
+	Execute(ctx, new(sync.WaitGroup), outputChan, command)
+	streamLogs(outputChan, 1, func(message Message) {
+		switch message.Type {
+		case OutputStdout:
+			stdout = append(stdout, message.Content)
+		case SystemError:
+			errors = append(errors, message.Content)
+		case OutputEnd:
+			exitCode = message.ExitCode
+		}
+	})
+	return stdout, errors, exitCode
+}
+
+func TestExecuteRemote(t *testing.T) {
+	addr := startSSHServer(t)
+	host := "deploy@" + addr
+
+	// A remote command exiting with 255 is no connection failure
+	stdout, errors, exitCode := executeRemote(context.Background(), Command{Name: "job", Command: "sh", Args: []string{"-c", "echo hello; exit 255"}, Host: host})
+	assert.Equal(t, []string{"hello"}, stdout)
+	assert.Equal(t, []string{"error waiting for command: exit status 255"}, errors)
+	assert.Equal(t, 255, exitCode)
+
+	// The stop signal reaches the remote command
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(time.Second)
+		cancel()
+	}()
+	stdout, errors, _ = executeRemote(ctx, Command{Name: "web", Command: "sh", Args: []string{"-c", "trap 'echo bye; exit 0' TERM; echo up; while :; do sleep 0.1; done"}, Host: host})
+	assert.Equal(t, []string{"up", "bye"}, stdout)
+	assert.Empty(t, errors)
+
+	// Hosts that can't be reached or verified fail the connection
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	closed := listener.Addr().String()
+	listener.Close()
+	_, errors, exitCode = executeRemote(context.Background(), Command{Name: "job", Command: "true", Host: "deploy@" + closed})
+	assert.Len(t, errors, 1)
+	assert.Contains(t, errors[0], "error running command on deploy@"+closed+": ssh connection failed: dial tcp "+closed+":")
+	assert.Equal(t, sshFailed, exitCode)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(os.Getenv("HOME"), ".ssh", "known_hosts"), nil, 0o600))
+	_, errors, _ = executeRemote(context.Background(), Command{Name: "job", Command: "true", Host: host})
+	assert.Len(t, errors, 1)
+	assert.Contains(t, errors[0], "ssh connection failed: ssh: handshake failed: knownhosts: key is unknown")
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI escape sequences used for colored output.
+const (
+	ansiReset = "\x1b[0m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+)
+
+// statusPrinter renders lifecycle messages as systemd-style status lines such as
+// "[ OK ] Started web" or "[FAIL] web exited (...)".
+// A command is reported as failed if it produced a failed SystemError before its OutputEnd.
+type statusPrinter struct {
+	color    bool
+	failures map[string]string
+}
+
+// newStatusPrinter returns a statusPrinter, coloring the OK/FAIL markers if color is set.
+func newStatusPrinter(color bool) *statusPrinter {
+	return &statusPrinter{
+		color:    color,
+		failures: make(map[string]string),
+	}
+}
+
+// format returns the status line for a lifecycle message, which is empty if the
+// message is consumed without printing anything.
+// It returns false for messages that are not rendered as status lines.
+func (p *statusPrinter) format(message Message) (string, bool) {
+	name := message.CommandName()
+	switch message.Type {
+	case OutputStart:
+		// The command is only reported as started once its process runs
+		delete(p.failures, name)
+		return "", true
+	case OutputRunning:
+		return p.ok(fmt.Sprintf("Started %s (pid %d)", name, message.Pid)), true
+	case OutputReady:
+		return p.ok(name + " is ready"), true
+	case SystemError:
+		// Remember the first error as the reason the command failed
+		if message.Command != nil && message.Failed {
+			if _, failed := p.failures[name]; !failed {
+				p.failures[name] = message.Content
+			}
+		}
+		return "", false
+	case OutputEnd:
+		reason, failed := p.failures[name]
+		delete(p.failures, name)
+		if failed {
+			return p.fail(fmt.Sprintf("%s exited (%s)", name, reason)), true
+		}
+		return p.ok("Stopped " + name), true
+	}
+	return "", false
+}
+
+// ok returns text prefixed with an OK marker.
+func (p *statusPrinter) ok(text string) string {
+	if p.color {
+		return "[" + ansiGreen + " OK " + ansiReset + "] " + text
+	}
+	return "[ OK ] " + text
+}
+
+// fail returns text prefixed with a FAIL marker.
+func (p *statusPrinter) fail(text string) string {
+	if p.color {
+		return "[" + ansiRed + "FAIL" + ansiReset + "] " + text
+	}
+	return "[FAIL] " + text
+}
+
+// isTerminal reports whether f is a terminal, as opposed to a file or a pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPrinter(t *testing.T) {
+	printer := newStatusPrinter(false)
+	web := &Command{Name: "web"}
+
+	line, ok := printer.format(Message{Type: OutputStart, Command: web})
+	assert.True(t, ok)
+	assert.Empty(t, line)
+
+	line, ok = printer.format(Message{Type: OutputRunning, Command: web, Pid: 42})
+	assert.True(t, ok)
+	assert.Equal(t, "[ OK ] Started web (pid 42)", line)
+
+	_, ok = printer.format(Message{Type: OutputStdout, Content: "hello", Command: web})
+	assert.False(t, ok)
+
+	_, ok = printer.format(Message{Type: SystemError, Content: "error waiting for command: exit status 1", Command: web, Failed: true})
+	assert.False(t, ok)
+
+	line, ok = printer.format(Message{Type: OutputEnd, Command: web})
+	assert.True(t, ok)
+	assert.Equal(t, "[FAIL] web exited (error waiting for command: exit status 1)", line)
+
+	printer.format(Message{Type: OutputStart, Command: web})
+	line, _ = printer.format(Message{Type: OutputEnd, Command: web})
+	assert.Equal(t, "[ OK ] Stopped web", line)
+}
+
+func TestStatusPrinterColor(t *testing.T) {
+	printer := newStatusPrinter(true)
+
+	line, _ := printer.format(Message{Type: OutputRunning, Command: &Command{Name: "web"}, Pid: 42})
+	assert.Equal(t, "[\x1b[32m OK \x1b[0m] Started web (pid 42)", line)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"text/template"
+)
+
+// stdinData is what stdin templates are rendered with.
+type stdinData struct {
+	// App is the command being started, with its config values.
+	App Command
+	// Env is the environment the process is started with.
+	Env map[string]string
+}
+
+// parseStdinTemplate parses the stdin_template of a command.
+func parseStdinTemplate(text string) (*template.Template, error) {
+	return template.New("stdin_template").Option("missingkey=error").Parse(text)
+}
+
+// renderStdin renders the stdin_template of the command with the environment
+// of its process, a list of KEY=value entries.
+func renderStdin(command Command, env []string) (string, error) {
+	tmpl, err := parseStdinTemplate(command.StdinTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	data := stdinData{App: command, Env: make(map[string]string, len(env))}
+	for _, entry := range env {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			data.Env[key] = value
+		}
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// writeStdin writes content to the stdin of the command's process and closes
+// it, closing the returned channel once done. A process exiting before reading all of it is not an error: it closes
+// its end, and the write fails with a broken pipe or, once the process has
+// been waited for, a closed file.
+func writeStdin(clock Clock, outputChan chan<- Message, command Command, stdin io.WriteCloser, content string) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.WriteString(stdin, content)
+		if closeErr := stdin.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, os.ErrClosed) {
+			send(clock, outputChan, Message{
+				Content: fmt.Errorf("error writing stdin: %w", err).Error(),
+				Type:    SystemError,
+				Command: &command,
+				Failed:  true,
+			})
+		}
+	}()
+	return done
+}
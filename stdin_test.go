@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderStdin(t *testing.T) {
+	command := Command{
+		Name:          "migrate",
+		StdinTemplate: "\\connect {{.Env.DATABASE}}\n-- run by {{.App.Name}}\n",
+	}
+	stdin, err := renderStdin(command, []string{"DATABASE=app", "EMPTY="})
+	assert.NoError(t, err)
+	assert.Equal(t, "\\connect app\n-- run by migrate\n", stdin)
+
+	_, err = renderStdin(command, nil)
+	assert.ErrorContains(t, err, `map has no entry for key "DATABASE"`)
+}
+
+func TestExecuteStdinTemplate(t *testing.T) {
+	t.Setenv("GREETING", "hello")
+
+	for _, template := range []string{"echo {{.Env.GREETING}} from {{.App.Name}}", "{{.Env.MISSING}}"} {
+		outputChan := make(chan Message, 10)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+			Name:          "script",
+			Command:       "sh",
+			StdinTemplate: template,
+		})
+
+		var stdout, errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputStdout:
+				stdout = append(stdout, message.Content)
+			case SystemError:
+				errors = append(errors, message.Content)
+			}
+		})
+
+		if template == "{{.Env.MISSING}}" {
+			assert.Empty(t, stdout)
+			assert.Len(t, errors, 1)
+			assert.Contains(t, errors[0], "error rendering stdin_template:")
+		} else {
+			assert.Equal(t, []string{"hello from script"}, stdout)
+			assert.Empty(t, errors)
+		}
+	}
+}
+
+func TestExecuteStdin(t *testing.T) {
+	for _, command := range []Command{
+		{Name: "cat", Command: "cat", Stdin: "first\nsecond\n"},
+		// Exiting without reading stdin breaks the pipe, which is fine
+		{Name: "true", Command: "true", Stdin: strings.Repeat("unread\n", 1<<16)},
+	} {
+		outputChan := make(chan Message, 10)
+		Execute(context.Background(), new(sync.WaitGroup), outputChan, command)
+
+		var stdout, errors []string
+		streamLogs(outputChan, 1, func(message Message) {
+			switch message.Type {
+			case OutputStdout:
+				stdout = append(stdout, message.Content)
+			case SystemError:
+				errors = append(errors, message.Content)
+			}
+		})
+
+		if command.Name == "cat" {
+			assert.Equal(t, []string{"first", "second"}, stdout)
+		} else {
+			assert.Empty(t, stdout)
+		}
+		assert.Empty(t, errors, command.Name)
+	}
+}
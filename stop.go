@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// defaultStopTimeout is the time a command has to exit after SIGTERM on shutdown
+// before it is killed, unless it or the config's shutdown_timeout sets another.
+const defaultStopTimeout = 10 * time.Second
+
+// stopTimeout returns the time the command has to stop gracefully.
+func stopTimeout(command Command) time.Duration {
+	if command.StopTimeout > 0 {
+		return command.StopTimeout
+	}
+	return defaultStopTimeout
+}
+
+// stopSignal returns the signal that asks the command's process to exit gracefully,
+// its stop_signal or else SIGTERM.
+func stopSignal(command Command) syscall.Signal {
+	if command.StopSignal != "" {
+		if signal, err := parseSignal(command.StopSignal); err == nil {
+			return signal
+		}
+	}
+	return syscall.SIGTERM
+}
+
+// wasKilled reports whether the process ended by SIGKILL.
+func wasKilled(state *os.ProcessState) bool {
+	if state == nil {
+		return false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == syscall.SIGKILL
+}
+
+// wasSignaled reports whether the process was terminated by a signal, like
+// the stop signal psmgmt sent it.
+func wasSignaled(state *os.ProcessState) bool {
+	if state == nil {
+		return false
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled()
+}
+
+// stopReport collects how the commands stopped on shutdown for the final report.
+type stopReport struct {
+	lines []string
+}
+
+// observe records OutputStopped messages.
+func (r *stopReport) observe(message Message) {
+	if message.Type == OutputStopped {
+		r.lines = append(r.lines, fmt.Sprintf("%s: %s", message.CommandName(), message.Content))
+	}
+}
+
+// print logs how every command stopped, sorted by name, if any did on shutdown.
+func (r *stopReport) print(logf func(format string, args ...any)) {
+	if len(r.lines) == 0 {
+		return
+	}
+	sort.Strings(r.lines)
+	logf("shutdown report:")
+	for _, line := range r.lines {
+		logf("  %s", line)
+	}
+}
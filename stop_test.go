@@ -0,0 +1,18 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopSignal(t *testing.T) {
+	assert.Equal(t, syscall.SIGTERM, stopSignal(Command{}))
+	assert.Equal(t, syscall.SIGQUIT, stopSignal(Command{StopSignal: "SIGQUIT"}))
+	assert.Equal(t, syscall.SIGHUP, stopSignal(Command{StopSignal: "hup"}))
+	assert.Equal(t, syscall.SIGINT, stopSignal(Command{StopSignal: "INT"}))
+
+	_, err := parseSignal("SIGNOPE")
+	assert.EqualError(t, err, `unknown signal "SIGNOPE"`)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamCounter counts the lines and bytes a command wrote to one of its streams.
+// A nil streamCounter counts nothing.
+type streamCounter struct {
+	lines atomic.Uint64
+	bytes atomic.Uint64
+}
+
+// add counts a line of size bytes, not counting the line break.
+func (c *streamCounter) add(size int) {
+	if c == nil {
+		return
+	}
+	c.lines.Add(1)
+	c.bytes.Add(uint64(size))
+}
+
+// commandThroughput counts the output of a command since it first started.
+type commandThroughput struct {
+	since  time.Time
+	stdout streamCounter
+	stderr streamCounter
+}
+
+// stream returns the counter of the stream carrying messages of the given type,
+// or nil for a nil commandThroughput.
+func (c *commandThroughput) stream(messageType MessageType) *streamCounter {
+	if c == nil {
+		return nil
+	}
+	if messageType == OutputStderr {
+		return &c.stderr
+	}
+	return &c.stdout
+}
+
+// ThroughputStats is the output volume of a command.
+type ThroughputStats struct {
+	Name           string
+	StdoutLines    uint64
+	StdoutBytes    uint64
+	StderrLines    uint64
+	StderrBytes    uint64
+	LinesPerSecond float64
+}
+
+// throughput keeps the output counters of every command of a Runner.
+type throughput struct {
+	clock    Clock
+	mu       sync.Mutex
+	commands map[string]*commandThroughput
+}
+
+// newThroughput returns empty counters measuring rates with clock.
+func newThroughput(clock Clock) *throughput {
+	return &throughput{clock: clock, commands: make(map[string]*commandThroughput)}
+}
+
+// command returns the counters of the named command, created on first use.
+func (t *throughput) command(name string) *commandThroughput {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counters, ok := t.commands[name]
+	if !ok {
+		counters = &commandThroughput{since: t.clock.Now()}
+		t.commands[name] = counters
+	}
+	return counters
+}
+
+// stats returns the output volume of every command so far, sorted by name.
+func (t *throughput) stats() []ThroughputStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.clock.Now()
+	stats := make([]ThroughputStats, 0, len(t.commands))
+	for name, counters := range t.commands {
+		stat := ThroughputStats{
+			Name:        name,
+			StdoutLines: counters.stdout.lines.Load(),
+			StdoutBytes: counters.stdout.bytes.Load(),
+			StderrLines: counters.stderr.lines.Load(),
+			StderrBytes: counters.stderr.bytes.Load(),
+		}
+		if elapsed := now.Sub(counters.since).Seconds(); elapsed > 0 {
+			stat.LinesPerSecond = float64(stat.StdoutLines+stat.StderrLines) / elapsed
+		}
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// String formats the stats as a line of the final summary.
+func (s ThroughputStats) String() string {
+	return fmt.Sprintf("%s: %d lines (%d bytes) on stdout, %d lines (%d bytes) on stderr, %.1f lines/s",
+		s.Name, s.StdoutLines, s.StdoutBytes, s.StderrLines, s.StderrBytes, s.LinesPerSecond)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThroughput(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	counters := newThroughput(clock)
+
+	web := counters.command("web")
+	web.stream(OutputStdout).add(5)
+	web.stream(OutputStdout).add(3)
+	web.stream(OutputStderr).add(10)
+	assert.Same(t, web, counters.command("web"))
+	counters.command("idle")
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, []ThroughputStats{
+		{Name: "idle"},
+		{Name: "web", StdoutLines: 2, StdoutBytes: 8, StderrLines: 1, StderrBytes: 10, LinesPerSecond: 1.5},
+	}, counters.stats())
+	assert.Equal(t, "web: 2 lines (8 bytes) on stdout, 1 lines (10 bytes) on stderr, 1.5 lines/s", counters.stats()[1].String())
+
+	// Nil counters count nothing
+	var none *commandThroughput
+	none.stream(OutputStdout).add(1)
+}
+
+func TestExecuteThroughput(t *testing.T) {
+	runner := NewRunner()
+	outputChan := make(chan Message, 10)
+	runner.Execute(context.Background(), new(sync.WaitGroup), outputChan, Command{
+		Name:    "chatty",
+		Command: "sh",
+		Args:    []string{"-c", "echo hello; echo world; echo oops >&2"},
+	})
+	streamLogs(outputChan, 1, func(message Message) {})
+
+	stats := runner.Throughput()
+	assert.Len(t, stats, 1)
+	assert.Equal(t, uint64(2), stats[0].StdoutLines)
+	assert.Equal(t, uint64(10), stats[0].StdoutBytes)
+	assert.Equal(t, uint64(1), stats[0].StderrLines)
+	assert.Equal(t, uint64(4), stats[0].StderrBytes)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// resolvePipeThrough returns the executable of the command's pipe_through,
+// searched for in its path like the command itself, or else in $PATH.
+func resolvePipeThrough(command Command) (string, error) {
+	name := command.PipeThrough[0]
+	if name == "" {
+		return "", errors.New("pipe_through requires a command")
+	}
+	if len(command.Path) > 0 {
+		return lookPath(name, command.Path)
+	}
+	return exec.LookPath(name)
+}
+
+// startTransform starts the pipe_through command of the given command and connects
+// cmd's stdout to its stdin. The transform's stdout and stderr are captured in place
+// of the command's, with stdout checked against the ready gate, and counted by counters.
+// It returns a function that closes the transform's input and waits for it to exit and
+// its output to be read. The transform isn't stopped along with the command, so that
+// it gets to pass on the command's last lines: it runs in a process group of its own
+// with the command's environment and only gets the command's stop_signal, and then
+// is killed, if it doesn't exit within the command's stop_timeout of its input closing.
+func startTransform(cmd *exec.Cmd, clock Clock, outputChan chan<- Message, command Command, gate *readyGate, counters *commandThroughput) (func() error, error) {
+	transform := exec.Command(command.PipeThrough[0], command.PipeThrough[1:]...)
+	transform.Env = cmd.Env
+	transform.Dir = cmd.Dir
+	setProcessGroup(transform)
+
+	stdin, err := transform.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating StdinPipe: %w", err)
+	}
+
+	// Use plain pipes for the transform's output rather than StdoutPipe/StderrPipe:
+	// transform.Wait would close those before the last lines are read, while these
+	// are read until the transform's copy of the write end is closed at exit
+	stdout, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("error creating stdout pipe: %w", err)
+	}
+	stderr, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdout.Close()
+		stdoutWriter.Close()
+		return nil, fmt.Errorf("error creating stderr pipe: %w", err)
+	}
+	transform.Stdout = stdoutWriter
+	transform.Stderr = stderrWriter
+
+	err = transform.Start()
+	stdoutWriter.Close()
+	stderrWriter.Close()
+	if err != nil {
+		stdout.Close()
+		stderr.Close()
+		return nil, err
+	}
+	stdoutDone := captureOutput(stdout, clock, outputChan, command, OutputStdout, gate, counters.stream(OutputStdout))
+	stderrDone := captureOutput(stderr, clock, outputChan, command, OutputStderr, nil, counters.stream(OutputStderr))
+
+	// The command writes into the transform; closing stdin once the command
+	// has exited lets the transform see EOF and finish
+	cmd.Stdout = stdin
+	wait := func() error {
+		stdin.Close()
+		exited := make(chan error, 1)
+		go func() { exited <- transform.Wait() }()
+
+		timeout := stopTimeout(command)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		var err error
+		select {
+		case err = <-exited:
+		case <-timer.C:
+			_ = terminate(transform.Process, stopSignal(command))
+			timer.Reset(timeout)
+			select {
+			case <-exited:
+			case <-timer.C:
+				_ = transform.Process.Kill()
+				<-exited
+			}
+			err = fmt.Errorf("did not exit within stop_timeout of %s after its input closed, stopped", timeout)
+		}
+		killGroup(transform.Process)
+		<-stdoutDone
+		<-stderrDone
+		return err
+	}
+	return wait, nil
+}
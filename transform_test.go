@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutePipeThrough(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	command := Command{
+		Name:        "quoted",
+		Command:     "sh",
+		Args:        []string{"-c", "echo hello; echo world; sleep 0.2"},
+		PipeThrough: []string{"sh", "-c", "while read line; do echo \"<$line>\"; done"},
+	}
+
+	outputChan := make(chan Message, 10)
+	Execute(ctx, new(sync.WaitGroup), outputChan, command)
+
+	stdout := make([]string, 0)
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputStdout {
+			stdout = append(stdout, message.Content)
+		}
+	})
+
+	assert.Equal(t, []string{"<hello>", "<world>"}, stdout)
+}
+
+func TestParseConfigPipeThrough(t *testing.T) {
+	config, err := parseConfig(strings.NewReader("version: \"1\"\napps:\n  - name: web\n    command: web\n    pipe_through: [sh, -c, cat]\n"))
+	assert.NoError(t, err)
+	sh, err := exec.LookPath("sh")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{sh, "-c", "cat"}, config.Apps[0].PipeThrough)
+}
+
+func TestExecutePipeThroughStop(t *testing.T) {
+	// The transform outlives the command to pass on its last lines
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	command := Command{
+		Name:        "quoted",
+		Command:     "sh",
+		Args:        []string{"-c", "trap 'echo bye; exit' TERM; echo hello; sleep 10 & wait"},
+		PipeThrough: []string{"sh", "-c", "trap '' TERM; while read line; do sleep 0.1; echo \"<$line>\"; done; echo end"},
+	}
+	outputChan := make(chan Message, 10)
+	Execute(ctx, new(sync.WaitGroup), outputChan, command)
+
+	var stdout []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == OutputRunning {
+			time.AfterFunc(200*time.Millisecond, cancel)
+		}
+		if message.Type == OutputStdout {
+			stdout = append(stdout, message.Content)
+		}
+	})
+	assert.Equal(t, []string{"<hello>", "<bye>", "end"}, stdout)
+
+	// A transform that doesn't exit once its input closed is stopped, then killed
+	command.Args = []string{"-c", "echo hello"}
+	command.PipeThrough = []string{"sh", "-c", "trap '' TERM; cat >/dev/null; sleep 10"}
+	command.StopTimeout = 100 * time.Millisecond
+	outputChan = make(chan Message, 10)
+	started := time.Now()
+	Execute(context.Background(), new(sync.WaitGroup), outputChan, command)
+	var errors []string
+	streamLogs(outputChan, 1, func(message Message) {
+		if message.Type == SystemError {
+			errors = append(errors, message.Content)
+		}
+	})
+	assert.Equal(t, []string{"error waiting for pipe_through command: did not exit within stop_timeout of 100ms after its input closed, stopped"}, errors)
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// webAssets holds the page of the log viewer.
+//
+//go:embed web
+var webAssets embed.FS
+
+// webClientBuffer is the number of messages buffered per viewer before messages
+// are dropped for it, so that a slow browser never holds up the commands.
+const webClientBuffer = 256
+
+// webViewer is a Sink serving a page in the browser that streams the messages
+// live over server-sent events.
+type webViewer struct {
+	server   *http.Server
+	listener net.Listener
+	clock    Clock
+	snapshot func() []CommandStatus
+
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+// newWebViewer starts serving the log viewer on addr, e.g. ":8080". The state of
+// the commands taken with snapshot is served as JSON on /status.
+func newWebViewer(addr string, clock Clock, snapshot func() []CommandStatus) (*webViewer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting web viewer: %w", err)
+	}
+
+	viewer := &webViewer{
+		listener: listener,
+		clock:    clock,
+		snapshot: snapshot,
+		clients:  make(map[chan []byte]struct{}),
+	}
+	assets, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+	mux.HandleFunc("/events", viewer.serveEvents)
+	mux.HandleFunc("/status", viewer.serveStatus)
+	viewer.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := viewer.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logSystem("SystemError", "error serving web viewer: %v", err)
+		}
+	}()
+	return viewer, nil
+}
+
+// serveEvents streams the messages to a single viewer until it goes away.
+func (v *webViewer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events := make(chan []byte, webClientBuffer)
+	v.mu.Lock()
+	v.clients[events] = struct{}{}
+	v.mu.Unlock()
+	defer func() {
+		v.mu.Lock()
+		delete(v.clients, events)
+		v.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-events:
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// serveStatus responds with the state of every command.
+func (v *webViewer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v.snapshot()); err != nil {
+		logSystem("SystemError", "error serving status: %v", err)
+	}
+}
+
+// Write sends the message to every connected viewer, dropping it for the ones
+// that fall behind.
+func (v *webViewer) Write(message Message) error {
+	event, err := json.Marshal(newAuditRecord(message, v.clock.Now()))
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for client := range v.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close stops serving the log viewer and disconnects the viewers.
+func (v *webViewer) Close() error {
+	return v.server.Close()
+}
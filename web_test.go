@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebViewer(t *testing.T) {
+	snapshot := func() []CommandStatus {
+		return []CommandStatus{{Name: "web", State: stateRunning, Pid: 42, Ready: true}}
+	}
+	viewer, err := newWebViewer("127.0.0.1:0", realClock{}, snapshot)
+	assert.NoError(t, err)
+	defer viewer.Close()
+	base := "http://" + viewer.listener.Addr().String()
+
+	// The page is served from the embedded assets
+	resp, err := http.Get(base + "/")
+	assert.NoError(t, err)
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(page), "EventSource")
+
+	// The state of the commands is served as JSON
+	resp, err = http.Get(base + "/status")
+	assert.NoError(t, err)
+	var statuses []CommandStatus
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&statuses))
+	resp.Body.Close()
+	assert.Equal(t, snapshot(), statuses)
+
+	// Messages are streamed to connected viewers
+	resp, err = http.Get(base + "/events")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The viewer is registered once the response headers are sent
+	assert.Eventually(t, func() bool {
+		viewer.mu.Lock()
+		defer viewer.mu.Unlock()
+		return len(viewer.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.NoError(t, viewer.Write(Message{Content: "hello", Type: OutputStdout, Command: &Command{Name: "web"}}))
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "data: {"))
+	assert.Contains(t, line, `"command":"web"`)
+	assert.Contains(t, line, `"content":"hello"`)
+}